@@ -4,28 +4,38 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
-	pb "github.com/hyperledger/fabric-protos-go/peer"
-	"strconv"
-	"strings"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 /*
  * 企业账户积分合约实现：
  * 1. 登录数据平台账户基本信息管理
  * 2. 账户积分管理
+ * 3. 账户注销：锁定积分退回后才能注销，积分结清或转入指定账户，下架名下标签，保留注销记录防止名称重用，交易记录保留备查
  */
 
+// contractapi v1.0.0 不识别 json 标签中的 omitempty，可选字段通过 metadata 标签声明
 type Account struct {
-	Name     string `json:"name"`     /*账户名称*/
-	Password string `json:"password"` /*账户基本信息*/
-	Type     int    `json:"type"`     /*账户类别：企业、政府*/
-	OrgName  string `json:"orgName"`  /*企业或组织名称*/
-	Address  string `json:"address"`  /*账户地址*/
-	Frozen   bool   `json:"-"`        /*账户停用标记*/
-	Token    int64  `json:"token"`    /*账户积分*/
+	Name     string `json:"name" validate:"required,max=64,charset=name"`                        /*账户名称*/
+	Password string `json:"password" validate:"required,max=128"`                                /*账户基本信息*/
+	Type     int    `json:"type" metadata:"type,optional" validate:"min=0,max=65535"`            /*账户类别：企业、政府*/
+	OrgName  string `json:"orgName" metadata:"orgName,optional" validate:"max=128,charset=text"` /*企业或组织名称*/
+	Address  string `json:"address" metadata:"address,optional" validate:"max=128,charset=hex"`  /*账户地址*/
+	Frozen   bool   `json:"frozen" metadata:"frozen,optional" validate:"empty"`                  /*账户停用标记*/
+	Token    int64  `json:"token" metadata:"token,optional" validate:"min=0,max=0"`              /*账户积分*/
+	EncKey   string `json:"encKey,omitempty" metadata:"encKey,optional" validate:"empty"`        /*数据密钥加密公钥，PEM格式*/
+
+	Closed    bool   `json:"closed" metadata:"closed,optional" validate:"empty"`                     /*账户已注销*/
+	ClosedAt  int64  `json:"closedAt,omitempty" metadata:"closedAt,optional" validate:"min=0,max=0"` /*注销时间*/
+	Successor string `json:"successor,omitempty" metadata:"successor,optional" validate:"empty"`     /*注销时接收积分的账户*/
+	Org       string `json:"org,omitempty" metadata:"org,optional" validate:"empty"`                 /*所属组织，通过组织合约加入*/
+
+	SchemaVersion int `json:"schemaVersion" metadata:"schemaVersion,optional" validate:"min=0,max=0"` /*账本记录版本*/
 }
 
+// 写入账本时使用当前记录版本
 func (a *Account) toBytes() []byte {
+	a.SchemaVersion = CurrentSchemaVersion(SchemaAccount)
 	dataAsBytes, _ := json.Marshal(a)
 	return dataAsBytes
 }
@@ -35,15 +45,6 @@ func (a *Account) toString() string {
 	return string(dataAsBytes)
 }
 
-func GetAccountCompositeKey(stub shim.ChaincodeStubInterface, name string) (string, string) {
-	indexName := "account"
-	indexKey, err := stub.CreateCompositeKey(indexName, []string{name})
-	if err != nil {
-		fmt.Printf("GetAccountCompositeKey error: %s \n", err.Error())
-	}
-	return indexKey, indexName
-}
-
 func NewAccount(info Account) Account {
 	hashUtils := DefaultHashUtil()
 	storePassword := hashUtils.secret(info.Password)
@@ -58,205 +59,459 @@ func NewAccount(info Account) Account {
 	}
 }
 
+// 交易提交方证书公钥地址必须与账户地址一致，未登记地址的账户不能通过校验
+func (a *Account) checkCreator(stub shim.ChaincodeStubInterface) error {
+	address, err := GetCreatorAddress(stub)
+	if err != nil {
+		return err
+	}
+	if a.Address == "" || a.Address != string(address) {
+		return NewError(CodeAccountAddressError, ErrorData{"name": a.Name})
+	}
+	return nil
+}
+
 func (a *Account) balance() int64 {
 	return a.Token
 }
 
-func (a *Account) transfer(_to *Account, _value int64) ([]byte, bool) {
-
+// 已注销或冻结的账户不能交易
+func (a *Account) checkActive() error {
+	if a.Closed {
+		return NewError(CodeAccountClosed, ErrorData{"name": a.Name})
+	}
 	if a.Frozen {
-		msg := fmt.Sprintf("账户 %s 已冻结", a.Name)
-		return []byte(msg), false
+		return NewError(CodeAccountFrozen, ErrorData{"name": a.Name})
+	}
+	return nil
+}
+
+func (a *Account) transfer(_to *Account, _value int64) error {
+
+	if err := a.checkActive(); err != nil {
+		return err
 	}
-	if _to.Frozen {
-		msg := fmt.Sprintf("账户 %s 已冻结", _to.Name)
-		return []byte(msg), false
+	if err := _to.checkActive(); err != nil {
+		return err
 	}
 
 	// 支持积分透支，取消持有积分判断
 	// if account.Token >= _value {
 	a.Token -= _value
 	_to.Token += _value
-	msg := fmt.Sprintf("账户 %s 往账户 %s 转账 %d 成功", a.Name, _to.Name, _value)
-	return []byte(msg), true
+	fmt.Printf("账户 %s 往账户 %s 转账 %d 成功 \n", a.Name, _to.Name, _value)
+	return nil
 	// } else {
 	// 	msg := fmt.Sprintf("账户 %s 余额不足, 当前 %d, 需要支付 %d", account.Name, account.BalanceOf, _value)
 	// 	return []byte(msg), false
 	// }
 }
 
-func GetAccount(stub shim.ChaincodeStubInterface, name string) (*Account, error) {
-	accountKey, _ := GetAccountCompositeKey(stub, name)
-	keyAsBytes, _ := stub.GetState(accountKey)
-	if keyAsBytes != nil {
-		account := Account{}
-		if err := json.Unmarshal(keyAsBytes, &account); err != nil {
-			fmt.Printf("[getAccount] Failed to Unmarshal json %s \n", string(keyAsBytes))
-		}
-		return &account, nil
-	}
-	return nil, fmt.Errorf("can't find ok by name %s", name)
-}
-
 type AccountContract struct {
+	contractapi.Contract
 }
 
-func (s *AccountContract) createAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	if len(args) != 1 {
-		return shim.Error("[createAccount] Incorrect number of arguments. Expecting 1")
-	}
-
-	var reqAccounts []Account
-	if err := json.Unmarshal([]byte(args[0]), &reqAccounts); err != nil {
-		return shim.Error("[createAccount] Incorrect arguments. Expecting a json array string.")
-	}
+func (s *AccountContract) GetEvaluateTransactions() []string {
+	return []string{"ShowAccount"}
+}
 
-	for _, val := range reqAccounts {
-		accountKey, _ := GetAccountCompositeKey(stub, val.Name)
-		existAsBytes, err := stub.GetState(accountKey)
-		if existAsBytes != nil {
-			return shim.Error("Failed to create account, Duplicate key.")
-		}
+func (s *AccountContract) CreateAccount(ctx contractapi.TransactionContextInterface, accounts []Account) error {
 
+	stub := ctx.GetStub()
+	repository := NewAccountRepository(stub)
+	events := NewEventBatch(stub)
+	for _, val := range accounts {
 		account := NewAccount(val)
-		if err = stub.PutState(accountKey, account.toBytes()); err != nil {
-			return shim.Error(err.Error())
+		if err := repository.Create(&account); err != nil {
+			return err
 		} else {
 			fmt.Printf("createAccount - end %s \n", account.toBytes())
 		}
+		events.add(EventAccountCreated, AccountEventPayload{
+			Name:    account.Name,
+			Type:    account.Type,
+			OrgName: account.OrgName,
+			Address: account.Address,
+			Frozen:  account.Frozen,
+		})
 	}
-	return shim.Success(nil)
+	return events.emit()
 }
 
-func (s *AccountContract) showAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// 账户名称列表为空时返回全部账户
+func (s *AccountContract) ShowAccount(ctx contractapi.TransactionContextInterface, names []string) ([]Account, error) {
 
-	var accounts []Account
-	if len(args) == 0 {
-		// return shim.Error("[showAccount] Incorrect number of arguments. Expecting length more then 0")
-		resultIterator, _ := stub.GetStateByPartialCompositeKey("account", args)
-		defer resultIterator.Close()
-		for resultIterator.HasNext() {
-			item, _ := resultIterator.Next()
-
-			account := Account{}
-			_ = json.Unmarshal(item.Value, &account)
-			accounts = append(accounts, account)
+	repository := NewAccountRepository(ctx.GetStub())
+	accounts := []Account{}
+	if len(names) == 0 {
+		var err error
+		if accounts, err = repository.List(); err != nil {
+			return nil, err
 		}
 	} else {
-		for _, val := range args {
-			account, err := GetAccount(stub, val)
+		for _, val := range names {
+			account, err := repository.Get(val)
 			if err != nil {
-				return shim.Error(err.Error())
+				return nil, err
 			}
-			account.Password = ""
 			accounts = append(accounts, *account)
 		}
 	}
 
-	dataBytes, _ := json.Marshal(accounts)
-	return shim.Success(dataBytes)
+	// 不返回密码摘要
+	for i := range accounts {
+		accounts[i].Password = ""
+	}
+	return accounts, nil
 }
 
-func (s *AccountContract) frozenAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (s *AccountContract) FrozenAccount(ctx contractapi.TransactionContextInterface, name string, frozen bool) error {
+
+	stub := ctx.GetStub()
+	repository := NewAccountRepository(stub)
+	account, err := repository.GetOpen(name)
+	if err != nil {
+		return err
+	}
+	account.Frozen = frozen
 
-	if len(args) != 2 {
-		return shim.Error("[frozenAccount] Incorrect number of arguments. Expecting 2")
+	if err = repository.Save(account); err != nil {
+		return err
+	} else {
+		fmt.Printf("frozenAccount - end %s \n", account.toString())
 	}
 
-	_account := args[0]
-	_status := args[1]
+	events := NewEventBatch(stub)
+	events.add(EventAccountFrozen, AccountEventPayload{Name: account.Name, Frozen: account.Frozen})
+	return events.emit()
+}
+
+// 兼容原删除接口，按无积分转入账户注销
+func (s *AccountContract) DeleteAccount(ctx contractapi.TransactionContextInterface, name string) error {
+	return s.CloseAccount(ctx, name, "")
+}
+
+// 注销账户：积分为 0 时可直接注销，积分为正时需指定 successor 接收积分，透支积分需先结清
+func (s *AccountContract) CloseAccount(ctx contractapi.TransactionContextInterface, name string, successor string) error {
 
-	account, err := GetAccount(stub, _account)
+	if successor == name {
+		return InvalidArgumentError("successor must differ from account %s", name)
+	}
+
+	stub := ctx.GetStub()
+	repository := NewAccountRepository(stub)
+	account, err := repository.GetOpen(name)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
+	}
+	// 出价保证金、悬赏积分退回前不能注销
+	if count, locked, err := SumEscrow(stub, account.Name); err != nil {
+		return err
+	} else if count > 0 {
+		return NewError(CodeEscrowNotSettled, ErrorData{"name": account.Name, "count": count, "token": locked})
 	}
 
-	if strings.ToLower(_status) == "true" || _status == "1" {
-		account.Frozen = true
-	} else {
-		account.Frozen = false
+	amount := account.Token
+	payload := AccountClosedEventPayload{Name: account.Name, Successor: successor, Amount: amount}
+	if amount < 0 || (amount > 0 && successor == "") {
+		return NewError(CodeBalanceNotSettled, ErrorData{"name": account.Name, "token": amount})
+	}
+	if amount > 0 {
+		to, err := repository.Get(successor)
+		if err != nil {
+			return err
+		}
+		if err := account.transfer(to, amount); err != nil {
+			return err
+		}
+		if err := repository.Save(to); err != nil {
+			return err
+		}
+		payload.SuccessorBalance = to.Token
 	}
 
-	accountAsBytes := account.toBytes()
-	err = stub.PutState(_account, accountAsBytes)
+	events := NewEventBatch(stub)
+	if err := UnshelveOwnerTitles(stub, account.Name, events); err != nil {
+		return err
+	}
+	if account.Org != "" {
+		if err := DeleteOrgMember(stub, account.Org, account.Name); err != nil {
+			return err
+		}
+		events.add(EventOrgMemberChanged, OrgMemberEventPayload{Org: account.Org, Name: account.Name, Removed: true})
+		account.Org = ""
+	}
+
+	// 保留注销记录，清除登录和加密信息
+	timeUnix, err := GetTxTimeUnix(stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
+	}
+	account.Password = ""
+	account.EncKey = ""
+	account.Closed = true
+	account.ClosedAt = timeUnix
+	account.Successor = successor
+	if err = repository.Save(account); err != nil {
+		return err
 	} else {
-		fmt.Printf("frozenAccount - end %s \n", string(accountAsBytes))
+		fmt.Printf("closeAccount - end %s \n", account.toString())
 	}
 
-	return shim.Success(nil)
+	events.add(EventAccountClosed, payload)
+	return events.emit()
 }
 
-func (s *AccountContract) deleteAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// 管理员增发积分，周期内累计增发超过审批阈值时创建待审批提案，审批通过后执行
+func (s *AccountContract) MintToken(ctx contractapi.TransactionContextInterface, name string, amount int64) (*AccountTokenResponse, error) {
 
-	if len(args) != 1 {
-		return shim.Error("[deleteAccount] Incorrect number of arguments. Expecting 1")
+	stub := ctx.GetStub()
+	if err := RequireAdmin(stub); err != nil {
+		return nil, err
+	}
+	events := NewEventBatch(stub)
+	var result *AccountTokenResponse
+	policy, err := GetApprovalPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	exceeded, err := policy.accumulate(stub, ProposalMint, name, amount)
+	if err != nil {
+		return nil, err
+	}
+	if exceeded {
+		result, err = SubmitProposal(stub, policy, ProposalMint, name, amount, MintProposalRequest{Name: name, Amount: amount}, events)
+	} else {
+		result, err = mintToken(stub, name, amount, events)
+	}
+	if err != nil {
+		return nil, err
 	}
+	if err = events.emit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	_account := args[0]
-	accountKey, _ := GetAccountCompositeKey(stub, _account)
+// 负数积分视为销毁积分
+func mintToken(stub shim.ChaincodeStubInterface, name string, amount int64, events *EventBatch) (*AccountTokenResponse, error) {
 
-	err := stub.DelState(accountKey)
+	repository := NewAccountRepository(stub)
+	account, err := repository.GetOpen(name)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
+	}
+	account.Token += amount
+
+	if err = AddTotalSupply(stub, amount); err != nil {
+		return nil, err
+	}
+	if err = repository.Save(account); err != nil {
+		return nil, err
+	} else {
+		fmt.Printf("Accounter mint token - end %s %d \n", account.Name, account.Token)
+	}
+
+	if amount >= 0 {
+		events.add(EventTokenMinted, TokenEventPayload{Name: account.Name, Amount: amount, Balance: account.Token})
 	} else {
-		fmt.Printf("deleteAccount - end %s \n", _account)
+		events.add(EventTokenBurned, TokenEventPayload{Name: account.Name, Amount: -amount, Balance: account.Token})
 	}
+	return &AccountTokenResponse{Name: account.Name, Token: account.Token}, nil
+}
 
-	return shim.Success(nil)
+type TokenTransferRequest struct {
+	From    string `json:"from" validate:"required,max=64,charset=name"`                                 /*转出账户*/
+	To      string `json:"to" validate:"required,max=64,charset=name"`                                   /*转入账户*/
+	Amount  int64  `json:"amount" validate:"min=1,max=1000000000000"`                                    /*转账积分*/
+	Spender string `json:"spender,omitempty" metadata:"spender,optional" validate:"max=64,charset=name"` /*代转出账户发起转账的账户，按授权额度扣减，可选*/
 }
 
-func (s *AccountContract) mintToken(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// 账户间转账积分，转出账户不允许透支；超过交易审批阈值时创建待审批提案
+func (s *AccountContract) TransferToken(ctx contractapi.TransactionContextInterface, request TokenTransferRequest) (*AccountTokenResponse, error) {
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	stub := ctx.GetStub()
+	if err := checkTransferCreator(stub, request); err != nil {
+		return nil, err
 	}
+	events := NewEventBatch(stub)
+	result, err := submitTokenTransfer(stub, request, events)
+	if err != nil {
+		return nil, err
+	}
+	if err = events.emit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	_account := args[0]
-	_amount, err := strconv.Atoi(args[1])
+// 转账须由转出账户提交，指定代付账户时由代付账户提交
+func checkTransferCreator(stub shim.ChaincodeStubInterface, request TokenTransferRequest) error {
+	payer := request.From
+	if request.Spender != "" {
+		payer = request.Spender
+	}
+	account, err := NewAccountRepository(stub).Get(payer)
 	if err != nil {
-		return shim.Error("Expecting integer Value for mint token holding")
+		return err
 	}
+	return account.checkCreator(stub)
+}
 
-	account, err := GetAccount(stub, _account)
+// 按审批策略执行转账或创建待审批提案
+func submitTokenTransfer(stub shim.ChaincodeStubInterface, request TokenTransferRequest, events *EventBatch) (*AccountTokenResponse, error) {
+	policy, err := GetApprovalPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	exceeded, err := policy.accumulate(stub, ProposalToken, request.From, request.Amount)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
-	account.Token += int64(_amount)
+	if exceeded {
+		return SubmitProposal(stub, policy, ProposalToken, request.From, request.Amount, request, events)
+	}
+	return transferAccountToken(stub, request, events)
+}
+
+// 返回转出账户积分
+func transferAccountToken(stub shim.ChaincodeStubInterface, request TokenTransferRequest, events *EventBatch) (*AccountTokenResponse, error) {
 
-	err = stub.PutState(_account, account.toBytes())
+	if request.From == request.To {
+		return nil, InvalidArgumentError("from and to must differ")
+	}
+	repository := NewAccountRepository(stub)
+	from, err := repository.Get(request.From)
 	if err != nil {
-		return shim.Error(err.Error())
-	} else {
-		fmt.Printf("Accounter mint token - end %s %d \n", account.Name, account.Token)
+		return nil, err
+	}
+	to, err := repository.Get(request.To)
+	if err != nil {
+		return nil, err
+	}
+	if from.Token < request.Amount {
+		return nil, NewError(CodeTokenInsufficient, ErrorData{"name": from.Name, "token": from.Token, "amount": request.Amount})
 	}
 
-	token := AccountTokenResponse{Name: account.Name, Token: account.Token}
-	tokenAsBytes := token.toBytes()
+	spender := ""
+	if request.Spender != "" && request.Spender != from.Name {
+		spender = request.Spender
+		spending, err := NewAllowanceSpending(stub, from.Name, spender)
+		if err != nil {
+			return nil, err
+		}
+		if err := spending.spend(AllowanceAnyType, request.Amount); err != nil {
+			return nil, err
+		}
+		if err := spending.save(events); err != nil {
+			return nil, err
+		}
+	}
 
-	return shim.Success(tokenAsBytes)
+	if err := from.transfer(to, request.Amount); err != nil {
+		return nil, err
+	}
+	if err := repository.Save(from); err != nil {
+		return nil, err
+	}
+	if err := repository.Save(to); err != nil {
+		return nil, err
+	}
+	fmt.Printf("transferToken - end [%s %d] -> [%s %d] \n", from.Name, from.Token, to.Name, to.Token)
+
+	events.add(EventTokenTransferred, TokenTransferEventPayload{
+		From:        from.Name,
+		To:          to.Name,
+		Amount:      request.Amount,
+		Spender:     spender,
+		FromBalance: from.Token,
+		ToBalance:   to.Token,
+	})
+	return &AccountTokenResponse{Name: from.Name, Token: from.Token}, nil
 }
 
-func (s *AccountContract) changeSecret(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (s *AccountContract) SetEncryptKey(ctx contractapi.TransactionContextInterface, name string, publicKey string) error {
+
+	if _, err := ParsePublicKeyPem(publicKey); err != nil {
+		return err
+	}
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	stub := ctx.GetStub()
+	repository := NewAccountRepository(stub)
+	account, err := repository.GetOpen(name)
+	if err != nil {
+		return err
 	}
+	if err := account.checkCreator(stub); err != nil {
+		return err
+	}
+	account.EncKey = publicKey
+
+	if err = repository.Save(account); err != nil {
+		return err
+	}
+
+	events := NewEventBatch(stub)
+	events.add(EventAccountKeySet, AccountKeyEventPayload{Name: account.Name, Fingerprint: KeyFingerprint(publicKey)})
+	return events.emit()
+}
 
-	_account := args[0]
-	_secret := args[1]
+func (s *AccountContract) ChangeSecret(ctx contractapi.TransactionContextInterface, name string, secret string) error {
 
-	if account, err := GetAccount(stub, _account); err == nil {
+	repository := NewAccountRepository(ctx.GetStub())
+	if account, err := repository.GetOpen(name); err == nil {
 		hashUtil := DefaultHashUtil()
-		account.Password = hashUtil.secret(_secret)
-		if err1 := stub.PutState(_account, account.toBytes()); err1 != nil {
-			return shim.Error(err1.Error())
+		account.Password = hashUtil.secret(secret)
+		if err1 := repository.Save(account); err1 != nil {
+			return err1
 		}
 	} else {
-		return shim.Error(err.Error())
+		return err
 	}
 
-	return shim.Success(nil)
+	return nil
+}
+
+// 管理员合并旧版本写在账户名称普通键下的账户记录，返回每个账户的合并结果
+func (s *AccountContract) MigrateAccounts(ctx contractapi.TransactionContextInterface) ([]AccountMigration, error) {
+
+	stub := ctx.GetStub()
+	if err := RequireAdmin(stub); err != nil {
+		return nil, err
+	}
+
+	repository := NewAccountRepository(stub)
+	names, err := repository.findLegacyNames()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []AccountMigration{}
+	var supplyDelta int64
+	events := NewEventBatch(stub)
+	for _, name := range names {
+		migration, err := repository.MigrateLegacy(name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("migrateAccounts - %s delta %d orphan %t \n", name, migration.TokenDelta, migration.Orphan)
+		migrations = append(migrations, *migration)
+		supplyDelta += migration.TokenDelta
+		if !migration.Orphan {
+			events.add(EventAccountMigrated, AccountMigratedEventPayload{
+				Name:       migration.Name,
+				TokenDelta: migration.TokenDelta,
+				Balance:    migration.Balance,
+			})
+		}
+	}
+	if supplyDelta != 0 {
+		if err := AddTotalSupply(stub, supplyDelta); err != nil {
+			return nil, err
+		}
+	}
+	if err = events.emit(); err != nil {
+		return nil, err
+	}
+	return migrations, nil
 }
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+)
+
+/*
+ * 账户存储实现：
+ * 1. 所有账户读写必须通过 AccountRepository，账户统一保存在 account 组合键下
+ * 2. 账户注销后保留记录，不提供删除
+ * 3. 旧版本 frozenAccount、mintToken、changeSecret 误写在账户名称普通键下的记录，通过 migrateAccounts 合并
+ */
+
+const AccountIndexName = "account"
+
+type AccountRepository struct {
+	stub shim.ChaincodeStubInterface
+}
+
+func NewAccountRepository(stub shim.ChaincodeStubInterface) *AccountRepository {
+	return &AccountRepository{stub: stub}
+}
+
+func (r *AccountRepository) key(name string) (string, error) {
+	return CreateCompositeKey(r.stub, AccountIndexName, []string{name})
+}
+
+func (r *AccountRepository) unmarshal(key string, accountAsBytes []byte) (*Account, error) {
+	account := Account{}
+	if err := UnmarshalRecord(SchemaAccount, key, accountAsBytes, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// 账户不存在时返回 nil
+func (r *AccountRepository) Find(name string) (*Account, error) {
+	accountKey, err := r.key(name)
+	if err != nil {
+		return nil, err
+	}
+	accountAsBytes, err := GetState(r.stub, accountKey)
+	if err != nil {
+		return nil, err
+	}
+	if accountAsBytes == nil {
+		return nil, nil
+	}
+	return r.unmarshal(accountKey, accountAsBytes)
+}
+
+func (r *AccountRepository) Get(name string) (*Account, error) {
+	account, err := r.Find(name)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, NewError(CodeAccountNotFound, ErrorData{"name": name})
+	}
+	return account, nil
+}
+
+// 未注销的账户，用于修改账户信息
+func (r *AccountRepository) GetOpen(name string) (*Account, error) {
+	account, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if account.Closed {
+		return nil, NewError(CodeAccountClosed, ErrorData{"name": name})
+	}
+	return account, nil
+}
+
+// 账户已注销时返回错误，账户不存在时不校验
+func (r *AccountRepository) CheckNotClosed(name string) error {
+	account, err := r.Find(name)
+	if err != nil {
+		return err
+	}
+	if account != nil && account.Closed {
+		return NewError(CodeAccountClosed, ErrorData{"name": name})
+	}
+	return nil
+}
+
+// 已注销账户保留注销记录，名称不能重用
+func (r *AccountRepository) Create(account *Account) error {
+	exist, err := r.Find(account.Name)
+	if err != nil {
+		return err
+	}
+	if exist != nil && exist.Closed {
+		return NewError(CodeAccountClosed, ErrorData{"name": account.Name})
+	}
+	if exist != nil {
+		return NewError(CodeAccountExists, ErrorData{"name": account.Name})
+	}
+	return r.Save(account)
+}
+
+func (r *AccountRepository) Save(account *Account) error {
+	accountKey, err := r.key(account.Name)
+	if err != nil {
+		return err
+	}
+	return PutState(r.stub, accountKey, account.toBytes())
+}
+
+func (r *AccountRepository) List() ([]Account, error) {
+	accounts := []Account{}
+	resultIterator, err := r.stub.GetStateByPartialCompositeKey(AccountIndexName, []string{})
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		account, err := r.unmarshal(item.Key, item.Value)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+	return accounts, nil
+}
+
+// 账户键的一个历史版本，account 为 nil 表示删除
+type accountVersion struct {
+	account   *Account
+	timestamp int64 /*写入时间，纳秒*/
+}
+
+// 旧版本误写在普通键下的账户记录合并结果
+type AccountMigration struct {
+	Name          string `json:"name"`          /*账户名称*/
+	TokenDelta    int64  `json:"tokenDelta"`    /*补记到账户的积分*/
+	SecretChanged bool   `json:"secretChanged"` /*是否补记修改后的密码*/
+	Orphan        bool   `json:"orphan"`        /*账户已删除，普通键记录直接清除*/
+	Balance       int64  `json:"balance"`       /*合并后的账户积分*/
+}
+
+// 读取键的历史版本，按写入时间升序返回，需要节点开启历史数据库
+func (r *AccountRepository) history(key string) ([]accountVersion, error) {
+	versions := []accountVersion{}
+	historyIterator, err := r.stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, LedgerError("GetHistoryForKey", err)
+	}
+	defer historyIterator.Close()
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		version := accountVersion{
+			timestamp: modification.Timestamp.GetSeconds()*1e9 + int64(modification.Timestamp.GetNanos()),
+		}
+		if !modification.IsDelete {
+			if version.account, err = r.unmarshal(key, modification.Value); err != nil {
+				return nil, err
+			}
+		}
+		versions = append(versions, version)
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].timestamp < versions[j].timestamp })
+	return versions, nil
+}
+
+// 查找旧版本写在账户名称普通键下的账户记录，普通键范围查询不包含组合键
+func (r *AccountRepository) findLegacyNames() ([]string, error) {
+	names := []string{}
+	resultIterator, err := r.stub.GetStateByRange("", "")
+	if err != nil {
+		return nil, LedgerError("GetStateByRange", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		account := Account{}
+		if err := json.Unmarshal(item.Value, &account); err != nil || account.Name != item.Key {
+			continue
+		}
+		names = append(names, item.Key)
+	}
+	return names, nil
+}
+
+/*
+ * 合并普通键账户记录：
+ * 旧版本每次误写都是读取组合键账户、修改后写入普通键，因此按写入时间对比当时的组合键账户：
+ * 1. 积分差额为 mintToken 的积分，逐次累加后补记到组合键账户
+ * 2. 密码不同为 changeSecret 修改的密码，使用最后一次修改的密码
+ * 3. 冻结标记旧版本未保存，无法恢复
+ * 组合键账户已删除时只清除普通键记录
+ */
+func (r *AccountRepository) MigrateLegacy(name string) (*AccountMigration, error) {
+	migration := AccountMigration{Name: name}
+	account, err := r.Find(name)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		migration.Orphan = true
+		return &migration, r.deleteLegacy(name)
+	}
+
+	legacyVersions, err := r.history(name)
+	if err != nil {
+		return nil, err
+	}
+	accountKey, err := r.key(name)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := r.history(accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := ""
+	for _, legacy := range legacyVersions {
+		if legacy.account == nil {
+			continue
+		}
+		var base *Account
+		for _, version := range versions {
+			if version.timestamp > legacy.timestamp {
+				break
+			}
+			base = version.account
+		}
+		if base == nil {
+			continue
+		}
+		migration.TokenDelta += legacy.account.Token - base.Token
+		if legacy.account.Password != base.Password {
+			secret = legacy.account.Password
+		}
+	}
+
+	account.Token += migration.TokenDelta
+	if secret != "" && secret != account.Password {
+		account.Password = secret
+		migration.SecretChanged = true
+	}
+	migration.Balance = account.Token
+	if err := r.Save(account); err != nil {
+		return nil, err
+	}
+	return &migration, r.deleteLegacy(name)
+}
+
+func (r *AccountRepository) deleteLegacy(name string) error {
+	if err := r.stub.DelState(name); err != nil {
+		return LedgerError("DelState", err)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 代付额度实现：
+ * 1. 账户授权代付账户(spender)代为支付积分，额度可限定数据类型和过期时间
+ * 2. transferData、transferToken 请求填写 spender 时按额度扣减，买方或转出方账户支付积分
+ * 3. 数据交易先使用该数据类型的额度，不足时使用不限类型的额度；积分转账只使用不限类型的额度
+ * 4. 重新授权覆盖原额度，额度为 0 时撤销授权，过期额度视为 0
+ * 5. 授权须由授权账户提交，积分转账须由转出账户或代付账户提交
+ */
+
+// 不限数据类型的额度
+const AllowanceAnyType = -1
+
+type Allowance struct {
+	Owner     string `json:"owner"`     /*授权账户，支付积分*/
+	Spender   string `json:"spender"`   /*代付账户*/
+	Type      int    `json:"type"`      /*数据类型，-1 表示不限类型*/
+	Amount    int64  `json:"amount"`    /*剩余额度*/
+	ExpiresAt int64  `json:"expiresAt"` /*过期时间，0 表示不过期*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (a *Allowance) toBytes() []byte {
+	a.SchemaVersion = CurrentSchemaVersion(SchemaAllowance)
+	dataAsBytes, _ := json.Marshal(a)
+	return dataAsBytes
+}
+
+// 过期额度视为 0
+func (a *Allowance) availableAt(timeUnix int64) int64 {
+	if a.ExpiresAt > 0 && timeUnix > a.ExpiresAt {
+		return 0
+	}
+	return a.Amount
+}
+
+type AllowanceRequest struct {
+	Owner     string `json:"owner" validate:"required,max=64,charset=name"`            /*授权账户*/
+	Spender   string `json:"spender" validate:"required,max=64,charset=name"`          /*代付账户*/
+	Type      int    `json:"type" validate:"min=-1,max=65535"`                         /*数据类型，-1 表示不限类型*/
+	Amount    int64  `json:"amount" validate:"min=0,max=1000000000000"`                /*额度，0 表示撤销*/
+	ExpiresAt int64  `json:"expiresAt" metadata:"expiresAt,optional" validate:"min=0"` /*过期时间，0 表示不过期*/
+}
+
+// 代付额度：授权账户、代付账户、数据类型
+const AllowanceIndexName = "allowance"
+
+func GetAllowanceCompositeKey(stub shim.ChaincodeStubInterface, owner, spender string, dataType int) (string, error) {
+	return CreateCompositeKey(stub, AllowanceIndexName, []string{owner, spender, strconv.Itoa(dataType)})
+}
+
+// 额度不存在时返回 nil
+func FindAllowance(stub shim.ChaincodeStubInterface, owner, spender string, dataType int) (*Allowance, error) {
+	allowanceKey, err := GetAllowanceCompositeKey(stub, owner, spender, dataType)
+	if err != nil {
+		return nil, err
+	}
+	allowanceAsBytes, err := GetState(stub, allowanceKey)
+	if err != nil {
+		return nil, err
+	}
+	if allowanceAsBytes == nil {
+		return nil, nil
+	}
+	allowance := Allowance{}
+	if err := UnmarshalRecord(SchemaAllowance, allowanceKey, allowanceAsBytes, &allowance); err != nil {
+		return nil, err
+	}
+	return &allowance, nil
+}
+
+// 保存额度，额度为 0 时删除
+func PutAllowance(stub shim.ChaincodeStubInterface, allowance *Allowance) error {
+	allowanceKey, err := GetAllowanceCompositeKey(stub, allowance.Owner, allowance.Spender, allowance.Type)
+	if err != nil {
+		return err
+	}
+	if allowance.Amount == 0 {
+		if err := stub.DelState(allowanceKey); err != nil {
+			return LedgerError("DelState", err)
+		}
+		return nil
+	}
+	return PutState(stub, allowanceKey, allowance.toBytes())
+}
+
+/*
+ * 一次交易内的额度扣减：
+ * 账本写入在交易提交前不可读，同一额度在内存中累计扣减，全部扣减成功后统一保存
+ */
+type AllowanceSpending struct {
+	stub     shim.ChaincodeStubInterface
+	owner    string
+	spender  string
+	txTime   int64
+	cache    map[int]*Allowance
+	modified []*Allowance
+}
+
+// 代付账户必须为可用账户
+func NewAllowanceSpending(stub shim.ChaincodeStubInterface, owner, spender string) (*AllowanceSpending, error) {
+	spenderAccount, err := NewAccountRepository(stub).Get(spender)
+	if err != nil {
+		return nil, err
+	}
+	if err := spenderAccount.checkActive(); err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	return &AllowanceSpending{stub: stub, owner: owner, spender: spender, txTime: txTime, cache: make(map[int]*Allowance)}, nil
+}
+
+func (s *AllowanceSpending) get(dataType int) (*Allowance, error) {
+	if allowance, ok := s.cache[dataType]; ok {
+		return allowance, nil
+	}
+	allowance, err := FindAllowance(s.stub, s.owner, s.spender, dataType)
+	if err != nil {
+		return nil, err
+	}
+	s.cache[dataType] = allowance
+	return allowance, nil
+}
+
+func (s *AllowanceSpending) take(allowance *Allowance, amount int64) bool {
+	if allowance == nil || allowance.availableAt(s.txTime) < amount {
+		return false
+	}
+	allowance.Amount -= amount
+	s.modified = append(s.modified, allowance)
+	return true
+}
+
+// 扣减额度，dataType 为 AllowanceAnyType 时只使用不限类型的额度
+func (s *AllowanceSpending) spend(dataType int, amount int64) error {
+	if dataType != AllowanceAnyType {
+		allowance, err := s.get(dataType)
+		if err != nil {
+			return err
+		}
+		if s.take(allowance, amount) {
+			return nil
+		}
+	}
+	allowance, err := s.get(AllowanceAnyType)
+	if err != nil {
+		return err
+	}
+	if s.take(allowance, amount) {
+		return nil
+	}
+
+	var available int64
+	if allowance != nil {
+		available = allowance.availableAt(s.txTime)
+	}
+	return NewError(CodeAllowanceInsufficient, ErrorData{"owner": s.owner, "spender": s.spender, "type": dataType, "allowance": available, "amount": amount})
+}
+
+// 保存扣减后的额度
+func (s *AllowanceSpending) save(events *EventBatch) error {
+	saved := make(map[int]bool)
+	for _, allowance := range s.modified {
+		if saved[allowance.Type] {
+			continue
+		}
+		saved[allowance.Type] = true
+		if err := PutAllowance(s.stub, allowance); err != nil {
+			return err
+		}
+		events.add(EventAllowanceChanged, AllowanceEventPayload{
+			Owner:     allowance.Owner,
+			Spender:   allowance.Spender,
+			Type:      allowance.Type,
+			Amount:    allowance.Amount,
+			ExpiresAt: allowance.ExpiresAt,
+		})
+	}
+	return nil
+}
+
+type AllowanceContract struct {
+	contractapi.Contract
+}
+
+func (s *AllowanceContract) GetEvaluateTransactions() []string {
+	return []string{"ShowAllowances"}
+}
+
+// 授权代付额度，覆盖原额度，须由授权账户提交
+func (s *AllowanceContract) ApproveSpender(ctx contractapi.TransactionContextInterface, request AllowanceRequest) (*Allowance, error) {
+
+	stub := ctx.GetStub()
+	events := NewEventBatch(stub)
+	allowance, err := approveSpender(stub, request, events)
+	if err != nil {
+		return nil, err
+	}
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return allowance, nil
+}
+
+func approveSpender(stub shim.ChaincodeStubInterface, request AllowanceRequest, events *EventBatch) (*Allowance, error) {
+
+	if request.Owner == request.Spender {
+		return nil, InvalidArgumentError("owner and spender must differ")
+	}
+	repository := NewAccountRepository(stub)
+	owner, err := repository.Get(request.Owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := owner.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	if err := owner.checkActive(); err != nil {
+		return nil, err
+	}
+	if _, err := repository.GetOpen(request.Spender); err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	if request.ExpiresAt != 0 && request.ExpiresAt <= txTime {
+		return nil, InvalidArgumentError("expiresAt %d isn't after the transaction time %d", request.ExpiresAt, txTime)
+	}
+
+	allowance := Allowance{
+		Owner:     request.Owner,
+		Spender:   request.Spender,
+		Type:      request.Type,
+		Amount:    request.Amount,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if err := PutAllowance(stub, &allowance); err != nil {
+		return nil, err
+	}
+	fmt.Printf("approveSpender - end %s %s %d %d \n", allowance.Owner, allowance.Spender, allowance.Type, allowance.Amount)
+
+	events.add(EventAllowanceChanged, AllowanceEventPayload{
+		Owner:     allowance.Owner,
+		Spender:   allowance.Spender,
+		Type:      allowance.Type,
+		Amount:    allowance.Amount,
+		ExpiresAt: allowance.ExpiresAt,
+	})
+	return &allowance, nil
+}
+
+// 查询授权账户的代付额度，spender 为空时返回全部代付账户，不返回已过期额度
+func (s *AllowanceContract) ShowAllowances(ctx contractapi.TransactionContextInterface, owner string, spender string) ([]Allowance, error) {
+
+	stub := ctx.GetStub()
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []string{owner}
+	if spender != "" {
+		attributes = append(attributes, spender)
+	}
+
+	retDataList := []Allowance{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(AllowanceIndexName, attributes)
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		allowance := Allowance{}
+		if err := UnmarshalRecord(SchemaAllowance, item.Key, item.Value, &allowance); err != nil {
+			return nil, err
+		}
+		if allowance.availableAt(txTime) > 0 {
+			retDataList = append(retDataList, allowance)
+		}
+	}
+	return retDataList, nil
+}
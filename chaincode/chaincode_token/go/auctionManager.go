@@ -0,0 +1,907 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 独占数据密封拍卖实现：
+ * 1. 数据归属方为一条数据创建拍卖，拍卖期间及成交后该数据只能由成交方购买
+ * 2. 提交阶段竞拍方提交出价承诺并锁定积分，承诺为 SHA-256(拍卖编号:竞拍方:单价:随机串) 的十六进制
+ * 3. 揭示阶段竞拍方公开单价和随机串，单价不低于保留价且费用不超过锁定积分时为有效出价
+ * 4. 揭示结束后任何人可以结算：最高有效出价成交，单价相同时先提交者优先，未成交的锁定积分退回
+ * 5. 成交方的锁定积分在购买时退回并按成交价支付，购买与 transferData 相同，超过审批阈值时等待提案执行
+ * 6. 出价须由竞拍方提交，取消拍卖须由数据归属方提交
+ * 各阶段按交易时间判断：提交阶段至 commitEnd（含），揭示阶段至 revealEnd（含）
+ */
+
+// 拍卖状态，open 按交易时间显示为 commit、reveal、ended
+const (
+	AuctionOpen      = "open"
+	AuctionCommit    = "commit"
+	AuctionReveal    = "reveal"
+	AuctionEnded     = "ended"
+	AuctionSettling  = "settling"
+	AuctionSold      = "sold"
+	AuctionUnsold    = "unsold"
+	AuctionCancelled = "cancelled"
+)
+
+// 出价状态
+const (
+	BidLocked   = "locked"
+	BidRevealed = "revealed"
+	BidUnlocked = "unlocked"
+	BidWon      = "won"
+)
+
+type Auction struct {
+	ID        string `json:"id"`                                              /*拍卖编号，创建拍卖的交易ID*/
+	Type      int    `json:"type"`                                            /*数据类型*/
+	Owner     string `json:"owner"`                                           /*数据归属方*/
+	Title     string `json:"title"`                                           /*数据标签名称*/
+	Hash      string `json:"hash"`                                            /*数据Hash*/
+	Reserve   int    `json:"reserve"`                                         /*保留单价*/
+	CommitEnd int64  `json:"commitEnd"`                                       /*提交阶段截止时间*/
+	RevealEnd int64  `json:"revealEnd"`                                       /*揭示阶段截止时间*/
+	Status    string `json:"status"`                                          /*状态：open、settling、sold、unsold、cancelled*/
+	Bids      int    `json:"bids"`                                            /*出价数量*/
+	Winner    string `json:"winner,omitempty" metadata:"winner,optional"`     /*成交方*/
+	Price     int    `json:"price,omitempty" metadata:"price,optional"`       /*成交单价*/
+	Deposit   int64  `json:"deposit,omitempty" metadata:"deposit,optional"`   /*成交方锁定积分*/
+	Proposal  string `json:"proposal,omitempty" metadata:"proposal,optional"` /*成交超过审批阈值时的待审批提案编号*/
+	CreatedAt int64  `json:"createdAt"`                                       /*创建时间*/
+	ClosedAt  int64  `json:"closedAt,omitempty" metadata:"closedAt,optional"` /*成交、流拍或取消时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (a *Auction) toBytes() []byte {
+	a.SchemaVersion = CurrentSchemaVersion(SchemaAuction)
+	dataAsBytes, _ := json.Marshal(a)
+	return dataAsBytes
+}
+
+// 进行中的拍卖按交易时间返回所处阶段
+func (a *Auction) statusAt(timeUnix int64) string {
+	if a.Status != AuctionOpen {
+		return a.Status
+	}
+	if timeUnix <= a.CommitEnd {
+		return AuctionCommit
+	}
+	if timeUnix <= a.RevealEnd {
+		return AuctionReveal
+	}
+	return AuctionEnded
+}
+
+func (a *Auction) getDataEvidence() DataEvidenceRequest {
+	return DataEvidenceRequest{Type: a.Type, Owner: a.Owner, Title: a.Title, Hash: a.Hash}
+}
+
+// 拍卖成交的交易请求只能包含拍卖数据，由成交方自行支付
+func (a *Auction) matches(request TransferRequest) error {
+	if request.Buyer != a.Winner || len(request.Data) != 1 || request.Data[0] != a.getDataEvidence() {
+		return InvalidArgumentError("request doesn't match auction %s", a.ID)
+	}
+	if request.Org != "" || request.Spender != "" {
+		return InvalidArgumentError("auction %s must be paid by the winner", a.ID)
+	}
+	return nil
+}
+
+func (a *Auction) unitPrice(price *DataTitlePrice) (int, error) {
+	return a.Price, nil
+}
+
+// 退回成交方锁定积分，随后按成交价支付
+func (a *Auction) release(buyer *Account, events *EventBatch) {
+	buyer.Token += a.Deposit
+	events.add(EventAuctionBid, AuctionBidEventPayload{
+		Auction: a.ID,
+		Bidder:  buyer.Name,
+		Status:  BidUnlocked,
+		Deposit: a.Deposit,
+		Balance: buyer.Token,
+	})
+}
+
+func (a *Auction) complete(stub shim.ChaincodeStubInterface, events *EventBatch) error {
+	if err := DeleteEscrow(stub, a.Winner, EscrowBid, a.ID); err != nil {
+		return err
+	}
+	return closeAuction(stub, a, AuctionSold, events)
+}
+
+// 成交方可以交易
+func (a *Auction) checkWinner(stub shim.ChaincodeStubInterface) error {
+	winner, err := NewAccountRepository(stub).Get(a.Winner)
+	if err != nil {
+		return err
+	}
+	return winner.checkActive()
+}
+
+// 数据归属方可以交易，数据和标签仍然存在
+func (a *Auction) checkOwner(stub shim.ChaincodeStubInterface) error {
+	owner, err := NewAccountRepository(stub).Get(a.Owner)
+	if err != nil {
+		return err
+	}
+	if err := owner.checkActive(); err != nil {
+		return err
+	}
+	data := a.getDataEvidence()
+	if _, err := GetDataDescription(stub, data.getDataCompositeKeyAttributes()); err != nil {
+		return err
+	}
+	titleKey, err := GetDataTitleCompositeKey(stub, data.getDataTitleCompositeKeyAttributes())
+	if err != nil {
+		return err
+	}
+	_, err = GetDataTitle(stub, titleKey)
+	return err
+}
+
+// 账户或数据状态导致无法成交的错误，账本读取错误不在其中
+func isUnpurchasable(err error) bool {
+	chaincodeError, ok := err.(*ChaincodeError)
+	if !ok {
+		return false
+	}
+	switch chaincodeError.Code {
+	case CodeAccountNotFound, CodeAccountFrozen, CodeAccountClosed, CodeDataNotFound, CodeTitleNotFound:
+		return true
+	}
+	return false
+}
+
+func (a *Auction) toEventPayload() AuctionEventPayload {
+	return AuctionEventPayload{
+		ID:        a.ID,
+		Type:      a.Type,
+		Owner:     a.Owner,
+		Title:     a.Title,
+		Hash:      a.Hash,
+		Reserve:   a.Reserve,
+		CommitEnd: a.CommitEnd,
+		RevealEnd: a.RevealEnd,
+		Status:    a.Status,
+		Winner:    a.Winner,
+		Price:     a.Price,
+	}
+}
+
+type AuctionBid struct {
+	Auction     string `json:"auction"`                                   /*拍卖编号*/
+	Bidder      string `json:"bidder"`                                    /*竞拍方账户*/
+	Commitment  string `json:"commitment"`                                /*出价承诺*/
+	Deposit     int64  `json:"deposit"`                                   /*锁定积分*/
+	Price       int    `json:"price,omitempty" metadata:"price,optional"` /*揭示的单价*/
+	Status      string `json:"status"`                                    /*状态：locked、revealed、unlocked、won*/
+	CommittedAt int64  `json:"committedAt"`                               /*提交时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (b *AuctionBid) toBytes() []byte {
+	b.SchemaVersion = CurrentSchemaVersion(SchemaAuctionBid)
+	dataAsBytes, _ := json.Marshal(b)
+	return dataAsBytes
+}
+
+// 出价承诺：SHA-256(拍卖编号:竞拍方:单价:随机串) 的十六进制
+func NewBidCommitment(auction, bidder string, price int, salt string) string {
+	hashUtil := HashUtil{algor: SHA256}
+	return hashUtil.checksum([]byte(fmt.Sprintf("%s:%s:%d:%s", auction, bidder, price, salt)))
+}
+
+type AuctionRequest struct {
+	Type      int    `json:"type" validate:"min=0,max=65535"`                /*数据类型*/
+	Owner     string `json:"owner" validate:"required,max=64,charset=name"`  /*数据归属方*/
+	Title     string `json:"title" validate:"required,max=128,charset=text"` /*数据标签名称*/
+	Hash      string `json:"hash" validate:"required,max=128,charset=hex"`   /*数据Hash*/
+	Reserve   int    `json:"reserve" validate:"min=1,max=1000000000"`        /*保留单价*/
+	CommitEnd int64  `json:"commitEnd" validate:"min=1"`                     /*提交阶段截止时间*/
+	RevealEnd int64  `json:"revealEnd" validate:"min=1"`                     /*揭示阶段截止时间*/
+}
+
+type BidCommitRequest struct {
+	Auction    string `json:"auction" validate:"required,max=128,charset=name"`  /*拍卖编号*/
+	Bidder     string `json:"bidder" validate:"required,max=64,charset=name"`    /*竞拍方账户*/
+	Commitment string `json:"commitment" validate:"required,max=64,charset=hex"` /*出价承诺*/
+	Deposit    int64  `json:"deposit" validate:"min=1,max=1000000000000"`        /*锁定积分，不低于单价 * 数据条数*/
+}
+
+type BidRevealRequest struct {
+	Auction string `json:"auction" validate:"required,max=128,charset=name"` /*拍卖编号*/
+	Bidder  string `json:"bidder" validate:"required,max=64,charset=name"`   /*竞拍方账户*/
+	Price   int    `json:"price" validate:"min=1,max=1000000000"`            /*出价单价*/
+	Salt    string `json:"salt" validate:"required,max=128,charset=text"`    /*提交承诺时使用的随机串*/
+}
+
+const AuctionIndexName = "auction"
+
+func GetAuctionCompositeKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return CreateCompositeKey(stub, AuctionIndexName, []string{id})
+}
+
+// 标签拍卖索引：数据类型、数据归属方、标签、拍卖编号，值为拍卖编号
+const AuctionTitleIndexName = "auctionTitle"
+
+// 独占数据索引：数据类型、数据归属方、标签、数据Hash，值为拍卖编号，流拍或取消时删除
+const AuctionDataIndexName = "auctionData"
+
+func GetAuctionDataCompositeKey(stub shim.ChaincodeStubInterface, data DataEvidenceRequest) (string, error) {
+	return CreateCompositeKey(stub, AuctionDataIndexName, data.getDataCompositeKeyAttributes())
+}
+
+const AuctionBidIndexName = "auctionBid"
+
+func GetAuctionBidCompositeKey(stub shim.ChaincodeStubInterface, auction, bidder string) (string, error) {
+	return CreateCompositeKey(stub, AuctionBidIndexName, []string{auction, bidder})
+}
+
+func GetAuction(stub shim.ChaincodeStubInterface, id string) (*Auction, error) {
+	auctionKey, err := GetAuctionCompositeKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	auctionAsBytes, err := GetState(stub, auctionKey)
+	if err != nil {
+		return nil, err
+	}
+	if auctionAsBytes == nil {
+		return nil, NewError(CodeAuctionNotFound, ErrorData{"id": id})
+	}
+	auction := Auction{}
+	if err := UnmarshalRecord(SchemaAuction, auctionKey, auctionAsBytes, &auction); err != nil {
+		return nil, err
+	}
+	return &auction, nil
+}
+
+func PutAuction(stub shim.ChaincodeStubInterface, auction *Auction) error {
+	auctionKey, err := GetAuctionCompositeKey(stub, auction.ID)
+	if err != nil {
+		return err
+	}
+	return PutState(stub, auctionKey, auction.toBytes())
+}
+
+// 读取已确定成交方、等待购买的拍卖
+func GetSettlingAuction(stub shim.ChaincodeStubInterface, id string) (*Auction, error) {
+	auction, err := GetAuction(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if auction.Status != AuctionSettling {
+		return nil, NewError(CodeAuctionPhase, ErrorData{"id": id, "phase": auction.Status})
+	}
+	return auction, nil
+}
+
+// 返回数据所在的独占拍卖编号，没有时返回空字符串
+func GetDataAuction(stub shim.ChaincodeStubInterface, data DataEvidenceRequest) (string, error) {
+	dataKey, err := GetAuctionDataCompositeKey(stub, data)
+	if err != nil {
+		return "", err
+	}
+	idAsBytes, err := GetState(stub, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return string(idAsBytes), nil
+}
+
+// 出价不存在时返回 nil
+func FindAuctionBid(stub shim.ChaincodeStubInterface, auction, bidder string) (*AuctionBid, error) {
+	bidKey, err := GetAuctionBidCompositeKey(stub, auction, bidder)
+	if err != nil {
+		return nil, err
+	}
+	bidAsBytes, err := GetState(stub, bidKey)
+	if err != nil {
+		return nil, err
+	}
+	if bidAsBytes == nil {
+		return nil, nil
+	}
+	bid := AuctionBid{}
+	if err := UnmarshalRecord(SchemaAuctionBid, bidKey, bidAsBytes, &bid); err != nil {
+		return nil, err
+	}
+	return &bid, nil
+}
+
+func GetAuctionBid(stub shim.ChaincodeStubInterface, auction, bidder string) (*AuctionBid, error) {
+	bid, err := FindAuctionBid(stub, auction, bidder)
+	if err != nil {
+		return nil, err
+	}
+	if bid == nil {
+		return nil, NewError(CodeBidNotFound, ErrorData{"id": auction, "bidder": bidder})
+	}
+	return bid, nil
+}
+
+func PutAuctionBid(stub shim.ChaincodeStubInterface, bid *AuctionBid) error {
+	bidKey, err := GetAuctionBidCompositeKey(stub, bid.Auction, bid.Bidder)
+	if err != nil {
+		return err
+	}
+	return PutState(stub, bidKey, bid.toBytes())
+}
+
+// 关闭拍卖：成交、流拍或取消，流拍和取消时解除数据独占
+func closeAuction(stub shim.ChaincodeStubInterface, auction *Auction, status string, events *EventBatch) error {
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return err
+	}
+	auction.Status = status
+	auction.ClosedAt = txTime
+	if err := PutAuction(stub, auction); err != nil {
+		return err
+	}
+	if status != AuctionSold {
+		dataKey, err := GetAuctionDataCompositeKey(stub, auction.getDataEvidence())
+		if err != nil {
+			return err
+		}
+		if err := stub.DelState(dataKey); err != nil {
+			return LedgerError("DelState", err)
+		}
+	}
+	events.add(EventAuctionChanged, auction.toEventPayload())
+	return nil
+}
+
+// 退回出价锁定的积分
+func unlockBid(stub shim.ChaincodeStubInterface, bid *AuctionBid, events *EventBatch) error {
+	repository := NewAccountRepository(stub)
+	bidder, err := repository.Get(bid.Bidder)
+	if err != nil {
+		return err
+	}
+	bidder.Token += bid.Deposit
+	if err := repository.Save(bidder); err != nil {
+		return err
+	}
+	bid.Status = BidUnlocked
+	if err := PutAuctionBid(stub, bid); err != nil {
+		return err
+	}
+	if err := DeleteEscrow(stub, bid.Bidder, EscrowBid, bid.Auction); err != nil {
+		return err
+	}
+	events.add(EventAuctionBid, AuctionBidEventPayload{
+		Auction: bid.Auction,
+		Bidder:  bid.Bidder,
+		Status:  bid.Status,
+		Deposit: bid.Deposit,
+		Balance: bidder.Token,
+	})
+	return nil
+}
+
+// 数据已售出时不能独占拍卖
+func checkDataUnsold(stub shim.ChaincodeStubInterface, data DataEvidenceRequest) error {
+	resultIterator, err := stub.GetStateByPartialCompositeKey(SaleIndexName, []string{data.Owner, strconv.Itoa(data.Type), data.Title})
+	if err != nil {
+		return LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return LedgerError("Next", err)
+		}
+		record := DataTransferRecord{}
+		if err := UnmarshalRecord(SchemaTransfer, item.Key, item.Value, &record); err != nil {
+			return err
+		}
+		if record.Hash == data.Hash {
+			return NewError(CodeDataSold, ErrorData{"hash": data.Hash})
+		}
+	}
+	return nil
+}
+
+type AuctionContract struct {
+	contractapi.Contract
+	transferContract *TransferContract
+}
+
+func (s *AuctionContract) GetEvaluateTransactions() []string {
+	return []string{"ShowAuction", "ShowAuctions", "ShowAuctionBids"}
+}
+
+// 数据归属方创建拍卖，数据未售出且不在其他拍卖中
+func (s *AuctionContract) CreateAuction(ctx contractapi.TransactionContextInterface, request AuctionRequest) (*Auction, error) {
+
+	stub := ctx.GetStub()
+	owner, err := NewAccountRepository(stub).Get(request.Owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := owner.checkActive(); err != nil {
+		return nil, err
+	}
+	data := DataEvidenceRequest{Type: request.Type, Owner: request.Owner, Title: request.Title, Hash: request.Hash}
+	if _, err := GetDataDescription(stub, data.getDataCompositeKeyAttributes()); err != nil {
+		return nil, err
+	}
+	titleKey, err := GetDataTitleCompositeKey(stub, data.getDataTitleCompositeKeyAttributes())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := GetDataTitle(stub, titleKey); err != nil {
+		return nil, err
+	}
+	auctionID, err := GetDataAuction(stub, data)
+	if err != nil {
+		return nil, err
+	}
+	if auctionID != "" {
+		return nil, NewError(CodeDataExclusive, ErrorData{"hash": data.Hash, "auction": auctionID})
+	}
+	if err := checkDataUnsold(stub, data); err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	if request.CommitEnd <= txTime {
+		return nil, InvalidArgumentError("commitEnd %d isn't after the transaction time %d", request.CommitEnd, txTime)
+	}
+	if request.RevealEnd <= request.CommitEnd {
+		return nil, InvalidArgumentError("revealEnd %d isn't after commitEnd %d", request.RevealEnd, request.CommitEnd)
+	}
+
+	auction := Auction{
+		ID:        stub.GetTxID(),
+		Type:      request.Type,
+		Owner:     request.Owner,
+		Title:     request.Title,
+		Hash:      request.Hash,
+		Reserve:   request.Reserve,
+		CommitEnd: request.CommitEnd,
+		RevealEnd: request.RevealEnd,
+		Status:    AuctionOpen,
+		CreatedAt: txTime,
+	}
+	if err := PutAuction(stub, &auction); err != nil {
+		return nil, err
+	}
+	titleIndexKey, err := CreateCompositeKey(stub, AuctionTitleIndexName, []string{strconv.Itoa(auction.Type), auction.Owner, auction.Title, auction.ID})
+	if err != nil {
+		return nil, err
+	}
+	if err := PutState(stub, titleIndexKey, []byte(auction.ID)); err != nil {
+		return nil, err
+	}
+	dataKey, err := GetAuctionDataCompositeKey(stub, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := PutState(stub, dataKey, []byte(auction.ID)); err != nil {
+		return nil, err
+	}
+	fmt.Printf("createAuction - end %s %s %s %s \n", auction.ID, auction.Owner, auction.Title, auction.Hash)
+
+	events := NewEventBatch(stub)
+	events.add(EventAuctionChanged, auction.toEventPayload())
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &auction, nil
+}
+
+// 提交阶段提交出价承诺并锁定积分，再次提交时替换承诺，按差额锁定或退回积分
+func (s *AuctionContract) CommitBid(ctx contractapi.TransactionContextInterface, request BidCommitRequest) (*AuctionBid, error) {
+
+	stub := ctx.GetStub()
+	auction, err := s.getPhase(stub, request.Auction, AuctionCommit)
+	if err != nil {
+		return nil, err
+	}
+	if request.Bidder == auction.Owner {
+		return nil, InvalidArgumentError("owner can't bid on auction %s", auction.ID)
+	}
+	repository := NewAccountRepository(stub)
+	bidder, err := repository.Get(request.Bidder)
+	if err != nil {
+		return nil, err
+	}
+	if err := bidder.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	if err := bidder.checkActive(); err != nil {
+		return nil, err
+	}
+
+	bid, err := FindAuctionBid(stub, auction.ID, request.Bidder)
+	if err != nil {
+		return nil, err
+	}
+	if bid == nil {
+		bid = &AuctionBid{Auction: auction.ID, Bidder: request.Bidder}
+		auction.Bids++
+	}
+	// 锁定积分不允许透支
+	available := bidder.Token + bid.Deposit
+	if available < request.Deposit {
+		return nil, NewError(CodeTokenInsufficient, ErrorData{"name": bidder.Name, "token": available, "amount": request.Deposit})
+	}
+	if bid.Status == "" {
+		if err := PutAuction(stub, auction); err != nil {
+			return nil, err
+		}
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	bidder.Token = available - request.Deposit
+	if err := repository.Save(bidder); err != nil {
+		return nil, err
+	}
+	bid.Commitment = request.Commitment
+	bid.Deposit = request.Deposit
+	bid.Status = BidLocked
+	bid.CommittedAt = txTime
+	if err := PutAuctionBid(stub, bid); err != nil {
+		return nil, err
+	}
+	if err := PutEscrow(stub, bid.Bidder, EscrowBid, bid.Auction, bid.Deposit); err != nil {
+		return nil, err
+	}
+	fmt.Printf("commitBid - end %s %s %d \n", bid.Auction, bid.Bidder, bid.Deposit)
+
+	events := NewEventBatch(stub)
+	events.add(EventAuctionBid, AuctionBidEventPayload{
+		Auction: bid.Auction,
+		Bidder:  bid.Bidder,
+		Status:  bid.Status,
+		Deposit: bid.Deposit,
+		Balance: bidder.Token,
+	})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return bid, nil
+}
+
+// 揭示阶段公开出价，单价须不低于保留价，费用不超过锁定积分
+func (s *AuctionContract) RevealBid(ctx contractapi.TransactionContextInterface, request BidRevealRequest) (*AuctionBid, error) {
+
+	stub := ctx.GetStub()
+	auction, err := s.getPhase(stub, request.Auction, AuctionReveal)
+	if err != nil {
+		return nil, err
+	}
+	bid, err := GetAuctionBid(stub, auction.ID, request.Bidder)
+	if err != nil {
+		return nil, err
+	}
+	if NewBidCommitment(auction.ID, request.Bidder, request.Price, request.Salt) != bid.Commitment {
+		return nil, NewError(CodeBidMismatch, ErrorData{"id": auction.ID, "bidder": request.Bidder})
+	}
+	data := auction.getDataEvidence()
+	dataDetail, err := GetDataDescription(stub, data.getDataCompositeKeyAttributes())
+	if err != nil {
+		return nil, err
+	}
+	maxPrice := auction.Reserve
+	if dataDetail.Size > 0 {
+		maxPrice = int(bid.Deposit / int64(dataDetail.Size))
+	}
+	if request.Price < auction.Reserve || request.Price > maxPrice {
+		return nil, NewError(CodePriceOutOfRange, ErrorData{"value": request.Price, "min": auction.Reserve, "max": maxPrice})
+	}
+
+	bid.Price = request.Price
+	bid.Status = BidRevealed
+	if err := PutAuctionBid(stub, bid); err != nil {
+		return nil, err
+	}
+	fmt.Printf("revealBid - end %s %s %d \n", bid.Auction, bid.Bidder, bid.Price)
+
+	events := NewEventBatch(stub)
+	events.add(EventAuctionBid, AuctionBidEventPayload{
+		Auction: bid.Auction,
+		Bidder:  bid.Bidder,
+		Status:  bid.Status,
+		Deposit: bid.Deposit,
+		Price:   bid.Price,
+	})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return bid, nil
+}
+
+/*
+ * 揭示结束后结算拍卖，任何人可以调用，选出成交方和成交购买分两次结算提交：
+ * 1. 第一次结算最高有效出价成交，单价相同时先提交者优先，其余出价退回锁定积分，拍卖进入 settling
+ * 2. 再次结算时成交方按成交价购买数据，超过审批阈值时拍卖保持 settling，提案执行后成交
+ * 3. 成交方或数据归属方不能交易、数据或标签已不存在时无法购买，退回成交方锁定积分，拍卖流拍，按原因计入归属方信誉
+ * 4. 提案被取消或过期后再次结算，退回成交方锁定积分，拍卖流拍
+ */
+func (s *AuctionContract) SettleAuction(ctx contractapi.TransactionContextInterface, id string) (*Auction, error) {
+
+	stub := ctx.GetStub()
+	auction, err := GetAuction(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	events := NewEventBatch(stub)
+	switch status := auction.statusAt(txTime); status {
+	case AuctionEnded:
+		if err := s.settle(stub, auction, events); err != nil {
+			return nil, err
+		}
+	case AuctionSettling:
+		if auction.Proposal != "" {
+			if err := s.abandon(stub, auction, txTime, events); err != nil {
+				return nil, err
+			}
+		} else if err := s.purchase(stub, auction, events); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, NewError(CodeAuctionPhase, ErrorData{"id": id, "phase": status})
+	}
+	fmt.Printf("settleAuction - end %s %s %s %d \n", auction.ID, auction.Status, auction.Winner, auction.Price)
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+func (s *AuctionContract) settle(stub shim.ChaincodeStubInterface, auction *Auction, events *EventBatch) error {
+
+	bids, err := s.listBids(stub, auction.ID)
+	if err != nil {
+		return err
+	}
+	var winner *AuctionBid
+	for i := range bids {
+		bid := &bids[i]
+		if bid.Status != BidRevealed {
+			continue
+		}
+		if winner == nil || bid.Price > winner.Price || (bid.Price == winner.Price && bid.CommittedAt < winner.CommittedAt) {
+			winner = bid
+		}
+	}
+	for i := range bids {
+		if winner != nil && bids[i].Bidder == winner.Bidder {
+			continue
+		}
+		if err := unlockBid(stub, &bids[i], events); err != nil {
+			return err
+		}
+	}
+	if winner == nil {
+		return closeAuction(stub, auction, AuctionUnsold, events)
+	}
+
+	winner.Status = BidWon
+	if err := PutAuctionBid(stub, winner); err != nil {
+		return err
+	}
+	auction.Status = AuctionSettling
+	auction.Winner = winner.Bidder
+	auction.Price = winner.Price
+	auction.Deposit = winner.Deposit
+	if err := PutAuction(stub, auction); err != nil {
+		return err
+	}
+	events.add(EventAuctionChanged, auction.toEventPayload())
+	return nil
+}
+
+// 成交方按成交价购买数据，无法购买时流拍
+func (s *AuctionContract) purchase(stub shim.ChaincodeStubInterface, auction *Auction, events *EventBatch) error {
+
+	byOwner := false
+	err := auction.checkWinner(stub)
+	if err == nil {
+		byOwner = true
+		err = auction.checkOwner(stub)
+	}
+	if err != nil {
+		if !isUnpurchasable(err) {
+			return err
+		}
+		fmt.Printf("settleAuction - %s can't be purchased: %s \n", auction.ID, err.Error())
+		return s.refundWinner(stub, auction, byOwner, events)
+	}
+	request := TransferRequest{Buyer: auction.Winner, Data: []DataEvidenceRequest{auction.getDataEvidence()}, Auction: auction.ID}
+	result, err := s.transferContract.transferData(stub, request, nil, auction, events)
+	if err != nil {
+		return err
+	}
+	if result.Proposal != "" {
+		auction.Proposal = result.Proposal
+		return PutAuction(stub, auction)
+	}
+	return nil
+}
+
+// 成交购买的提案已取消或过期时流拍，退回成交方锁定积分
+func (s *AuctionContract) abandon(stub shim.ChaincodeStubInterface, auction *Auction, txTime int64, events *EventBatch) error {
+
+	proposal, err := GetProposal(stub, auction.Proposal)
+	if err != nil {
+		return err
+	}
+	if status := proposal.statusAt(txTime); status == ProposalPending || status == ProposalExecuted {
+		return NewError(CodeAuctionPhase, ErrorData{"id": auction.ID, "phase": auction.Status})
+	}
+	return s.refundWinner(stub, auction, false, events)
+}
+
+// 退回成交方锁定积分，拍卖流拍，byOwner 为归属方原因无法成交
+func (s *AuctionContract) refundWinner(stub shim.ChaincodeStubInterface, auction *Auction, byOwner bool, events *EventBatch) error {
+	bid, err := GetAuctionBid(stub, auction.ID, auction.Winner)
+	if err != nil {
+		return err
+	}
+	if err := unlockBid(stub, bid, events); err != nil {
+		return err
+	}
+	if err := closeAuction(stub, auction, AuctionUnsold, events); err != nil {
+		return err
+	}
+	return RecordAuctionUnsold(stub, auction.Owner, byOwner, events)
+}
+
+// 数据归属方在提交阶段取消拍卖，退回全部锁定积分
+func (s *AuctionContract) CancelAuction(ctx contractapi.TransactionContextInterface, id string, owner string) (*Auction, error) {
+
+	stub := ctx.GetStub()
+	auction, err := s.getPhase(stub, id, AuctionCommit)
+	if err != nil {
+		return nil, err
+	}
+	if owner != auction.Owner {
+		return nil, InvalidArgumentError("%s isn't the owner of auction %s", owner, id)
+	}
+	account, err := NewAccountRepository(stub).Get(owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := account.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	bids, err := s.listBids(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	events := NewEventBatch(stub)
+	for i := range bids {
+		if err := unlockBid(stub, &bids[i], events); err != nil {
+			return nil, err
+		}
+	}
+	if err := closeAuction(stub, auction, AuctionCancelled, events); err != nil {
+		return nil, err
+	}
+	// 已有出价时取消计入归属方信誉
+	if len(bids) > 0 {
+		if err := RecordAuctionUnsold(stub, auction.Owner, true, events); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("cancelAuction - end %s \n", id)
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+func (s *AuctionContract) ShowAuction(ctx contractapi.TransactionContextInterface, id string) (*Auction, error) {
+
+	stub := ctx.GetStub()
+	auction, err := GetAuction(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	auction.Status = auction.statusAt(txTime)
+	return auction, nil
+}
+
+// 查询数据归属方的拍卖，title 为空时返回该类型的全部标签
+func (s *AuctionContract) ShowAuctions(ctx contractapi.TransactionContextInterface, dataType int, owner string, title string) ([]Auction, error) {
+
+	stub := ctx.GetStub()
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []string{strconv.Itoa(dataType), owner}
+	if title != "" {
+		attributes = append(attributes, title)
+	}
+	retDataList := []Auction{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(AuctionTitleIndexName, attributes)
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		auction, err := GetAuction(stub, string(item.Value))
+		if err != nil {
+			return nil, err
+		}
+		auction.Status = auction.statusAt(txTime)
+		retDataList = append(retDataList, *auction)
+	}
+	return retDataList, nil
+}
+
+// 查询拍卖的全部出价，按竞拍方排序
+func (s *AuctionContract) ShowAuctionBids(ctx contractapi.TransactionContextInterface, id string) ([]AuctionBid, error) {
+	return s.listBids(ctx.GetStub(), id)
+}
+
+func (s *AuctionContract) listBids(stub shim.ChaincodeStubInterface, id string) ([]AuctionBid, error) {
+
+	retDataList := []AuctionBid{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(AuctionBidIndexName, []string{id})
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		bid := AuctionBid{}
+		if err := UnmarshalRecord(SchemaAuctionBid, item.Key, item.Value, &bid); err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, bid)
+	}
+	return retDataList, nil
+}
+
+// 读取处于指定阶段的拍卖
+func (s *AuctionContract) getPhase(stub shim.ChaincodeStubInterface, id, phase string) (*Auction, error) {
+	auction, err := GetAuction(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	if status := auction.statusAt(txTime); status != phase {
+		return nil, NewError(CodeAuctionPhase, ErrorData{"id": id, "phase": status})
+	}
+	return auction, nil
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 数据需求悬赏实现：
+ * 1. 买方发布数据需求，说明数据类型、需求描述、悬赏积分和截止时间，悬赏积分从买方账户锁定
+ * 2. 截止时间前数据提供方通过 setDataEvidence 登记数据后响应需求，关联登记的数据
+ * 3. 买方选定一条响应发放悬赏，与 transferData 相同记录购买，单价为悬赏积分 / 数据条数（向下取整）
+ *    锁定积分在购买时退回买方后支付，取整余数留在买方账户，超过审批阈值时等待提案执行
+ * 4. 买方可以随时关闭未发放的需求，退回锁定积分；发放的提案被取消或过期后同样可以关闭
+ * 5. 发布、发放和关闭需求须由买方提交
+ */
+
+// 需求状态
+const (
+	BountyOpen     = "open"
+	BountyAwarding = "awarding"
+	BountyAwarded  = "awarded"
+	BountyClosed   = "closed"
+)
+
+type Bounty struct {
+	ID          string `json:"id"`                                              /*需求编号，发布需求的交易ID*/
+	Buyer       string `json:"buyer"`                                           /*买方账户*/
+	Type        int    `json:"type"`                                            /*数据类型*/
+	Description string `json:"description"`                                     /*需求描述*/
+	Budget      int64  `json:"budget"`                                          /*悬赏积分，发布时锁定*/
+	Deadline    int64  `json:"deadline"`                                        /*响应截止时间*/
+	Status      string `json:"status"`                                          /*状态：open、awarding、awarded、closed*/
+	Responses   int    `json:"responses"`                                       /*响应数量*/
+	Owner       string `json:"owner,omitempty" metadata:"owner,optional"`       /*获得悬赏的数据提供方*/
+	Title       string `json:"title,omitempty" metadata:"title,optional"`       /*获得悬赏的数据标签*/
+	Hash        string `json:"hash,omitempty" metadata:"hash,optional"`         /*获得悬赏的数据Hash*/
+	Price       int    `json:"price,omitempty" metadata:"price,optional"`       /*购买单价*/
+	Proposal    string `json:"proposal,omitempty" metadata:"proposal,optional"` /*购买超过审批阈值时的待审批提案编号*/
+	CreatedAt   int64  `json:"createdAt"`                                       /*发布时间*/
+	ClosedAt    int64  `json:"closedAt,omitempty" metadata:"closedAt,optional"` /*发放或关闭时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (b *Bounty) toBytes() []byte {
+	b.SchemaVersion = CurrentSchemaVersion(SchemaBounty)
+	dataAsBytes, _ := json.Marshal(b)
+	return dataAsBytes
+}
+
+func (b *Bounty) getDataEvidence() DataEvidenceRequest {
+	return DataEvidenceRequest{Type: b.Type, Owner: b.Owner, Title: b.Title, Hash: b.Hash}
+}
+
+// 发放悬赏的交易请求只能包含选定的数据，由买方自行支付
+func (b *Bounty) matches(request TransferRequest) error {
+	if request.Buyer != b.Buyer || len(request.Data) != 1 || request.Data[0] != b.getDataEvidence() {
+		return InvalidArgumentError("request doesn't match bounty %s", b.ID)
+	}
+	if request.Org != "" || request.Spender != "" {
+		return InvalidArgumentError("bounty %s must be paid by the buyer", b.ID)
+	}
+	return nil
+}
+
+func (b *Bounty) unitPrice(price *DataTitlePrice) (int, error) {
+	return b.Price, nil
+}
+
+// 退回锁定的悬赏积分，随后按购买单价支付
+func (b *Bounty) release(buyer *Account, events *EventBatch) {
+	buyer.Token += b.Budget
+	events.add(EventBountyBudget, BountyBudgetEventPayload{
+		Bounty:  b.ID,
+		Buyer:   buyer.Name,
+		Status:  BidUnlocked,
+		Budget:  b.Budget,
+		Balance: buyer.Token,
+	})
+}
+
+func (b *Bounty) complete(stub shim.ChaincodeStubInterface, events *EventBatch) error {
+	if err := DeleteEscrow(stub, b.Buyer, EscrowBounty, b.ID); err != nil {
+		return err
+	}
+	return closeBounty(stub, b, BountyAwarded, events)
+}
+
+func (b *Bounty) toEventPayload() BountyEventPayload {
+	return BountyEventPayload{
+		ID:          b.ID,
+		Buyer:       b.Buyer,
+		Type:        b.Type,
+		Description: b.Description,
+		Budget:      b.Budget,
+		Deadline:    b.Deadline,
+		Status:      b.Status,
+		Owner:       b.Owner,
+		Title:       b.Title,
+		Hash:        b.Hash,
+		Price:       b.Price,
+	}
+}
+
+type BountyResponse struct {
+	Bounty    string `json:"bounty"`    /*需求编号*/
+	Owner     string `json:"owner"`     /*数据提供方，数据归属方*/
+	Title     string `json:"title"`     /*数据标签名称*/
+	Hash      string `json:"hash"`      /*数据Hash*/
+	Size      int    `json:"size"`      /*响应时的数据记录条数*/
+	CreatedAt int64  `json:"createdAt"` /*响应时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (r *BountyResponse) toBytes() []byte {
+	r.SchemaVersion = CurrentSchemaVersion(SchemaBountyResponse)
+	dataAsBytes, _ := json.Marshal(r)
+	return dataAsBytes
+}
+
+type BountyRequest struct {
+	Buyer       string `json:"buyer" validate:"required,max=64,charset=name"`         /*买方账户*/
+	Type        int    `json:"type" validate:"min=0,max=65535"`                       /*数据类型*/
+	Description string `json:"description" validate:"required,max=1024,charset=text"` /*需求描述*/
+	Budget      int64  `json:"budget" validate:"min=1,max=1000000000000"`             /*悬赏积分*/
+	Deadline    int64  `json:"deadline" validate:"min=1"`                             /*响应截止时间*/
+}
+
+type BountyResponseRequest struct {
+	Bounty string `json:"bounty" validate:"required,max=128,charset=name"` /*需求编号*/
+	Owner  string `json:"owner" validate:"required,max=64,charset=name"`   /*数据提供方*/
+	Title  string `json:"title" validate:"required,max=128,charset=text"`  /*数据标签名称*/
+	Hash   string `json:"hash" validate:"required,max=128,charset=hex"`    /*数据Hash*/
+}
+
+type BountyAwardRequest struct {
+	Bounty string `json:"bounty" validate:"required,max=128,charset=name"` /*需求编号*/
+	Buyer  string `json:"buyer" validate:"required,max=64,charset=name"`   /*买方账户*/
+	Owner  string `json:"owner" validate:"required,max=64,charset=name"`   /*选定响应的数据提供方*/
+	Title  string `json:"title" validate:"required,max=128,charset=text"`  /*选定响应的数据标签名称*/
+	Hash   string `json:"hash" validate:"required,max=128,charset=hex"`    /*选定响应的数据Hash*/
+}
+
+const BountyIndexName = "bounty"
+
+func GetBountyCompositeKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return CreateCompositeKey(stub, BountyIndexName, []string{id})
+}
+
+// 需求类型索引：数据类型、需求编号，值为需求编号
+const BountyTypeIndexName = "bountyType"
+
+// 买方需求索引：买方、需求编号，值为需求编号
+const BountyBuyerIndexName = "bountyBuyer"
+
+// 需求响应：需求编号、数据提供方、标签、数据Hash
+const BountyResponseIndexName = "bountyResponse"
+
+func GetBountyResponseCompositeKey(stub shim.ChaincodeStubInterface, bounty, owner, title, hash string) (string, error) {
+	return CreateCompositeKey(stub, BountyResponseIndexName, []string{bounty, owner, title, hash})
+}
+
+func GetBounty(stub shim.ChaincodeStubInterface, id string) (*Bounty, error) {
+	bountyKey, err := GetBountyCompositeKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	bountyAsBytes, err := GetState(stub, bountyKey)
+	if err != nil {
+		return nil, err
+	}
+	if bountyAsBytes == nil {
+		return nil, NewError(CodeBountyNotFound, ErrorData{"id": id})
+	}
+	bounty := Bounty{}
+	if err := UnmarshalRecord(SchemaBounty, bountyKey, bountyAsBytes, &bounty); err != nil {
+		return nil, err
+	}
+	return &bounty, nil
+}
+
+func PutBounty(stub shim.ChaincodeStubInterface, bounty *Bounty) error {
+	bountyKey, err := GetBountyCompositeKey(stub, bounty.ID)
+	if err != nil {
+		return err
+	}
+	return PutState(stub, bountyKey, bounty.toBytes())
+}
+
+// 读取已选定响应、等待购买的需求
+func GetAwardingBounty(stub shim.ChaincodeStubInterface, id string) (*Bounty, error) {
+	bounty, err := GetBounty(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if bounty.Status != BountyAwarding {
+		return nil, NewError(CodeBountyClosed, ErrorData{"id": id, "status": bounty.Status})
+	}
+	return bounty, nil
+}
+
+// 读取未发放、未关闭的需求
+func GetOpenBounty(stub shim.ChaincodeStubInterface, id string) (*Bounty, error) {
+	bounty, err := GetBounty(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if bounty.Status != BountyOpen {
+		return nil, NewError(CodeBountyClosed, ErrorData{"id": id, "status": bounty.Status})
+	}
+	return bounty, nil
+}
+
+func GetBountyResponse(stub shim.ChaincodeStubInterface, bounty, owner, title, hash string) (*BountyResponse, error) {
+	responseKey, err := GetBountyResponseCompositeKey(stub, bounty, owner, title, hash)
+	if err != nil {
+		return nil, err
+	}
+	responseAsBytes, err := GetState(stub, responseKey)
+	if err != nil {
+		return nil, err
+	}
+	if responseAsBytes == nil {
+		return nil, NewError(CodeBountyResponseNotFound, ErrorData{"id": bounty, "hash": hash})
+	}
+	response := BountyResponse{}
+	if err := UnmarshalRecord(SchemaBountyResponse, responseKey, responseAsBytes, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// 关闭需求：发放完成或买方关闭
+func closeBounty(stub shim.ChaincodeStubInterface, bounty *Bounty, status string, events *EventBatch) error {
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return err
+	}
+	bounty.Status = status
+	bounty.ClosedAt = txTime
+	if err := PutBounty(stub, bounty); err != nil {
+		return err
+	}
+	events.add(EventBountyChanged, bounty.toEventPayload())
+	return nil
+}
+
+// 悬赏积分按数据条数折算的单价，不足 1 时不能购买
+func bountyUnitPrice(bounty *Bounty, size int) (int, error) {
+	if size < 1 || bounty.Budget < int64(size) {
+		return 0, InvalidArgumentError("budget %d of bounty %s can't pay for %d records", bounty.Budget, bounty.ID, size)
+	}
+	return int(bounty.Budget / int64(size)), nil
+}
+
+type BountyContract struct {
+	contractapi.Contract
+	transferContract *TransferContract
+}
+
+func (s *BountyContract) GetEvaluateTransactions() []string {
+	return []string{"ShowBounty", "ShowBounties", "ShowBuyerBounties", "ShowBountyResponses"}
+}
+
+// 发布数据需求，锁定悬赏积分，不允许透支
+func (s *BountyContract) PostBounty(ctx contractapi.TransactionContextInterface, request BountyRequest) (*Bounty, error) {
+
+	stub := ctx.GetStub()
+	repository := NewAccountRepository(stub)
+	buyer, err := repository.Get(request.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	if err := buyer.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	if err := buyer.checkActive(); err != nil {
+		return nil, err
+	}
+	if buyer.Token < request.Budget {
+		return nil, NewError(CodeTokenInsufficient, ErrorData{"name": buyer.Name, "token": buyer.Token, "amount": request.Budget})
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	if request.Deadline <= txTime {
+		return nil, InvalidArgumentError("deadline %d isn't after the transaction time %d", request.Deadline, txTime)
+	}
+
+	bounty := Bounty{
+		ID:          stub.GetTxID(),
+		Buyer:       request.Buyer,
+		Type:        request.Type,
+		Description: request.Description,
+		Budget:      request.Budget,
+		Deadline:    request.Deadline,
+		Status:      BountyOpen,
+		CreatedAt:   txTime,
+	}
+	buyer.Token -= bounty.Budget
+	if err := repository.Save(buyer); err != nil {
+		return nil, err
+	}
+	if err := PutBounty(stub, &bounty); err != nil {
+		return nil, err
+	}
+	if err := PutEscrow(stub, bounty.Buyer, EscrowBounty, bounty.ID, bounty.Budget); err != nil {
+		return nil, err
+	}
+	typeKey, err := CreateCompositeKey(stub, BountyTypeIndexName, []string{strconv.Itoa(bounty.Type), bounty.ID})
+	if err != nil {
+		return nil, err
+	}
+	if err := PutState(stub, typeKey, []byte(bounty.ID)); err != nil {
+		return nil, err
+	}
+	buyerKey, err := CreateCompositeKey(stub, BountyBuyerIndexName, []string{bounty.Buyer, bounty.ID})
+	if err != nil {
+		return nil, err
+	}
+	if err := PutState(stub, buyerKey, []byte(bounty.ID)); err != nil {
+		return nil, err
+	}
+	fmt.Printf("postBounty - end %s %s %d \n", bounty.ID, bounty.Buyer, bounty.Budget)
+
+	events := NewEventBatch(stub)
+	events.add(EventBountyChanged, bounty.toEventPayload())
+	events.add(EventBountyBudget, BountyBudgetEventPayload{
+		Bounty:  bounty.ID,
+		Buyer:   buyer.Name,
+		Status:  BidLocked,
+		Budget:  bounty.Budget,
+		Balance: buyer.Token,
+	})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &bounty, nil
+}
+
+// 数据提供方在截止时间前响应需求，数据须已登记且类型与需求一致
+func (s *BountyContract) RespondBounty(ctx contractapi.TransactionContextInterface, request BountyResponseRequest) (*BountyResponse, error) {
+
+	stub := ctx.GetStub()
+	bounty, err := GetOpenBounty(stub, request.Bounty)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	if txTime > bounty.Deadline {
+		return nil, NewError(CodeBountyDeadline, ErrorData{"id": bounty.ID, "deadline": bounty.Deadline})
+	}
+	if request.Owner == bounty.Buyer {
+		return nil, InvalidArgumentError("buyer can't respond to bounty %s", bounty.ID)
+	}
+	provider, err := NewAccountRepository(stub).Get(request.Owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.checkActive(); err != nil {
+		return nil, err
+	}
+	data := DataEvidenceRequest{Type: bounty.Type, Owner: request.Owner, Title: request.Title, Hash: request.Hash}
+	dataDetail, err := GetDataDescription(stub, data.getDataCompositeKeyAttributes())
+	if err != nil {
+		return nil, err
+	}
+	titleKey, err := GetDataTitleCompositeKey(stub, data.getDataTitleCompositeKeyAttributes())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := GetDataTitle(stub, titleKey); err != nil {
+		return nil, err
+	}
+	if _, err := bountyUnitPrice(bounty, dataDetail.Size); err != nil {
+		return nil, err
+	}
+
+	responseKey, err := GetBountyResponseCompositeKey(stub, bounty.ID, data.Owner, data.Title, data.Hash)
+	if err != nil {
+		return nil, err
+	}
+	exist, err := GetState(stub, responseKey)
+	if err != nil {
+		return nil, err
+	}
+	if exist == nil {
+		bounty.Responses++
+		if err := PutBounty(stub, bounty); err != nil {
+			return nil, err
+		}
+	}
+	response := BountyResponse{
+		Bounty:    bounty.ID,
+		Owner:     data.Owner,
+		Title:     data.Title,
+		Hash:      data.Hash,
+		Size:      dataDetail.Size,
+		CreatedAt: txTime,
+	}
+	if err := PutState(stub, responseKey, response.toBytes()); err != nil {
+		return nil, err
+	}
+	fmt.Printf("respondBounty - end %s %s %s \n", bounty.ID, response.Owner, response.Hash)
+
+	events := NewEventBatch(stub)
+	events.add(EventBountyResponded, BountyResponseEventPayload{
+		Bounty: response.Bounty,
+		Owner:  response.Owner,
+		Title:  response.Title,
+		Hash:   response.Hash,
+		Size:   response.Size,
+	})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// 买方选定响应发放悬赏，记录购买，返回买方积分，超过审批阈值时返回提案编号
+func (s *BountyContract) AwardBounty(ctx contractapi.TransactionContextInterface, request BountyAwardRequest) (*AccountTokenResponse, error) {
+
+	stub := ctx.GetStub()
+	bounty, err := GetOpenBounty(stub, request.Bounty)
+	if err != nil {
+		return nil, err
+	}
+	if request.Buyer != bounty.Buyer {
+		return nil, InvalidArgumentError("%s isn't the buyer of bounty %s", request.Buyer, bounty.ID)
+	}
+	buyer, err := NewAccountRepository(stub).Get(bounty.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	if err := buyer.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	response, err := GetBountyResponse(stub, bounty.ID, request.Owner, request.Title, request.Hash)
+	if err != nil {
+		return nil, err
+	}
+	data := DataEvidenceRequest{Type: bounty.Type, Owner: response.Owner, Title: response.Title, Hash: response.Hash}
+	dataDetail, err := GetDataDescription(stub, data.getDataCompositeKeyAttributes())
+	if err != nil {
+		return nil, err
+	}
+	price, err := bountyUnitPrice(bounty, dataDetail.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	events := NewEventBatch(stub)
+	bounty.Status = BountyAwarding
+	bounty.Owner = response.Owner
+	bounty.Title = response.Title
+	bounty.Hash = response.Hash
+	bounty.Price = price
+	if err := PutBounty(stub, bounty); err != nil {
+		return nil, err
+	}
+	events.add(EventBountyChanged, bounty.toEventPayload())
+
+	transferRequest := TransferRequest{Buyer: bounty.Buyer, Data: []DataEvidenceRequest{data}, Bounty: bounty.ID}
+	result, err := s.transferContract.transferData(stub, transferRequest, nil, bounty, events)
+	if err != nil {
+		return nil, err
+	}
+	if result.Proposal != "" {
+		bounty.Proposal = result.Proposal
+		if err := PutBounty(stub, bounty); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("awardBounty - end %s %s %s %d \n", bounty.ID, bounty.Owner, bounty.Hash, bounty.Price)
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 买方关闭需求并退回锁定积分，发放中的需求须其提案已取消或过期
+func (s *BountyContract) CloseBounty(ctx contractapi.TransactionContextInterface, id string, buyer string) (*Bounty, error) {
+
+	stub := ctx.GetStub()
+	bounty, err := GetBounty(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if buyer != bounty.Buyer {
+		return nil, InvalidArgumentError("%s isn't the buyer of bounty %s", buyer, id)
+	}
+	// 锁定积分期间买方账户不能注销，退回前仍校验账户未注销
+	repository := NewAccountRepository(stub)
+	account, err := repository.GetOpen(bounty.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	if err := account.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	switch bounty.Status {
+	case BountyOpen:
+	case BountyAwarding:
+		txTime, err := GetTxTimeUnix(stub)
+		if err != nil {
+			return nil, err
+		}
+		proposal, err := GetProposal(stub, bounty.Proposal)
+		if err != nil {
+			return nil, err
+		}
+		if status := proposal.statusAt(txTime); status == ProposalPending || status == ProposalExecuted {
+			return nil, NewError(CodeBountyClosed, ErrorData{"id": id, "status": bounty.Status})
+		}
+	default:
+		return nil, NewError(CodeBountyClosed, ErrorData{"id": id, "status": bounty.Status})
+	}
+
+	account.Token += bounty.Budget
+	if err := repository.Save(account); err != nil {
+		return nil, err
+	}
+	if err := DeleteEscrow(stub, bounty.Buyer, EscrowBounty, bounty.ID); err != nil {
+		return nil, err
+	}
+	events := NewEventBatch(stub)
+	events.add(EventBountyBudget, BountyBudgetEventPayload{
+		Bounty:  bounty.ID,
+		Buyer:   account.Name,
+		Status:  BidUnlocked,
+		Budget:  bounty.Budget,
+		Balance: account.Token,
+	})
+	if err := closeBounty(stub, bounty, BountyClosed, events); err != nil {
+		return nil, err
+	}
+	fmt.Printf("closeBounty - end %s \n", id)
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return bounty, nil
+}
+
+func (s *BountyContract) ShowBounty(ctx contractapi.TransactionContextInterface, id string) (*Bounty, error) {
+	return GetBounty(ctx.GetStub(), id)
+}
+
+// 查询数据类型下的需求，openOnly 为 true 时只返回截止时间前可以响应的需求
+func (s *BountyContract) ShowBounties(ctx contractapi.TransactionContextInterface, dataType int, openOnly bool) ([]Bounty, error) {
+
+	stub := ctx.GetStub()
+	bounties, err := s.listBounties(stub, BountyTypeIndexName, []string{strconv.Itoa(dataType)})
+	if err != nil || !openOnly {
+		return bounties, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	retDataList := []Bounty{}
+	for _, bounty := range bounties {
+		if bounty.Status == BountyOpen && txTime <= bounty.Deadline {
+			retDataList = append(retDataList, bounty)
+		}
+	}
+	return retDataList, nil
+}
+
+// 查询买方发布的全部需求
+func (s *BountyContract) ShowBuyerBounties(ctx contractapi.TransactionContextInterface, buyer string) ([]Bounty, error) {
+	return s.listBounties(ctx.GetStub(), BountyBuyerIndexName, []string{buyer})
+}
+
+// 查询需求的全部响应，按数据提供方、标签、数据Hash排序
+func (s *BountyContract) ShowBountyResponses(ctx contractapi.TransactionContextInterface, id string) ([]BountyResponse, error) {
+
+	stub := ctx.GetStub()
+	retDataList := []BountyResponse{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(BountyResponseIndexName, []string{id})
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		response := BountyResponse{}
+		if err := UnmarshalRecord(SchemaBountyResponse, item.Key, item.Value, &response); err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, response)
+	}
+	return retDataList, nil
+}
+
+func (s *BountyContract) listBounties(stub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]Bounty, error) {
+
+	retDataList := []Bounty{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		bounty, err := GetBounty(stub, string(item.Value))
+		if err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, *bounty)
+	}
+	return retDataList, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/*
+ * 通道配置合约实现：
+ * 1. 合约在每个通道独立部署，配置保存在通道账本中
+ * 2. 默认语言配置，由管理员设置
+ * 3. 账本数据版本迁移，见 schemaManager.go
+ * 4. 大额交易审批策略，见 proposalManager.go
+ */
+
+const ConfigIndexName = "config"
+
+const ConfigLanguage = "language"
+
+func GetConfigCompositeKey(stub shim.ChaincodeStubInterface, name string) (string, error) {
+	return CreateCompositeKey(stub, ConfigIndexName, []string{name})
+}
+
+type ConfigContract struct {
+	contractapi.Contract
+}
+
+func (s *ConfigContract) GetEvaluateTransactions() []string {
+	return []string{"ShowLanguage", "ShowSchemaVersion", "ShowApprovalPolicy"}
+}
+
+// 管理员设置通道默认语言
+func (s *ConfigContract) SetLanguage(ctx contractapi.TransactionContextInterface, language string) error {
+
+	if !IsSupportedLanguage(language) {
+		return NewError(CodeUnsupportedLang, ErrorData{"language": language})
+	}
+
+	stub := ctx.GetStub()
+	if err := RequireAdmin(stub); err != nil {
+		return err
+	}
+	configKey, err := GetConfigCompositeKey(stub, ConfigLanguage)
+	if err != nil {
+		return err
+	}
+	if err := PutState(stub, configKey, []byte(language)); err != nil {
+		return err
+	}
+	fmt.Printf("setLanguage - end %s \n", language)
+
+	events := NewEventBatch(stub)
+	events.add(EventConfigChanged, ConfigEventPayload{Name: ConfigLanguage, Value: language})
+	return events.emit()
+}
+
+func (s *ConfigContract) ShowLanguage(ctx contractapi.TransactionContextInterface) (string, error) {
+	return GetChannelLanguage(ctx.GetStub()), nil
+}
@@ -12,8 +12,9 @@ import (
  */
 
 type AccountTokenResponse struct {
-	Name  string `json:"name"`
-	Token int64  `json:"token"`
+	Name     string `json:"name"`
+	Token    int64  `json:"token"`
+	Proposal string `json:"proposal,omitempty" metadata:"proposal,optional"` /*超过审批阈值时返回待审批提案编号，交易未执行*/
 }
 
 func (a *AccountTokenResponse) toBytes() []byte {
@@ -22,10 +23,10 @@ func (a *AccountTokenResponse) toBytes() []byte {
 }
 
 type DataEvidenceRequest struct {
-	Type  int    `json:"type"`  /*数据类型*/
-	Owner string `json:"owner"` /*数据归属方*/
-	Title string `json:"title"` /*数据标签名称*/
-	Hash  string `json:"hash"`  /*数据Hash*/
+	Type  int    `json:"type" validate:"min=0,max=65535"`                /*数据类型*/
+	Owner string `json:"owner" validate:"required,max=64,charset=name"`  /*数据归属方*/
+	Title string `json:"title" validate:"required,max=128,charset=text"` /*数据标签名称*/
+	Hash  string `json:"hash" validate:"required,max=128,charset=hex"`   /*数据Hash*/
 }
 
 func (d *DataEvidenceRequest) getDataCompositeKeyAttributes() []string {
@@ -41,4 +42,14 @@ func (d *DataEvidenceRequest) getDataTitleCompositeKeyAttributes() []string {
 func (d *DataEvidenceRequest) getDataTransferCompositeKeyAttributes(buyer string) []string {
 	attributes := []string{buyer, strconv.Itoa(d.Type), d.Owner, d.Title}
 	return attributes
-}
\ No newline at end of file
+}
+
+func (d *DataEvidenceRequest) getDataTransferTimeCompositeKeyAttributes(buyer string, timeUnix int64) []string {
+	attributes := []string{buyer, FormatTimeUnix(timeUnix), strconv.Itoa(d.Type), d.Owner, d.Title}
+	return attributes
+}
+
+func (d *DataEvidenceRequest) getDataSaleCompositeKeyAttributes(buyer string) []string {
+	attributes := []string{d.Owner, strconv.Itoa(d.Type), d.Title, buyer}
+	return attributes
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 数据密钥交换合约实现：
+ * 1. 买方在账户上登记加密公钥(setEncryptKey)
+ * 2. 交易完成后，数据归属方用买方公钥加密数据对称密钥并上链
+ * 登记公钥和上链数据密钥的交易提交方必须与账户地址一致
+ * 3. 买方校验交易记录后获取加密的数据密钥
+ */
+
+type DataKeyRequest struct {
+	Buyer      string `json:"buyer" validate:"required,max=64,charset=name"`                                          /*买方账户*/
+	Type       int    `json:"type" validate:"min=0,max=65535"`                                                        /*数据类型*/
+	Owner      string `json:"owner" validate:"required,max=64,charset=name"`                                          /*数据归属方*/
+	Title      string `json:"title" validate:"required,max=128,charset=text"`                                         /*数据标签名称*/
+	Hash       string `json:"hash" validate:"required,max=128,charset=hex"`                                           /*数据Hash*/
+	WrappedKey string `json:"wrappedKey,omitempty" metadata:"wrappedKey,optional" validate:"max=8192,charset=base64"` /*买方公钥加密后的数据密钥，Base64编码，查询时不填写*/
+}
+
+func (d *DataKeyRequest) getDataKeyCompositeKeyAttributes() []string {
+	attributes := []string{d.Buyer, strconv.Itoa(d.Type), d.Owner, d.Title, d.Hash}
+	return attributes
+}
+
+func (d *DataKeyRequest) toTransferCheckRequest() (*TransferCheckRequest, DownloadTitle) {
+	request := &TransferCheckRequest{Buyer: d.Buyer, Type: d.Type, Owner: d.Owner}
+	return request, DownloadTitle{Title: d.Title, Hash: d.Hash}
+}
+
+type DataKeyRecord struct {
+	WrappedKey  string `json:"wrappedKey"`  /*买方公钥加密后的数据密钥，Base64编码*/
+	Fingerprint string `json:"fingerprint"` /*加密使用的买方公钥指纹*/
+	Time        int64  `json:"time"`        /*上链时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (d *DataKeyRecord) toBytes() []byte {
+	d.SchemaVersion = CurrentSchemaVersion(SchemaDataKey)
+	dataAsBytes, _ := json.Marshal(d)
+	return dataAsBytes
+}
+
+type DataKeyResponse struct {
+	Request            DataKeyRequest `json:"request"`
+	Record             DataKeyRecord  `json:"record"`
+	CurrentFingerprint string         `json:"currentFingerprint"` /*买方当前公钥指纹，与记录不一致时需重新获取密钥*/
+}
+
+const DataKeyIndexName = "dataKey"
+
+func GetDataKeyCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, DataKeyIndexName, attributes)
+}
+
+type DataKeyContract struct {
+	contractapi.Contract
+	transferContract *TransferContract
+}
+
+func (s *DataKeyContract) GetEvaluateTransactions() []string {
+	return []string{"FetchDataKey"}
+}
+
+func (s *DataKeyContract) PostDataKey(ctx contractapi.TransactionContextInterface, request DataKeyRequest) error {
+
+	if wrappedKey, err := base64.StdEncoding.DecodeString(request.WrappedKey); err != nil || len(wrappedKey) == 0 {
+		return NewError(CodeInvalidWrappedKey, nil)
+	}
+
+	stub := ctx.GetStub()
+	checkRequest, data := request.toTransferCheckRequest()
+	if _, _, _, err := s.transferContract.verifyTransferred(stub, checkRequest, data); err != nil {
+		return err
+	}
+
+	repository := NewAccountRepository(stub)
+	owner, err := repository.Get(request.Owner)
+	if err != nil {
+		return err
+	}
+	if err := owner.checkActive(); err != nil {
+		return err
+	}
+	if err := owner.checkCreator(stub); err != nil {
+		return err
+	}
+	buyer, err := repository.GetOpen(request.Buyer)
+	if err != nil {
+		return err
+	}
+	if buyer.EncKey == "" {
+		return NewError(CodeEncryptKeyNotSet, ErrorData{"name": buyer.Name})
+	}
+
+	timeUnix, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return err
+	}
+	record := DataKeyRecord{
+		WrappedKey:  request.WrappedKey,
+		Fingerprint: KeyFingerprint(buyer.EncKey),
+		Time:        timeUnix,
+	}
+	dataKey, err := GetDataKeyCompositeKey(stub, request.getDataKeyCompositeKeyAttributes())
+	if err != nil {
+		return err
+	}
+	if err := PutState(stub, dataKey, record.toBytes()); err != nil {
+		return err
+	}
+
+	events := NewEventBatch(stub)
+	events.add(EventDataKeyPosted, DataKeyEventPayload{
+		Buyer:       request.Buyer,
+		Type:        request.Type,
+		Owner:       request.Owner,
+		Title:       request.Title,
+		Hash:        request.Hash,
+		Fingerprint: record.Fingerprint,
+	})
+	return events.emit()
+}
+
+func (s *DataKeyContract) FetchDataKey(ctx contractapi.TransactionContextInterface, request DataKeyRequest) (*DataKeyResponse, error) {
+
+	stub := ctx.GetStub()
+	request.WrappedKey = ""
+
+	// 先校验交易记录，未购买的数据不返回密钥
+	checkRequest, data := request.toTransferCheckRequest()
+	if _, _, _, err := s.transferContract.verifyTransferred(stub, checkRequest, data); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := GetDataKeyCompositeKey(stub, request.getDataKeyCompositeKeyAttributes())
+	if err != nil {
+		return nil, err
+	}
+	recordAsBytes, err := GetState(stub, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if recordAsBytes == nil {
+		return nil, NewError(CodeDataKeyNotFound, ErrorData{"hash": request.Hash})
+	}
+	var record DataKeyRecord
+	if err := UnmarshalRecord(SchemaDataKey, dataKey, recordAsBytes, &record); err != nil {
+		return nil, err
+	}
+
+	buyer, err := NewAccountRepository(stub).Get(request.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKeyResponse{
+		Request:            request,
+		Record:             record,
+		CurrentFingerprint: KeyFingerprint(buyer.EncKey),
+	}, nil
+}
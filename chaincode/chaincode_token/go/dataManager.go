@@ -1,10 +1,11 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
-	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"strconv"
 )
 
@@ -25,44 +26,98 @@ func (d *DataRequest) toString() string {
 	return string(dataAsBytes)
 }
 
+// 数据扩展信息通过 transient 字段提交，保存在归属方组织的私有数据集合中，公开状态只保存其Hash
+const TransientExtendKey = "extend"
+
+// 扩展信息的随机盐值，由客户端通过 transient 字段提交，与扩展信息一起保存在私有数据集合，防止通过公开Hash穷举扩展信息
+const (
+	TransientSaltKey = "salt"
+	extendSaltMin    = 16
+	extendSaltMax    = 64
+)
+
 type DataDescription struct {
-	Size   int    `json:"size"`             /*文件记录条数*/
-	Extend string `json:"extend,omitempty"` /*数据其他扩展信息，JSON格式数据，供数据方使用*/
+	Size       int    `json:"size" validate:"min=1,max=1000000000"`                                 /*文件记录条数*/
+	Extend     string `json:"extend,omitempty" metadata:"extend,optional" validate:"max=4096"`      /*数据其他扩展信息，仅兼容历史数据，新数据保存在私有数据集合*/
+	ExtendHash string `json:"extendHash,omitempty" metadata:"extendHash,optional" validate:"empty"` /*私有扩展信息的SHA256*/
+	Collection string `json:"collection,omitempty" metadata:"collection,optional" validate:"empty"` /*私有扩展信息所在集合*/
+
+	SchemaVersion int `json:"schemaVersion" metadata:"schemaVersion,optional" validate:"min=0,max=0"` /*账本记录版本*/
 }
 
+// 写入账本时使用当前记录版本
 func (d *DataDescription) toBytes() []byte {
+	d.SchemaVersion = CurrentSchemaVersion(SchemaData)
 	dataAsBytes, _ := json.Marshal(d)
 	return dataAsBytes
 }
 
-func GetDataCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, string) {
-	indexName := "data"
-	indexKey, err := stub.CreateCompositeKey(indexName, attributes)
+const DataIndexName = "data"
+
+func GetDataCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, DataIndexName, attributes)
+}
+
+type DataPrivateExtend struct {
+	Extend string `json:"extend"`         /*数据其他扩展信息，JSON格式数据，供数据方使用*/
+	Salt   string `json:"salt,omitempty"` /*随机盐值，hex编码，盐值实现前的数据为空*/
+}
+
+// 私有扩展信息序列化结果的SHA256，序列化结果包含盐值
+func extendHash(extend []byte) string {
+	hashUtil := HashUtil{algor: SHA256}
+	return hashUtil.checksum(extend)
+}
+
+// 读取私有扩展信息并校验Hash，本节点不持有该集合数据时返回错误
+func GetDataPrivateExtend(stub shim.ChaincodeStubInterface, dataKey string, description *DataDescription) (string, error) {
+	if description.Collection == "" {
+		return description.Extend, nil
+	}
+
+	extendAsBytes, err := stub.GetPrivateData(description.Collection, dataKey)
 	if err != nil {
-		fmt.Printf("GetDataCompositeKey error: %s \n", err.Error())
+		return "", LedgerError("GetPrivateData", err)
+	}
+	if extendAsBytes == nil {
+		return "", NewError(CodePrivateExtendMissing, ErrorData{"hash": description.ExtendHash})
+	}
+	if extendHash(extendAsBytes) != description.ExtendHash {
+		return "", NewError(CodePrivateExtendMismatch, ErrorData{"hash": description.ExtendHash})
+	}
+
+	privateExtend := DataPrivateExtend{}
+	if err := json.Unmarshal(extendAsBytes, &privateExtend); err != nil {
+		return "", CorruptedStateError(dataKey)
 	}
-	return indexKey, indexName
+	return privateExtend.Extend, nil
 }
 
+// attributes 依次为数据类型、归属方、标签名称、数据Hash
 func GetDataDescription(stub shim.ChaincodeStubInterface, attributes []string) (*DataDescription, error) {
-	dataKey, _ := GetDataCompositeKey(stub, attributes)
-	dataAsBytes, _ := stub.GetState(dataKey)
+	dataKey, err := GetDataCompositeKey(stub, attributes)
+	if err != nil {
+		return nil, err
+	}
+	dataAsBytes, err := GetState(stub, dataKey)
+	if err != nil {
+		return nil, err
+	}
 	if dataAsBytes == nil {
-		return nil, fmt.Errorf("can't find data detail by key %s", dataKey)
+		return nil, NewError(CodeDataNotFound, ErrorData{"type": attributes[0], "owner": attributes[1], "title": attributes[2], "hash": attributes[3]})
 	}
 
 	description := DataDescription{}
-	err := json.Unmarshal(dataAsBytes, &description)
-	if err != nil {
+	if err := UnmarshalRecord(SchemaData, dataKey, dataAsBytes, &description); err != nil {
 		return nil, err
 	}
 	return &description, nil
 }
 
 type DataTitlePrice struct {
-	Min   int `json:"min,omitempty"` /*价格区间最小值*/
-	Max   int `json:"max,omitempty"` /*价格区间最大值*/
-	Value int `json:"value"`         /*当前使用价格值*/
+	Min   int `json:"min,omitempty" metadata:"min,optional" validate:"min=0,max=1000000000"` /*价格区间最小值*/
+	Max   int `json:"max,omitempty" metadata:"max,optional" validate:"min=0,max=1000000000"` /*价格区间最大值*/
+	Value int `json:"value" validate:"min=0,max=1000000000"`                                 /*当前使用价格值*/
 }
 
 func (p *DataTitlePrice) valid() error {
@@ -78,14 +133,14 @@ func (p *DataTitlePrice) validValue(value int) error {
 	if value >= p.Min && value <= p.Max {
 		return nil
 	}
-	return fmt.Errorf("数据值[%d]超出范围[%d ~ %d]", value, p.Min, p.Max)
+	return NewError(CodePriceOutOfRange, ErrorData{"value": value, "min": p.Min, "max": p.Max})
 }
 
 func (p *DataTitlePrice) validRange() error {
 	if p.Min >= 1 && p.Max >= 1 && p.Min < p.Max {
 		return nil
 	}
-	return fmt.Errorf("数据价格范围[%d ~ %d]无效", p.Min, p.Max)
+	return NewError(CodeInvalidPriceRange, ErrorData{"min": p.Min, "max": p.Max})
 }
 
 func (p *DataTitlePrice) setRange(min int, max int) {
@@ -99,19 +154,30 @@ func (p *DataTitlePrice) toString() string {
 }
 
 type DataTitleRequest struct {
-	Type   int            `json:"type"`   /*数据类型*/
-	Owner  string         `json:"owner"`  /*数据归属方*/
-	Title  string         `json:"title"`  /*数据标签名称*/
-	Shelve bool           `json:"shelve"` /*标签是否上架*/
-	Price  DataTitlePrice `json:"price"`  /*数据标签价格*/
+	Type    int            `json:"type" validate:"min=0,max=65535"`                                            /*数据类型*/
+	Owner   string         `json:"owner" validate:"required,max=64,charset=name"`                              /*数据归属方*/
+	Title   string         `json:"title" validate:"required,max=128,charset=text"`                             /*数据标签名称*/
+	Shelve  bool           `json:"shelve" metadata:"shelve,optional"`                                          /*标签是否上架*/
+	Price   DataTitlePrice `json:"price"`                                                                      /*数据标签价格*/
+	Terms   string         `json:"terms,omitempty" metadata:"terms,optional" validate:"max=1024,charset=text"` /*许可条款，写入购买时生成的许可证，为空时不修改*/
+	Resale  bool           `json:"resale,omitempty" metadata:"resale,optional"`                                /*是否允许买方转售许可证*/
+	Royalty int            `json:"royalty,omitempty" metadata:"royalty,optional" validate:"min=0,max=100"`     /*转售时支付给数据归属方的版税，转售价格的百分比*/
+	Rating  *RatingSummary `json:"rating,omitempty" metadata:"rating,optional" validate:"empty"`               /*买方评分汇总，由合约返回，没有评分时为空*/
 }
 
 type DataTitleDescription struct {
-	Shelve bool           `json:"shelve"`
-	Price  DataTitlePrice `json:"price"`
+	Shelve  bool           `json:"shelve"`
+	Price   DataTitlePrice `json:"price"`
+	Terms   string         `json:"terms,omitempty" metadata:"terms,optional"` /*许可条款*/
+	Resale  bool           `json:"resale,omitempty"`                          /*是否允许转售许可证*/
+	Royalty int            `json:"royalty,omitempty"`                         /*转售版税百分比*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
 }
 
+// 写入账本时使用当前记录版本
 func (d *DataTitleDescription) toBytes() []byte {
+	d.SchemaVersion = CurrentSchemaVersion(SchemaTitle)
 	dataAsBytes, err := json.Marshal(d)
 	if err != nil {
 		fmt.Printf("[DataTitleDescription] marshall failed.\n")
@@ -125,31 +191,94 @@ func (d *DataTitleDescription) toString() string {
 	return string(dataAsBytes)
 }
 
-func GetDataTitleCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, string) {
-	indexName := "title"
-	indexKey, err := stub.CreateCompositeKey(indexName, attributes)
+const DataTitleIndexName = "title"
+
+func GetDataTitleCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, DataTitleIndexName, attributes)
+}
+
+// 标签不存在时返回 nil
+func FindDataTitle(stub shim.ChaincodeStubInterface, key string) (*DataTitleDescription, error) {
+	dataAsBytes, err := GetState(stub, key)
 	if err != nil {
-		fmt.Printf("GetDataTitleCompositeKey error: %s \n", err.Error())
+		return nil, err
+	}
+	if dataAsBytes == nil {
+		return nil, nil
+	}
+	titleDescription := DataTitleDescription{}
+	if err := UnmarshalRecord(SchemaTitle, key, dataAsBytes, &titleDescription); err != nil {
+		return nil, err
 	}
-	return indexKey, indexName
+	return &titleDescription, nil
 }
 
 func GetDataTitle(stub shim.ChaincodeStubInterface, key string) (*DataTitleDescription, error) {
-	dataAsBytes, _ := stub.GetState(key)
-	if dataAsBytes != nil {
-		titleDescription := DataTitleDescription{}
-		if err := json.Unmarshal(dataAsBytes, &titleDescription); err != nil {
-			return nil, fmt.Errorf("[GetDataTitle] Failed to Unmarshal json %s \n", string(dataAsBytes))
+	titleDescription, err := FindDataTitle(stub, key)
+	if err != nil {
+		return nil, err
+	}
+	if titleDescription == nil {
+		attributes, err := SplitCompositeKey(stub, key)
+		if err != nil {
+			return nil, err
 		}
-		return &titleDescription, nil
+		return nil, NewError(CodeTitleNotFound, ErrorData{"type": attributes[0], "owner": attributes[1], "title": attributes[2]})
 	}
-	return nil, fmt.Errorf("can't find title detail by key %s", key)
+	return titleDescription, nil
+}
+
+// 下架归属方的全部标签，标签索引以数据类型开头，需遍历全部标签
+func UnshelveOwnerTitles(stub shim.ChaincodeStubInterface, owner string, events *EventBatch) error {
+	resultIterator, err := stub.GetStateByPartialCompositeKey(DataTitleIndexName, []string{})
+	if err != nil {
+		return LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return LedgerError("Next", err)
+		}
+		attributes, err := SplitCompositeKey(stub, item.Key)
+		if err != nil {
+			return err
+		}
+		if attributes[1] != owner {
+			continue
+		}
+
+		titleDetail := DataTitleDescription{}
+		if err := UnmarshalRecord(SchemaTitle, item.Key, item.Value, &titleDetail); err != nil {
+			return err
+		}
+		if !titleDetail.Shelve {
+			continue
+		}
+		titleDetail.Shelve = false
+		if err := PutState(stub, item.Key, titleDetail.toBytes()); err != nil {
+			return err
+		}
+
+		dataType, err := strconv.Atoi(attributes[0])
+		if err != nil {
+			return CorruptedStateError(item.Key)
+		}
+		events.add(EventTitleChanged, TitleEventPayload{
+			Type:   dataType,
+			Owner:  owner,
+			Title:  attributes[2],
+			Shelve: false,
+			Price:  titleDetail.Price,
+		})
+	}
+	return nil
 }
 
 type SearchTitleRequest struct {
-	Type   int      `json:"type"`   /*数据类型*/
-	Owner  string   `json:"owner"`  /*数据归属方*/
-	Titles []string `json:"titles"` /*搜索标签名列表*/
+	Type   int      `json:"type" validate:"min=0,max=65535"`                                                  /*数据类型*/
+	Owner  string   `json:"owner" validate:"required,max=64,charset=name"`                                    /*数据归属方*/
+	Titles []string `json:"titles" validate:"required,max=100,unique=." item:"required,max=128,charset=text"` /*搜索标签名列表*/
 }
 
 func (s *SearchTitleRequest) getDataTitleCompositeKeyAttributes(title string) []string {
@@ -163,9 +292,11 @@ func (s *SearchTitleRequest) getDataTitlePartialCompositeKeyAttributes() []strin
 }
 
 type SearchTitleResponse struct {
-	Base   DataTitleRequest `json:"base"`   /*数据标签基本信息*/
-	Hash   string           `json:"hash"`   /*数据Hash*/
-	Extend string           `json:"extend"` /*数据扩展描述信息*/
+	Base       DataTitleRequest `json:"base"`                                                /*数据标签基本信息*/
+	Hash       string           `json:"hash"`                                                /*数据Hash*/
+	Extend     string           `json:"extend"`                                              /*数据扩展描述信息，仅历史数据*/
+	ExtendHash string           `json:"extendHash,omitempty" metadata:"extendHash,optional"` /*私有扩展信息Hash，购买后通过 showDataExtend 获取*/
+	Reputation *Reputation      `json:"reputation"`                                          /*数据归属方信誉*/
 }
 
 type OwnerTitleResponse struct {
@@ -173,52 +304,79 @@ type OwnerTitleResponse struct {
 	Title map[string][]string `json:"titles"`
 }
 
-func (o *OwnerTitleResponse) toBytes() []byte {
-	dataAsBytes, _ := json.Marshal(o)
-	return dataAsBytes
+type DataContract struct {
+	contractapi.Contract
 }
 
-type DataContract struct {
+func (s *DataContract) GetEvaluateTransactions() []string {
+	return []string{"ShowDataEvidence", "ShowTitles", "ShowNameOfTitles", "SearchTitles"}
 }
 
-func (s *DataContract) setDataEvidence(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (s *DataContract) SetDataEvidence(ctx contractapi.TransactionContextInterface, request DataRequest) error {
+
+	if request.Description.Extend != "" {
+		return NewError(CodePublicExtend, ErrorData{"field": TransientExtendKey})
+	}
 
-	if len(args) != 1 {
-		return shim.Error("[setDataEvidence] Incorrect number of arguments. Expecting 1")
+	stub := ctx.GetStub()
+	if err := NewAccountRepository(stub).CheckNotClosed(request.Core.Owner); err != nil {
+		return err
+	}
+	dataKey, err := GetDataCompositeKey(stub, request.Core.getDataCompositeKeyAttributes())
+	if err != nil {
+		return err
 	}
 
-	_param := args[0]
+	// 扩展信息写入归属方组织私有数据集合
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return LedgerError("GetTransient", err)
+	}
+	if extend, ok := transientMap[TransientExtendKey]; ok && len(extend) > 0 {
+		mspID, err := GetCreatorMSPID(stub)
+		if err != nil {
+			return err
+		}
+		salt := transientMap[TransientSaltKey]
+		if len(salt) < extendSaltMin || len(salt) > extendSaltMax {
+			return NewError(CodeInvalidExtendSalt, ErrorData{"field": TransientSaltKey, "min": extendSaltMin, "max": extendSaltMax})
+		}
+		privateExtend := DataPrivateExtend{Extend: string(extend), Salt: hex.EncodeToString(salt)}
+		privateExtendAsBytes, err := json.Marshal(privateExtend)
+		if err != nil {
+			return InternalError(err)
+		}
 
-	var request DataRequest
-	if err := json.Unmarshal([]byte(_param), &request); err != nil {
-		fmt.Println("Failed to parse data entity.")
-		return shim.Error("Failed to parse data entity.")
+		request.Description.Collection = GetOrgCollectionName(mspID)
+		request.Description.ExtendHash = extendHash(privateExtendAsBytes)
+		if err := stub.PutPrivateData(request.Description.Collection, dataKey, privateExtendAsBytes); err != nil {
+			return LedgerError("PutPrivateData", err)
+		}
 	}
 
-	dataKey, _ := GetDataCompositeKey(stub, request.Core.getDataCompositeKeyAttributes())
 	dataDetailAsBytes := request.Description.toBytes()
 
-	if err := stub.PutState(dataKey, dataDetailAsBytes); err != nil {
-		return shim.Error(err.Error())
+	if err := PutState(stub, dataKey, dataDetailAsBytes); err != nil {
+		return err
 	} else {
 		fmt.Printf("setDataEvidence - end %s = %s \n", dataKey, string(dataDetailAsBytes))
 	}
 
-	return shim.Success(nil)
+	events := NewEventBatch(stub)
+	events.add(EventDataEvidence, DataEvidenceEventPayload{
+		Type:  request.Core.Type,
+		Owner: request.Core.Owner,
+		Title: request.Core.Title,
+		Hash:  request.Core.Hash,
+		Size:  request.Description.Size,
+	})
+	return events.emit()
 }
 
-func (s *DataContract) showDataEvidence(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	if len(args) != 1 {
-		return shim.Error("[showDataEvidence] Incorrect number of arguments. Expecting 1")
-	}
+func (s *DataContract) ShowDataEvidence(ctx contractapi.TransactionContextInterface, requestList []DataEvidenceRequest) ([]DataRequest, error) {
 
-	var requestList []DataEvidenceRequest
-	if err := json.Unmarshal([]byte(args[0]), &requestList); err != nil {
-		return shim.Error("[showDataEvidence] Failed to parse request.")
-	}
-
-	var retDataList []DataRequest
+	stub := ctx.GetStub()
+	retDataList := []DataRequest{}
 	for _, info := range requestList {
 		attributes := info.getDataCompositeKeyAttributes()
 		if dataDetail, err := GetDataDescription(stub, attributes); err == nil {
@@ -228,129 +386,154 @@ func (s *DataContract) showDataEvidence(stub shim.ChaincodeStubInterface, args [
 			})
 		} else {
 			fmt.Printf("Invalid key of data detail. [%v] \n", attributes)
-			return shim.Error(err.Error())
+			return nil, err
 		}
 	}
-	retDataListAsBytes, _ := json.Marshal(retDataList)
 
-	return shim.Success(retDataListAsBytes)
+	return retDataList, nil
 }
 
-func (s *DataContract) setTitle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (s *DataContract) SetTitle(ctx contractapi.TransactionContextInterface, dataTitle DataTitleRequest) error {
 
-	if len(args) != 1 {
-		return shim.Error("[setTitle] Incorrect number of arguments. Expecting 1")
+	stub := ctx.GetStub()
+	if err := NewAccountRepository(stub).CheckNotClosed(dataTitle.Owner); err != nil {
+		return err
 	}
-
-	var dataTitle DataTitleRequest
-	if err := json.Unmarshal([]byte(args[0]), &dataTitle); err != nil {
-		return shim.Error("[setTitle] Incorrect argument. Expecting a json string of data title.")
+	dataTitleKey, err := GetDataTitleCompositeKey(stub, []string{strconv.Itoa(dataTitle.Type), dataTitle.Owner, dataTitle.Title})
+	if err != nil {
+		return err
 	}
-
-	dataTitleKey, _ := GetDataTitleCompositeKey(stub, []string{strconv.Itoa(dataTitle.Type), dataTitle.Owner, dataTitle.Title})
-	_existDataTitle, err := GetDataTitle(stub, dataTitleKey)
+	_existDataTitle, err := FindDataTitle(stub, dataTitleKey)
 	if err != nil {
+		return err
+	}
+	if _existDataTitle == nil {
 		// 数据不存在，新增加数据
 		if err := dataTitle.Price.valid(); err != nil {
-			return shim.Error(err.Error())
+			return err
 		}
 		_existDataTitle = &DataTitleDescription{
 			Shelve: dataTitle.Shelve,
 			Price:  dataTitle.Price,
+			Terms:  dataTitle.Terms,
 		}
 	} else {
 		_existDataTitle.Shelve = dataTitle.Shelve
+		if dataTitle.Terms != "" {
+			_existDataTitle.Terms = dataTitle.Terms
+		}
 		// 调整数据价格区间
 		if err := dataTitle.Price.validRange(); err != nil {
-			return shim.Error(err.Error())
+			return err
 		}
 		_existDataTitle.Price.setRange(dataTitle.Price.Min, dataTitle.Price.Max)
 		// 调整数据价格值
 		if dataTitle.Price.Value > 0 {
 			if err := _existDataTitle.Price.validValue(dataTitle.Price.Value); err != nil {
-				return shim.Error(err.Error())
+				return err
 			}
 			_existDataTitle.Price.Value = dataTitle.Price.Value
 		}
 		if err := _existDataTitle.Price.valid(); err != nil {
-			return shim.Error(err.Error())
+			return err
 		}
 	}
 
+	_existDataTitle.Resale = dataTitle.Resale
+	_existDataTitle.Royalty = dataTitle.Royalty
+
 	// 更新标签数据状态
-	if err = stub.PutState(dataTitleKey, _existDataTitle.toBytes()); err != nil {
-		return shim.Error(err.Error())
+	if err = PutState(stub, dataTitleKey, _existDataTitle.toBytes()); err != nil {
+		return err
 	} else {
 		fmt.Printf("setTitle - end %s = %s \n", dataTitleKey, _existDataTitle.toString())
 	}
 
-	return shim.Success(nil)
+	events := NewEventBatch(stub)
+	events.add(EventTitleChanged, TitleEventPayload{
+		Type:    dataTitle.Type,
+		Owner:   dataTitle.Owner,
+		Title:   dataTitle.Title,
+		Shelve:  _existDataTitle.Shelve,
+		Price:   _existDataTitle.Price,
+		Resale:  _existDataTitle.Resale,
+		Royalty: _existDataTitle.Royalty,
+	})
+	return events.emit()
 }
 
-func (s *DataContract) showTitles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (s *DataContract) ShowTitles(ctx contractapi.TransactionContextInterface, dataType int, owner string) ([]DataTitleRequest, error) {
 
-	// args: [type, owner]
-	if len(args) != 2 {
-		return shim.Error("[showTitles] Incorrect number of arguments. Expecting 2")
-	}
-
-	dataType, err := strconv.Atoi(args[0])
+	stub := ctx.GetStub()
+	retDataList := []DataTitleRequest{}
+	titleAttributes := []string{strconv.Itoa(dataType), owner}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(DataTitleIndexName, titleAttributes)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("[showTitles] Failed to parse data type %s", args[0]))
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
 	}
-
-	var retDataList []DataTitleRequest
-	_, indexName := GetDataTitleCompositeKey(stub, args)
-	resultIterator, _ := stub.GetStateByPartialCompositeKey(indexName, args)
 	defer resultIterator.Close()
 	for resultIterator.HasNext() {
-		item, _ := resultIterator.Next()
-		_, attributes, _ := stub.SplitCompositeKey(item.Key)
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		attributes, err := SplitCompositeKey(stub, item.Key)
+		if err != nil {
+			return nil, err
+		}
 
 		titleDetail := DataTitleDescription{}
-		_ = json.Unmarshal(item.Value, &titleDetail)
-		// fmt.Printf("showTitles info: %s = %s \n", item.Key, titleDetail.toString())
+		if err := UnmarshalRecord(SchemaTitle, item.Key, item.Value, &titleDetail); err != nil {
+			return nil, err
+		}
+		rating, err := GetRatingSummary(stub, attributes)
+		if err != nil {
+			return nil, err
+		}
 		retDataList = append(retDataList, DataTitleRequest{
-			Type:   dataType,
-			Owner:  attributes[1],
-			Title:  attributes[2],
-			Shelve: titleDetail.Shelve,
-			Price:  titleDetail.Price,
+			Type:    dataType,
+			Owner:   attributes[1],
+			Title:   attributes[2],
+			Shelve:  titleDetail.Shelve,
+			Price:   titleDetail.Price,
+			Terms:   titleDetail.Terms,
+			Resale:  titleDetail.Resale,
+			Royalty: titleDetail.Royalty,
+			Rating:  rating,
 		})
 	}
-	retDataListAsBytes, _ := json.Marshal(retDataList)
 
-	return shim.Success(retDataListAsBytes)
+	return retDataList, nil
 }
 
-func (s *DataContract) showNameOfTitles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	// args: [type]
-	if len(args) != 1 {
-		return shim.Error("[showNameOfTitles] Incorrect number of arguments. Expecting 1")
-	}
+func (s *DataContract) ShowNameOfTitles(ctx contractapi.TransactionContextInterface, dataType int) (*OwnerTitleResponse, error) {
 
-	dataType, err := strconv.Atoi(args[0])
+	stub := ctx.GetStub()
+	var retData = OwnerTitleResponse{Type: dataType}
+	titleAttributes := []string{strconv.Itoa(dataType)}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(DataTitleIndexName, titleAttributes)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("[showNameOfTitles] Incorrect format of argument. Expecting number."))
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
 	}
-
-	var retData = OwnerTitleResponse{Type: dataType}
-	_, indexName := GetDataTitleCompositeKey(stub, args)
-	resultIterator, _ := stub.GetStateByPartialCompositeKey(indexName, args)
 	defer resultIterator.Close()
 
 	retData.Title = make(map[string][]string)
 	for resultIterator.HasNext() {
-		item, _ := resultIterator.Next()
-		_, attributes, _ := stub.SplitCompositeKey(item.Key)
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		attributes, err := SplitCompositeKey(stub, item.Key)
+		if err != nil {
+			return nil, err
+		}
 
-		if titleDetail, err := GetDataTitle(stub, item.Key); err == nil {
-			if !titleDetail.Shelve {
-				continue
-			}
-		} else {
-			return shim.Error(err.Error())
+		titleDetail := DataTitleDescription{}
+		if err := UnmarshalRecord(SchemaTitle, item.Key, item.Value, &titleDetail); err != nil {
+			return nil, err
+		}
+		if !titleDetail.Shelve {
+			continue
 		}
 
 		owner := attributes[1]
@@ -361,58 +544,90 @@ func (s *DataContract) showNameOfTitles(stub shim.ChaincodeStubInterface, args [
 			retData.Title[owner] = []string{title}
 		}
 	}
-	retDataListAsBytes := retData.toBytes()
 
-	return shim.Success(retDataListAsBytes)
+	return &retData, nil
 }
 
-func (s *DataContract) searchTitles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (s *DataContract) SearchTitles(ctx contractapi.TransactionContextInterface, searchRequest SearchTitleRequest) ([]SearchTitleResponse, error) {
 
-	if len(args) != 1 {
-		return shim.Error("[searchTitles] Incorrect number of arguments. Expecting 1")
-	}
-
-	searchRequest := SearchTitleRequest{}
-	if err := json.Unmarshal([]byte(args[0]), &searchRequest); err != nil {
-		return shim.Error("[searchTitles] Incorrect argument. Expecting a json string.")
+	stub := ctx.GetStub()
+	retDataList := []SearchTitleResponse{}
+	reputation, err := GetReputation(stub, searchRequest.Owner)
+	if err != nil {
+		return nil, err
 	}
-
-	var retDataList []SearchTitleResponse
 	for _, title := range searchRequest.Titles {
 		titleReqArgs := searchRequest.getDataTitleCompositeKeyAttributes(title)
-		titleKey, _ := GetDataTitleCompositeKey(stub, titleReqArgs)
-		if titleDetail, err := GetDataTitle(stub, titleKey); err == nil {
-			if !titleDetail.Shelve {
-				continue
-			}
+		titleKey, err := GetDataTitleCompositeKey(stub, titleReqArgs)
+		if err != nil {
+			return nil, err
+		}
+		// 未找到或未上架的标签不返回
+		titleDetail, err := FindDataTitle(stub, titleKey)
+		if err != nil {
+			return nil, err
+		}
+		if titleDetail == nil || !titleDetail.Shelve {
+			continue
+		}
 
-			_, dataIndexName := GetDataCompositeKey(stub, titleReqArgs)
-			dataIterator, _ := stub.GetStateByPartialCompositeKey(dataIndexName, titleReqArgs)
-			defer dataIterator.Close()
-			for dataIterator.HasNext() {
-				item, _ := dataIterator.Next()
-
-				var dataDetail DataDescription
-				_ = json.Unmarshal(item.Value, &dataDetail)
-
-				_, dataAttributes, _ := stub.SplitCompositeKey(item.Key)
-				retDataList = append(retDataList, SearchTitleResponse{
-					Base: DataTitleRequest{
-						Type:   searchRequest.Type,
-						Owner:  searchRequest.Owner,
-						Title:  title,
-						Shelve: titleDetail.Shelve,
-						Price:  titleDetail.Price,
-					},
-					Hash:   dataAttributes[3],
-					Extend: dataDetail.Extend,
-				})
+		dataList, err := s.searchTitleData(stub, titleReqArgs)
+		if err != nil {
+			return nil, err
+		}
+		rating, err := GetRatingSummary(stub, titleReqArgs)
+		if err != nil {
+			return nil, err
+		}
+		for _, data := range dataList {
+			data.Base = DataTitleRequest{
+				Type:    searchRequest.Type,
+				Owner:   searchRequest.Owner,
+				Title:   title,
+				Shelve:  titleDetail.Shelve,
+				Price:   titleDetail.Price,
+				Terms:   titleDetail.Terms,
+				Resale:  titleDetail.Resale,
+				Royalty: titleDetail.Royalty,
+				Rating:  rating,
 			}
-		} else {
-			fmt.Println(err.Error())
+			data.Reputation = reputation
+			retDataList = append(retDataList, data)
 		}
 	}
-	retDataListAsBytes, _ := json.Marshal(retDataList)
 
-	return shim.Success(retDataListAsBytes)
+	return retDataList, nil
+}
+
+// 查询标签下的全部数据存证
+func (s *DataContract) searchTitleData(stub shim.ChaincodeStubInterface, titleAttributes []string) ([]SearchTitleResponse, error) {
+
+	retDataList := []SearchTitleResponse{}
+	dataIterator, err := stub.GetStateByPartialCompositeKey(DataIndexName, titleAttributes)
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer dataIterator.Close()
+	for dataIterator.HasNext() {
+		item, err := dataIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+
+		var dataDetail DataDescription
+		if err := UnmarshalRecord(SchemaData, item.Key, item.Value, &dataDetail); err != nil {
+			return nil, err
+		}
+
+		dataAttributes, err := SplitCompositeKey(stub, item.Key)
+		if err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, SearchTitleResponse{
+			Hash:       dataAttributes[3],
+			Extend:     dataDetail.Extend,
+			ExtendHash: dataDetail.ExtendHash,
+		})
+	}
+	return retDataList, nil
 }
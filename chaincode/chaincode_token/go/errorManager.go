@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+ * 合约错误码实现：
+ * 1. 错误码数值稳定，客户端按错误码判断失败原因，只允许新增错误码
+ * 2. 所有接口返回统一结构 {code, message, data}，错误时 data 为错误参数
+ * 3. 错误码分段：1xxx 通用，2xxx 账户，3xxx 数据，4xxx 交易，5xxx 组织，6xxx 审批
+ * 4. 错误信息按请求语言从 languageManager.go 的信息目录生成
+ */
+
+type ErrorCode int
+
+const (
+	CodeOK ErrorCode = 0
+
+	// 通用错误
+	CodeInvalidArgument   ErrorCode = 1001
+	CodeFunctionNotFound  ErrorCode = 1002
+	CodeLedgerError       ErrorCode = 1003
+	CodeCorruptedState    ErrorCode = 1004
+	CodeIdentityError     ErrorCode = 1005
+	CodeUnsupportedLang   ErrorCode = 1006
+	CodeValidationFailed  ErrorCode = 1007
+	CodePermissionDenied  ErrorCode = 1008
+	CodeUnsupportedSchema ErrorCode = 1009
+	CodeInternal          ErrorCode = 1099
+
+	// 账户错误
+	CodeAccountNotFound     ErrorCode = 2001
+	CodeAccountExists       ErrorCode = 2002
+	CodeAccountFrozen       ErrorCode = 2003
+	CodeEncryptKeyNotSet    ErrorCode = 2004
+	CodeInvalidEncryptKey   ErrorCode = 2005
+	CodeAccountAddressError ErrorCode = 2006
+	CodeBalanceNotSettled   ErrorCode = 2007
+	CodeAccountClosed       ErrorCode = 2008
+	CodeEscrowNotSettled    ErrorCode = 2009
+
+	// 数据错误
+	CodeDataNotFound          ErrorCode = 3001
+	CodeTitleNotFound         ErrorCode = 3002
+	CodePriceOutOfRange       ErrorCode = 3003
+	CodeInvalidPriceRange     ErrorCode = 3004
+	CodePublicExtend          ErrorCode = 3005
+	CodePrivateExtendMissing  ErrorCode = 3006
+	CodePrivateExtendMismatch ErrorCode = 3007
+	CodeInvalidExtendSalt     ErrorCode = 3008
+
+	// 交易错误
+	CodeTransferEmpty          ErrorCode = 4001
+	CodeTransferNotFound       ErrorCode = 4002
+	CodeTransferHashMismatch   ErrorCode = 4003
+	CodeInvalidWrappedKey      ErrorCode = 4004
+	CodeDataKeyNotFound        ErrorCode = 4005
+	CodeAllowanceInsufficient  ErrorCode = 4006
+	CodeTokenInsufficient      ErrorCode = 4007
+	CodeLicenseNotFound        ErrorCode = 4008
+	CodeLicenseNotHeld         ErrorCode = 4009
+	CodeResaleDisabled         ErrorCode = 4010
+	CodeLicenseNotListed       ErrorCode = 4011
+	CodeLicenseReserved        ErrorCode = 4012
+	CodeOfferNotFound          ErrorCode = 4013
+	CodeOfferClosed            ErrorCode = 4014
+	CodeOfferExpired           ErrorCode = 4015
+	CodeNotOfferParty          ErrorCode = 4016
+	CodeOfferTurn              ErrorCode = 4017
+	CodeAuctionNotFound        ErrorCode = 4018
+	CodeAuctionPhase           ErrorCode = 4019
+	CodeBidNotFound            ErrorCode = 4020
+	CodeBidMismatch            ErrorCode = 4021
+	CodeDataExclusive          ErrorCode = 4022
+	CodeDataSold               ErrorCode = 4023
+	CodeBountyNotFound         ErrorCode = 4024
+	CodeBountyClosed           ErrorCode = 4025
+	CodeBountyDeadline         ErrorCode = 4026
+	CodeBountyResponseNotFound ErrorCode = 4027
+	CodeRatingExists           ErrorCode = 4028
+	CodeTicketNotFound         ErrorCode = 4029
+	CodeTicketExpired          ErrorCode = 4030
+	CodeTicketMismatch         ErrorCode = 4031
+	CodeDisputeExists          ErrorCode = 4032
+
+	// 组织错误
+	CodeOrgNotFound            ErrorCode = 5001
+	CodeOrgExists              ErrorCode = 5002
+	CodeOrgMspBound            ErrorCode = 5003
+	CodeOrgMemberNotFound      ErrorCode = 5004
+	CodeAccountInOrg           ErrorCode = 5005
+	CodeOrgBalanceInsufficient ErrorCode = 5006
+	CodeMemberLimitExceeded    ErrorCode = 5007
+
+	// 审批错误
+	CodeProposalNotFound      ErrorCode = 6001
+	CodeProposalClosed        ErrorCode = 6002
+	CodeProposalExpired       ErrorCode = 6003
+	CodeNotApprover           ErrorCode = 6004
+	CodeAlreadyApproved       ErrorCode = 6005
+	CodeSelfApproval          ErrorCode = 6006
+	CodeProposalAmountChanged ErrorCode = 6007
+)
+
+type ErrorData map[string]interface{}
+
+// 合约错误，Error() 返回 JSON 结构，由合约入口解析后生成统一返回结构
+type ChaincodeError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Data    ErrorData `json:"data,omitempty"`
+}
+
+// 错误信息默认使用英文，返回客户端时按请求语言重新生成
+func NewError(code ErrorCode, data ErrorData) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: LocalizeMessage(LangEN, code, data), Data: data}
+}
+
+func (e *ChaincodeError) Error() string {
+	errorAsBytes, _ := json.Marshal(e)
+	return string(errorAsBytes)
+}
+
+// 替换模板中的参数，按参数名排序保证结果一致
+func formatMessage(template string, data ErrorData) string {
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	message := template
+	for _, name := range names {
+		message = strings.Replace(message, "{"+name+"}", fmt.Sprint(data[name]), -1)
+	}
+	return message
+}
+
+func InvalidArgumentError(format string, a ...interface{}) *ChaincodeError {
+	return NewError(CodeInvalidArgument, ErrorData{"reason": fmt.Sprintf(format, a...)})
+}
+
+// shim 读写账本失败
+func LedgerError(operation string, err error) *ChaincodeError {
+	return NewError(CodeLedgerError, ErrorData{"operation": operation, "cause": err.Error()})
+}
+
+// 账本数据无法解析
+func CorruptedStateError(key string) *ChaincodeError {
+	return NewError(CodeCorruptedState, ErrorData{"key": key})
+}
+
+func InternalError(err error) *ChaincodeError {
+	return NewError(CodeInternal, ErrorData{"cause": err.Error()})
+}
+
+// 解析错误信息，非合约错误按 contractapi 错误信息归类；数值参数保留原样，避免大整数按浮点数显示
+func ParseChaincodeError(message string) *ChaincodeError {
+	chaincodeError := ChaincodeError{}
+	decoder := json.NewDecoder(strings.NewReader(message))
+	decoder.UseNumber()
+	if err := decoder.Decode(&chaincodeError); err == nil && chaincodeError.Code != CodeOK {
+		return &chaincodeError
+	}
+
+	switch {
+	case strings.HasPrefix(message, "Function ") && strings.Contains(message, " not found"),
+		strings.HasPrefix(message, "Contract not found"),
+		strings.HasPrefix(message, "Blank function name"):
+		return NewError(CodeFunctionNotFound, ErrorData{"reason": message})
+	case strings.HasPrefix(message, "Error managing parameter"),
+		strings.HasPrefix(message, "Incorrect number of params"):
+		return NewError(CodeInvalidArgument, ErrorData{"reason": message})
+	}
+	return NewError(CodeInternal, ErrorData{"cause": message})
+}
+
+// 接口统一返回结构
+type Response struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+}
+
+func (r *Response) toBytes() []byte {
+	dataAsBytes, _ := json.Marshal(r)
+	return dataAsBytes
+}
+
+// 成功返回，payload 为 JSON 时原样作为 data，否则作为字符串
+func SuccessResponse(payload []byte, language string) *Response {
+	response := Response{Code: CodeOK, Message: LocalizeMessage(language, CodeOK, nil)}
+	if len(payload) > 0 {
+		if json.Valid(payload) {
+			response.Data = json.RawMessage(payload)
+		} else {
+			response.Data = string(payload)
+		}
+	}
+	return &response
+}
+
+func ErrorResponse(err *ChaincodeError, language string) *Response {
+	response := Response{Code: err.Code, Message: LocalizeMessage(language, err.Code, err.Data)}
+	if err.Data != nil {
+		response.Data = err.Data
+	}
+	return &response
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+/*
+ * 锁定积分登记实现：
+ * 1. 拍卖出价保证金、悬赏积分锁定时按账户登记，值为锁定积分，退回或支付时删除
+ * 2. 账户仍有锁定积分时不能注销，避免退回积分进入已注销账户
+ */
+
+// 锁定类别
+const (
+	EscrowBid    = "bid"
+	EscrowBounty = "bounty"
+)
+
+// 锁定积分组合键：账户、锁定类别、拍卖或需求编号
+const EscrowIndexName = "escrow"
+
+func GetEscrowCompositeKey(stub shim.ChaincodeStubInterface, account, kind, id string) (string, error) {
+	return CreateCompositeKey(stub, EscrowIndexName, []string{account, kind, id})
+}
+
+// 登记或更新锁定积分
+func PutEscrow(stub shim.ChaincodeStubInterface, account, kind, id string, amount int64) error {
+	escrowKey, err := GetEscrowCompositeKey(stub, account, kind, id)
+	if err != nil {
+		return err
+	}
+	return PutState(stub, escrowKey, []byte(strconv.FormatInt(amount, 10)))
+}
+
+func DeleteEscrow(stub shim.ChaincodeStubInterface, account, kind, id string) error {
+	escrowKey, err := GetEscrowCompositeKey(stub, account, kind, id)
+	if err != nil {
+		return err
+	}
+	if err := stub.DelState(escrowKey); err != nil {
+		return LedgerError("DelState", err)
+	}
+	return nil
+}
+
+// 账户锁定积分合计，返回登记条数和积分
+func SumEscrow(stub shim.ChaincodeStubInterface, account string) (int, int64, error) {
+	attributes := []string{}
+	if account != "" {
+		attributes = append(attributes, account)
+	}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(EscrowIndexName, attributes)
+	if err != nil {
+		return 0, 0, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+
+	count := 0
+	var total int64
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return 0, 0, LedgerError("Next", err)
+		}
+		amount, err := strconv.ParseInt(string(item.Value), 10, 64)
+		if err != nil {
+			return 0, 0, CorruptedStateError(item.Key)
+		}
+		count++
+		total += amount
+	}
+	return count, total, nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+/*
+ * 合约事件实现：
+ * 1. 每笔交易只能设置一个事件，交易内的所有状态变更合并为一个事件批次
+ * 2. 事件结构带版本号，字段定义见 EVENTS.md，只允许新增字段
+ */
+
+const TokenEventName = "TokenEvent"
+const TokenEventVersion = 1
+
+const (
+	EventAccountCreated     = "account.created"
+	EventAccountFrozen      = "account.frozen"
+	EventAccountDeleted     = "account.deleted" /*已由 account.closed 替代，保留供解析历史事件*/
+	EventTokenMinted        = "token.minted"
+	EventTokenBurned        = "token.burned"
+	EventDataEvidence       = "data.evidence"
+	EventTitleChanged       = "title.changed"
+	EventDataPurchased      = "data.purchased"
+	EventAccountKeySet      = "account.key"
+	EventDataKeyPosted      = "data.key"
+	EventConfigChanged      = "config.changed"
+	EventAccountMigrated    = "account.migrated"
+	EventAccountClosed      = "account.closed"
+	EventOrgRegistered      = "org.registered"
+	EventOrgMemberChanged   = "org.member"
+	EventOrgFunded          = "org.funded"
+	EventProposalCreated    = "proposal.created"
+	EventProposalApproved   = "proposal.approved"
+	EventProposalExecuted   = "proposal.executed"
+	EventProposalCancelled  = "proposal.cancelled"
+	EventAllowanceChanged   = "allowance.changed"
+	EventTokenTransferred   = "token.transferred"
+	EventERC20Transfer      = "erc20.transfer"
+	EventERC20Approval      = "erc20.approval"
+	EventLicenseMinted      = "license.minted"
+	EventLicenseListed      = "license.listed"
+	EventLicenseTransferred = "license.transferred"
+	EventOfferChanged       = "offer.changed"
+	EventAuctionChanged     = "auction.changed"
+	EventAuctionBid         = "auction.bid"
+	EventBountyChanged      = "bounty.changed"
+	EventBountyResponded    = "bounty.responded"
+	EventBountyBudget       = "bounty.budget"
+	EventTitleRated         = "title.rated"
+	EventReputationChanged  = "reputation.changed"
+	EventDisputeRecorded    = "dispute.recorded"
+)
+
+type TokenEvent struct {
+	Version int         `json:"version"` /*事件结构版本*/
+	TxID    string      `json:"txId"`    /*交易ID*/
+	Time    int64       `json:"time"`    /*交易时间*/
+	Items   []EventItem `json:"items"`   /*交易内事件列表，按发生顺序*/
+}
+
+type EventItem struct {
+	Type    string      `json:"type"`    /*事件类型*/
+	Payload interface{} `json:"payload"` /*事件内容，结构由事件类型决定*/
+}
+
+type AccountEventPayload struct {
+	Name    string `json:"name"`
+	Type    int    `json:"type,omitempty"`
+	OrgName string `json:"orgName,omitempty"`
+	Address string `json:"address,omitempty"`
+	Frozen  bool   `json:"frozen"`
+}
+
+type AccountClosedEventPayload struct {
+	Name             string `json:"name"`                       /*注销账户名称*/
+	Successor        string `json:"successor,omitempty"`        /*接收积分的账户*/
+	Amount           int64  `json:"amount"`                     /*转入 successor 的积分*/
+	SuccessorBalance int64  `json:"successorBalance,omitempty"` /*successor 转入后积分*/
+}
+
+type AccountMigratedEventPayload struct {
+	Name       string `json:"name"`       /*账户名称*/
+	TokenDelta int64  `json:"tokenDelta"` /*补记积分，可能为负数*/
+	Balance    int64  `json:"balance"`    /*合并后积分*/
+}
+
+type AccountKeyEventPayload struct {
+	Name        string `json:"name"`        /*账户名称*/
+	Fingerprint string `json:"fingerprint"` /*加密公钥指纹*/
+}
+
+type DataKeyEventPayload struct {
+	Buyer       string `json:"buyer"`
+	Type        int    `json:"type"`
+	Owner       string `json:"owner"`
+	Title       string `json:"title"`
+	Hash        string `json:"hash"`
+	Fingerprint string `json:"fingerprint"` /*加密数据密钥使用的买方公钥指纹*/
+}
+
+type ConfigEventPayload struct {
+	Name  string `json:"name"`  /*配置项名称*/
+	Value string `json:"value"` /*配置值*/
+}
+
+type TokenEventPayload struct {
+	Name    string `json:"name"`    /*账户名称*/
+	Amount  int64  `json:"amount"`  /*变动积分，始终为正数*/
+	Balance int64  `json:"balance"` /*变动后积分*/
+}
+
+type DataEvidenceEventPayload struct {
+	Type  int    `json:"type"`
+	Owner string `json:"owner"`
+	Title string `json:"title"`
+	Hash  string `json:"hash"`
+	Size  int    `json:"size"`
+}
+
+type TitleEventPayload struct {
+	Type    int            `json:"type"`
+	Owner   string         `json:"owner"`
+	Title   string         `json:"title"`
+	Shelve  bool           `json:"shelve"`
+	Price   DataTitlePrice `json:"price"`
+	Resale  bool           `json:"resale,omitempty"`  /*是否允许转售许可证*/
+	Royalty int            `json:"royalty,omitempty"` /*转售版税百分比*/
+}
+
+type PurchaseEventPayload struct {
+	Buyer   string `json:"buyer"`
+	Type    int    `json:"type"`
+	Owner   string `json:"owner"`
+	Title   string `json:"title"`
+	Hash    string `json:"hash"`
+	Price   int    `json:"price"`             /*实际交易价格*/
+	Size    int    `json:"size"`              /*交易数据记录数*/
+	Time    int64  `json:"time"`              /*交易时间*/
+	Org     string `json:"org,omitempty"`     /*支付的组织钱包，为空时由买方账户支付*/
+	Spender string `json:"spender,omitempty"` /*代付账户，使用买方授权额度*/
+	License string `json:"license,omitempty"` /*交易生成的许可证编号*/
+}
+
+type OrgEventPayload struct {
+	Name  string `json:"name"`  /*组织名称*/
+	MSPID string `json:"mspId"` /*绑定的 MSP ID*/
+}
+
+type OrgMemberEventPayload struct {
+	Org     string `json:"org"`               /*组织名称*/
+	Name    string `json:"name"`              /*成员账户名称*/
+	Limit   int64  `json:"limit"`             /*组织钱包使用额度*/
+	Spent   int64  `json:"spent"`             /*已使用积分*/
+	Removed bool   `json:"removed,omitempty"` /*是否移出组织*/
+}
+
+type TokenTransferEventPayload struct {
+	From        string `json:"from"`              /*转出账户*/
+	To          string `json:"to"`                /*转入账户*/
+	Amount      int64  `json:"amount"`            /*转账积分*/
+	Spender     string `json:"spender,omitempty"` /*代付账户，使用转出账户授权额度*/
+	FromBalance int64  `json:"fromBalance"`       /*转出账户变动后积分*/
+	ToBalance   int64  `json:"toBalance"`         /*转入账户变动后积分*/
+}
+
+// ERC-20 Transfer 事件
+type ERC20TransferEventPayload struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value int64  `json:"value"`
+}
+
+// ERC-20 Approval 事件
+type ERC20ApprovalEventPayload struct {
+	Owner   string `json:"owner"`
+	Spender string `json:"spender"`
+	Value   int64  `json:"value"`
+}
+
+type AllowanceEventPayload struct {
+	Owner     string `json:"owner"`     /*授权账户*/
+	Spender   string `json:"spender"`   /*代付账户*/
+	Type      int    `json:"type"`      /*数据类型，-1 表示不限类型*/
+	Amount    int64  `json:"amount"`    /*剩余额度，0 表示已撤销或用完*/
+	ExpiresAt int64  `json:"expiresAt"` /*过期时间，0 表示不过期*/
+}
+
+type LicenseEventPayload struct {
+	ID     string `json:"id"`     /*许可证编号*/
+	Holder string `json:"holder"` /*持有账户*/
+	Type   int    `json:"type"`   /*数据类型*/
+	Owner  string `json:"owner"`  /*数据归属方*/
+	Title  string `json:"title"`  /*数据标签名称*/
+	Hash   string `json:"hash"`   /*数据Hash*/
+}
+
+type LicenseListingEventPayload struct {
+	ID     string `json:"id"`              /*许可证编号*/
+	Holder string `json:"holder"`          /*持有账户*/
+	Listed bool   `json:"listed"`          /*是否挂牌，false 表示撤销挂牌*/
+	Price  int64  `json:"price"`           /*转售价格*/
+	Buyer  string `json:"buyer,omitempty"` /*指定的买方*/
+}
+
+type LicenseTransferEventPayload struct {
+	ID      string `json:"id"`                /*许可证编号*/
+	From    string `json:"from"`              /*原持有账户*/
+	To      string `json:"to"`                /*新持有账户*/
+	Owner   string `json:"owner"`             /*数据归属方，收取版税*/
+	Price   int64  `json:"price"`             /*买方支付积分*/
+	Royalty int64  `json:"royalty"`           /*其中支付给数据归属方的版税*/
+	Spender string `json:"spender,omitempty"` /*代付账户，使用买方授权额度*/
+}
+
+type OfferEventPayload struct {
+	ID        string `json:"id"`        /*报价编号*/
+	Buyer     string `json:"buyer"`     /*买方账户*/
+	Type      int    `json:"type"`      /*数据类型*/
+	Owner     string `json:"owner"`     /*数据归属方*/
+	Title     string `json:"title"`     /*数据标签名称*/
+	Hash      string `json:"hash"`      /*数据Hash*/
+	Price     int    `json:"price"`     /*当前报价单价*/
+	By        string `json:"by"`        /*最近一次报价方*/
+	Status    string `json:"status"`    /*报价状态*/
+	ExpiresAt int64  `json:"expiresAt"` /*过期时间*/
+}
+
+type AuctionEventPayload struct {
+	ID        string `json:"id"`               /*拍卖编号*/
+	Type      int    `json:"type"`             /*数据类型*/
+	Owner     string `json:"owner"`            /*数据归属方*/
+	Title     string `json:"title"`            /*数据标签名称*/
+	Hash      string `json:"hash"`             /*数据Hash*/
+	Reserve   int    `json:"reserve"`          /*保留单价*/
+	CommitEnd int64  `json:"commitEnd"`        /*提交阶段截止时间*/
+	RevealEnd int64  `json:"revealEnd"`        /*揭示阶段截止时间*/
+	Status    string `json:"status"`           /*拍卖状态*/
+	Winner    string `json:"winner,omitempty"` /*成交方*/
+	Price     int    `json:"price,omitempty"`  /*成交单价*/
+}
+
+type AuctionBidEventPayload struct {
+	Auction string `json:"auction"`           /*拍卖编号*/
+	Bidder  string `json:"bidder"`            /*竞拍方账户*/
+	Status  string `json:"status"`            /*出价状态：locked、revealed、unlocked*/
+	Deposit int64  `json:"deposit"`           /*锁定积分*/
+	Price   int    `json:"price,omitempty"`   /*揭示的单价*/
+	Balance int64  `json:"balance,omitempty"` /*锁定或退回后的账户积分，揭示时为空*/
+}
+
+type BountyEventPayload struct {
+	ID          string `json:"id"`              /*需求编号*/
+	Buyer       string `json:"buyer"`           /*买方账户*/
+	Type        int    `json:"type"`            /*数据类型*/
+	Description string `json:"description"`     /*需求描述*/
+	Budget      int64  `json:"budget"`          /*悬赏积分*/
+	Deadline    int64  `json:"deadline"`        /*响应截止时间*/
+	Status      string `json:"status"`          /*需求状态*/
+	Owner       string `json:"owner,omitempty"` /*获得悬赏的数据提供方*/
+	Title       string `json:"title,omitempty"` /*获得悬赏的数据标签*/
+	Hash        string `json:"hash,omitempty"`  /*获得悬赏的数据Hash*/
+	Price       int    `json:"price,omitempty"` /*购买单价*/
+}
+
+type BountyResponseEventPayload struct {
+	Bounty string `json:"bounty"` /*需求编号*/
+	Owner  string `json:"owner"`  /*数据提供方*/
+	Title  string `json:"title"`  /*数据标签名称*/
+	Hash   string `json:"hash"`   /*数据Hash*/
+	Size   int    `json:"size"`   /*数据记录条数*/
+}
+
+type BountyBudgetEventPayload struct {
+	Bounty  string `json:"bounty"`            /*需求编号*/
+	Buyer   string `json:"buyer"`             /*买方账户*/
+	Status  string `json:"status"`            /*locked：发布时锁定，unlocked：关闭或购买时退回*/
+	Budget  int64  `json:"budget"`            /*悬赏积分*/
+	Balance int64  `json:"balance,omitempty"` /*锁定或退回后的账户积分*/
+}
+
+type RatingEventPayload struct {
+	Type    int     `json:"type"`              /*数据类型*/
+	Owner   string  `json:"owner"`             /*数据归属方*/
+	Title   string  `json:"title"`             /*数据标签名称*/
+	Hash    string  `json:"hash"`              /*数据Hash*/
+	Buyer   string  `json:"buyer"`             /*评分的买方*/
+	License string  `json:"license,omitempty"` /*购买的许可证编号*/
+	Score   int     `json:"score"`             /*评分*/
+	Review  string  `json:"review,omitempty"`  /*评价内容Hash*/
+	Count   int     `json:"count"`             /*标签评分次数*/
+	Average float64 `json:"average"`           /*标签平均分*/
+}
+
+type ReputationEventPayload struct {
+	Owner     string `json:"owner"`     /*数据归属方*/
+	Sales     int    `json:"sales"`     /*售出数据数*/
+	Volume    int64  `json:"volume"`    /*售出获得积分*/
+	Ratings   int    `json:"ratings"`   /*收到的评分次数*/
+	Royalties int64  `json:"royalties"` /*转售获得版税积分*/
+	Cancelled int    `json:"cancelled"` /*归属方原因未成交的拍卖数*/
+	Disputes  int    `json:"disputes"`  /*成立的争议次数*/
+	Score     int    `json:"score"`     /*信誉分*/
+}
+
+type DisputeEventPayload struct {
+	ID      string `json:"id"`                /*争议编号*/
+	Buyer   string `json:"buyer"`             /*买方*/
+	Type    int    `json:"type"`              /*数据类型*/
+	Owner   string `json:"owner"`             /*数据归属方*/
+	Title   string `json:"title"`             /*数据标签名称*/
+	Hash    string `json:"hash"`              /*数据Hash*/
+	Refund  int64  `json:"refund"`            /*退款积分*/
+	License string `json:"license,omitempty"` /*争议交易的许可证编号*/
+}
+
+type OrgTokenEventPayload struct {
+	Org            string `json:"org"`            /*组织名称*/
+	Amount         int64  `json:"amount"`         /*转入组织钱包的积分，负数为转回成员账户*/
+	Balance        int64  `json:"balance"`        /*变动后组织钱包积分*/
+	Account        string `json:"account"`        /*划转积分的成员账户*/
+	AccountBalance int64  `json:"accountBalance"` /*变动后成员账户积分*/
+}
+
+type ProposalEventPayload struct {
+	ID        string `json:"id"`        /*提案编号*/
+	Kind      string `json:"kind"`      /*提案类型*/
+	Account   string `json:"account"`   /*增发或支付账户*/
+	Amount    int64  `json:"amount"`    /*涉及积分*/
+	Proposer  string `json:"proposer"`  /*提交方身份*/
+	Required  int    `json:"required"`  /*执行所需审批数*/
+	ExpiresAt int64  `json:"expiresAt"` /*过期时间*/
+}
+
+type ProposalActionEventPayload struct {
+	ID        string `json:"id"`                 /*提案编号*/
+	Identity  string `json:"identity,omitempty"` /*审批或撤销方身份*/
+	Approvals int    `json:"approvals"`          /*已审批数*/
+}
+
+type EventBatch struct {
+	stub  shim.ChaincodeStubInterface
+	items []EventItem
+}
+
+func NewEventBatch(stub shim.ChaincodeStubInterface) *EventBatch {
+	return &EventBatch{stub: stub, items: []EventItem{}}
+}
+
+func (e *EventBatch) add(eventType string, payload interface{}) {
+	e.items = append(e.items, EventItem{Type: eventType, Payload: payload})
+}
+
+// 将批次内事件作为交易事件提交，批次为空时不设置事件
+func (e *EventBatch) emit() error {
+	if len(e.items) == 0 {
+		return nil
+	}
+
+	timeUnix, err := GetTxTimeUnix(e.stub)
+	if err != nil {
+		return err
+	}
+	event := TokenEvent{
+		Version: TokenEventVersion,
+		TxID:    e.stub.GetTxID(),
+		Time:    timeUnix,
+		Items:   e.items,
+	}
+	eventAsBytes, err := json.Marshal(event)
+	if err != nil {
+		return InternalError(err)
+	}
+	if err := e.stub.SetEvent(TokenEventName, eventAsBytes); err != nil {
+		return LedgerError("SetEvent", err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+/*
+ * 多语言信息实现：
+ * 1. 错误码和状态信息按语言维护信息目录，新增错误码时需同时补充各语言信息
+ * 2. 请求语言通过 transient 字段 lang 指定，未指定时使用通道配置的默认语言
+ * 3. 成功返回不读取通道配置：读取配置键会使每个写交易都依赖该键，setLanguage 提交时这些交易全部读写冲突
+ */
+
+const (
+	LangZH = "zh"
+	LangEN = "en"
+)
+
+// 通道未配置语言时使用的语言
+const DefaultLanguage = LangZH
+
+const TransientLanguageKey = "lang"
+
+// 信息模板，{name} 由错误参数中同名字段替换
+var messageCatalogue = map[string]map[ErrorCode]string{
+	LangEN: {
+		CodeOK: "success",
+
+		CodeInvalidArgument:   "invalid argument: {reason}",
+		CodeFunctionNotFound:  "function not found: {reason}",
+		CodeLedgerError:       "ledger operation {operation} failed: {cause}",
+		CodeCorruptedState:    "failed to parse ledger state {key}",
+		CodeIdentityError:     "failed to read creator identity: {cause}",
+		CodeUnsupportedLang:   "unsupported language {language}",
+		CodeValidationFailed:  "request validation failed: {count} invalid fields",
+		CodePermissionDenied:  "permission denied: {role} role required",
+		CodeUnsupportedSchema: "record {key} has schema version {version}, newer than supported version {current}",
+		CodeInternal:          "internal error: {cause}",
+
+		CodeAccountNotFound:     "account {name} doesn't exist",
+		CodeAccountExists:       "account {name} already exists",
+		CodeAccountFrozen:       "account {name} is frozen",
+		CodeEncryptKeyNotSet:    "account {name} hasn't registered an encrypt key",
+		CodeInvalidEncryptKey:   "invalid encrypt key: {cause}",
+		CodeAccountAddressError: "creator address doesn't match account {name}",
+		CodeBalanceNotSettled:   "account {name} holds {token} tokens, settle or transfer them to a successor first",
+		CodeAccountClosed:       "account {name} is closed",
+		CodeEscrowNotSettled:    "account {name} has {token} tokens locked in {count} open bids or bounties",
+
+		CodeDataNotFound:          "data {hash} of title {title} doesn't exist",
+		CodeTitleNotFound:         "title {title} of owner {owner} doesn't exist",
+		CodePriceOutOfRange:       "price {value} is out of range [{min} ~ {max}]",
+		CodeInvalidPriceRange:     "invalid price range [{min} ~ {max}]",
+		CodePublicExtend:          "extend must be passed by transient field {field}",
+		CodePrivateExtendMissing:  "private extend of {hash} isn't available on this peer",
+		CodePrivateExtendMismatch: "private extend of {hash} doesn't match the public hash",
+		CodeInvalidExtendSalt:     "transient field {field} must hold {min} to {max} random bytes",
+
+		CodeTransferEmpty:          "transfer details or accounts are empty",
+		CodeTransferNotFound:       "buyer {buyer} hasn't bought title {title}",
+		CodeTransferHashMismatch:   "hash {hash} doesn't match the transfer record",
+		CodeInvalidWrappedKey:      "wrapped key must be non-empty base64",
+		CodeDataKeyNotFound:        "data key of {hash} isn't posted",
+		CodeAllowanceInsufficient:  "{spender} may spend {allowance} tokens of {owner} for type {type}, {amount} required",
+		CodeTokenInsufficient:      "account {name} holds {token} tokens, {amount} required",
+		CodeLicenseNotFound:        "license {id} doesn't exist",
+		CodeLicenseNotHeld:         "buyer {buyer} doesn't hold a license of {hash}",
+		CodeResaleDisabled:         "title {title} of {owner} doesn't allow resale",
+		CodeLicenseNotListed:       "license {id} isn't listed for sale",
+		CodeLicenseReserved:        "license {id} is reserved for {buyer}",
+		CodeOfferNotFound:          "offer {id} doesn't exist",
+		CodeOfferClosed:            "offer {id} is {status}",
+		CodeOfferExpired:           "offer {id} expired at {expiresAt}",
+		CodeNotOfferParty:          "{account} isn't a party of offer {id}",
+		CodeOfferTurn:              "offer {id} is waiting for the other party, not {account}",
+		CodeAuctionNotFound:        "auction {id} doesn't exist",
+		CodeAuctionPhase:           "auction {id} is in phase {phase}",
+		CodeBidNotFound:            "{bidder} has no bid in auction {id}",
+		CodeBidMismatch:            "revealed bid of {bidder} doesn't match the commitment in auction {id}",
+		CodeDataExclusive:          "data {hash} is sold exclusively by auction {auction}",
+		CodeDataSold:               "data {hash} has already been sold",
+		CodeBountyNotFound:         "bounty {id} doesn't exist",
+		CodeBountyClosed:           "bounty {id} is {status}",
+		CodeBountyDeadline:         "bounty {id} stopped taking responses at {deadline}",
+		CodeBountyResponseNotFound: "data {hash} didn't respond to bounty {id}",
+		CodeRatingExists:           "{buyer} has already rated this purchase of data {hash}",
+		CodeTicketNotFound:         "download ticket {id} doesn't exist",
+		CodeTicketExpired:          "download ticket {id} expired at {expiresAt}",
+		CodeTicketMismatch:         "download ticket {id} doesn't match the ledger",
+		CodeDisputeExists:          "a dispute is already recorded for {buyer}'s purchase of {hash} in title {title} of {owner}",
+
+		CodeOrgNotFound:            "organization {name} doesn't exist",
+		CodeOrgExists:              "organization {name} already exists",
+		CodeOrgMspBound:            "MSP {mspId} is already bound to organization {org}",
+		CodeOrgMemberNotFound:      "account {name} isn't a member of organization {org}",
+		CodeAccountInOrg:           "account {name} already belongs to organization {org}",
+		CodeOrgBalanceInsufficient: "organization {org} holds {token} tokens, {amount} required",
+		CodeMemberLimitExceeded:    "member {name} of {org} has spent {spent} of limit {limit}, {amount} required",
+
+		CodeProposalNotFound:      "proposal {id} doesn't exist",
+		CodeProposalClosed:        "proposal {id} is already {status}",
+		CodeProposalExpired:       "proposal {id} expired at {expiresAt}",
+		CodeNotApprover:           "{identity} isn't an approver of proposal {id}",
+		CodeAlreadyApproved:       "{identity} has already approved proposal {id}",
+		CodeSelfApproval:          "{identity} can't approve its own proposal {id}",
+		CodeProposalAmountChanged: "proposal {id} approved {amount} tokens, {current} required now",
+	},
+	LangZH: {
+		CodeOK: "成功",
+
+		CodeInvalidArgument:   "参数无效: {reason}",
+		CodeFunctionNotFound:  "接口不存在: {reason}",
+		CodeLedgerError:       "账本操作 {operation} 失败: {cause}",
+		CodeCorruptedState:    "账本数据 {key} 解析失败",
+		CodeIdentityError:     "读取交易提交方身份失败: {cause}",
+		CodeUnsupportedLang:   "不支持的语言 {language}",
+		CodeValidationFailed:  "请求参数校验失败: {count} 个字段无效",
+		CodePermissionDenied:  "没有权限: 需要 {role} 角色",
+		CodeUnsupportedSchema: "账本数据 {key} 的版本 {version} 高于合约支持的版本 {current}",
+		CodeInternal:          "内部错误: {cause}",
+
+		CodeAccountNotFound:     "账户 {name} 不存在",
+		CodeAccountExists:       "账户 {name} 已存在",
+		CodeAccountFrozen:       "账户 {name} 已冻结",
+		CodeEncryptKeyNotSet:    "账户 {name} 未登记加密公钥",
+		CodeInvalidEncryptKey:   "加密公钥无效: {cause}",
+		CodeAccountAddressError: "交易提交方地址与账户 {name} 不一致",
+		CodeBalanceNotSettled:   "账户 {name} 积分为 {token}，需先结清或指定接收积分的账户",
+		CodeAccountClosed:       "账户 {name} 已注销",
+		CodeEscrowNotSettled:    "账户 {name} 有 {count} 笔出价或悬赏锁定积分 {token}，需先退回",
+
+		CodeDataNotFound:          "标签 {title} 的数据 {hash} 不存在",
+		CodeTitleNotFound:         "数据归属方 {owner} 的标签 {title} 不存在",
+		CodePriceOutOfRange:       "数据值[{value}]超出范围[{min} ~ {max}]",
+		CodeInvalidPriceRange:     "数据价格范围[{min} ~ {max}]无效",
+		CodePublicExtend:          "扩展信息必须通过 transient 字段 {field} 提交",
+		CodePrivateExtendMissing:  "本节点没有私有扩展信息 {hash}",
+		CodePrivateExtendMismatch: "私有扩展信息与公开Hash {hash} 不一致",
+		CodeInvalidExtendSalt:     "transient 字段 {field} 必须为 {min} 至 {max} 字节的随机数",
+
+		CodeTransferEmpty:          "交易数据或账户为空",
+		CodeTransferNotFound:       "买方 {buyer} 未购买标签 {title}",
+		CodeTransferHashMismatch:   "数据Hash {hash} 与交易记录不一致",
+		CodeInvalidWrappedKey:      "加密数据密钥必须为非空的Base64编码",
+		CodeDataKeyNotFound:        "数据 {hash} 的密钥未上传",
+		CodeAllowanceInsufficient:  "{spender} 代 {owner} 支付类型 {type} 的剩余额度 {allowance} 不足 {amount}",
+		CodeTokenInsufficient:      "账户 {name} 积分 {token} 不足 {amount}",
+		CodeLicenseNotFound:        "许可证 {id} 不存在",
+		CodeLicenseNotHeld:         "买方 {buyer} 未持有数据 {hash} 的许可证",
+		CodeResaleDisabled:         "{owner} 的标签 {title} 不允许转售",
+		CodeLicenseNotListed:       "许可证 {id} 未挂牌转售",
+		CodeLicenseReserved:        "许可证 {id} 仅转让给 {buyer}",
+		CodeOfferNotFound:          "报价 {id} 不存在",
+		CodeOfferClosed:            "报价 {id} 已{status}",
+		CodeOfferExpired:           "报价 {id} 已于 {expiresAt} 过期",
+		CodeNotOfferParty:          "{account} 不是报价 {id} 的交易方",
+		CodeOfferTurn:              "报价 {id} 等待对方处理，{account} 不能操作",
+		CodeAuctionNotFound:        "拍卖 {id} 不存在",
+		CodeAuctionPhase:           "拍卖 {id} 当前处于 {phase} 阶段",
+		CodeBidNotFound:            "{bidder} 未参与拍卖 {id}",
+		CodeBidMismatch:            "{bidder} 揭示的出价与拍卖 {id} 中的承诺不符",
+		CodeDataExclusive:          "数据 {hash} 由拍卖 {auction} 独占出售",
+		CodeDataSold:               "数据 {hash} 已售出",
+		CodeBountyNotFound:         "数据需求 {id} 不存在",
+		CodeBountyClosed:           "数据需求 {id} 已{status}",
+		CodeBountyDeadline:         "数据需求 {id} 已于 {deadline} 截止响应",
+		CodeBountyResponseNotFound: "数据 {hash} 未响应数据需求 {id}",
+		CodeRatingExists:           "{buyer} 已对本次购买的数据 {hash} 评分",
+		CodeTicketNotFound:         "下载凭证 {id} 不存在",
+		CodeTicketExpired:          "下载凭证 {id} 已于 {expiresAt} 过期",
+		CodeTicketMismatch:         "下载凭证 {id} 与账本记录不一致",
+		CodeDisputeExists:          "买方 {buyer} 购买 {owner} 标签 {title} 的数据 {hash} 已登记争议",
+
+		CodeOrgNotFound:            "组织 {name} 不存在",
+		CodeOrgExists:              "组织 {name} 已存在",
+		CodeOrgMspBound:            "MSP {mspId} 已绑定组织 {org}",
+		CodeOrgMemberNotFound:      "账户 {name} 不是组织 {org} 的成员",
+		CodeAccountInOrg:           "账户 {name} 已属于组织 {org}",
+		CodeOrgBalanceInsufficient: "组织 {org} 钱包积分 {token} 不足 {amount}",
+		CodeMemberLimitExceeded:    "组织 {org} 成员 {name} 已使用 {spent}，额度 {limit}，本次需要 {amount}",
+
+		CodeProposalNotFound:      "提案 {id} 不存在",
+		CodeProposalClosed:        "提案 {id} 状态为 {status}，不能继续操作",
+		CodeProposalExpired:       "提案 {id} 已于 {expiresAt} 过期",
+		CodeNotApprover:           "{identity} 不是提案 {id} 的审批人",
+		CodeAlreadyApproved:       "{identity} 已审批提案 {id}",
+		CodeSelfApproval:          "{identity} 不能审批自己提交的提案 {id}",
+		CodeProposalAmountChanged: "提案 {id} 审批积分 {amount}，当前需要 {current}",
+	},
+}
+
+func IsSupportedLanguage(language string) bool {
+	_, ok := messageCatalogue[language]
+	return ok
+}
+
+// 生成指定语言的信息，缺少翻译时使用英文
+func LocalizeMessage(language string, code ErrorCode, data ErrorData) string {
+	template, ok := messageCatalogue[language][code]
+	if !ok {
+		template = messageCatalogue[LangEN][code]
+	}
+	return formatMessage(template, data)
+}
+
+// 读取 transient 字段 lang 指定的语言，未指定或不支持时返回空
+func getTransientLanguage(stub shim.ChaincodeStubInterface) string {
+	if transientMap, err := stub.GetTransient(); err == nil {
+		if language := string(transientMap[TransientLanguageKey]); IsSupportedLanguage(language) {
+			return language
+		}
+	}
+	return ""
+}
+
+// 获取返回信息的语言：transient 字段 lang 优先，其次为通道配置语言
+// 未指定 lang 的交易读取配置，与 setLanguage 在同一区块提交时产生读写冲突
+func GetRequestLanguage(stub shim.ChaincodeStubInterface) string {
+	if language := getTransientLanguage(stub); language != "" {
+		return language
+	}
+	return GetChannelLanguage(stub)
+}
+
+// 读取通道配置语言，未配置或读取失败时使用默认语言，不影响接口返回
+func GetChannelLanguage(stub shim.ChaincodeStubInterface) string {
+	configKey, err := GetConfigCompositeKey(stub, ConfigLanguage)
+	if err != nil {
+		return DefaultLanguage
+	}
+	languageAsBytes, err := stub.GetState(configKey)
+	if err != nil || !IsSupportedLanguage(string(languageAsBytes)) {
+		return DefaultLanguage
+	}
+	return string(languageAsBytes)
+}
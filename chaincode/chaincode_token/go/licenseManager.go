@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 数据许可证实现(ERC-721)：
+ * 1. 每次购买一份数据生成一个许可证，编号由交易ID和数据存证计算，全局唯一
+ * 2. 许可证记录标签、数据Hash、购买时的许可条款和价格，归买方所有
+ * 3. 持有许可证是下载数据的凭证，checkTransferred 按许可证校验
+ * 4. 许可证生成前的交易记录没有许可证，仍按交易记录校验
+ * 5. 标签允许转售时，持有人可以挂牌出售许可证，或指定买方直接转让，
+ *    买方支付的积分按标签版税比例支付给数据归属方，其余支付给持有人
+ * 6. 挂牌、撤销挂牌须由持有人提交
+ */
+
+type License struct {
+	ID        string `json:"id"`                                                /*许可证编号*/
+	Holder    string `json:"holder"`                                            /*持有账户*/
+	Type      int    `json:"type"`                                              /*数据类型*/
+	Owner     string `json:"owner"`                                             /*数据归属方*/
+	Title     string `json:"title"`                                             /*数据标签名称*/
+	Hash      string `json:"hash"`                                              /*数据Hash*/
+	Terms     string `json:"terms"`                                             /*购买时的许可条款*/
+	Price     int    `json:"price"`                                             /*购买价格*/
+	Size      int    `json:"size"`                                              /*购买数据记录数*/
+	Buyer     string `json:"buyer"`                                             /*首次购买账户*/
+	IssuedAt  int64  `json:"issuedAt"`                                          /*购买时间*/
+	Listed    bool   `json:"listed,omitempty" metadata:"listed,optional"`       /*是否挂牌转售*/
+	ListPrice int64  `json:"listPrice,omitempty" metadata:"listPrice,optional"` /*转售价格*/
+	ListedFor string `json:"listedFor,omitempty" metadata:"listedFor,optional"` /*指定的买方，为空时任何账户可以购买*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (l *License) toBytes() []byte {
+	l.SchemaVersion = CurrentSchemaVersion(SchemaLicense)
+	dataAsBytes, _ := json.Marshal(l)
+	return dataAsBytes
+}
+
+func (l *License) getHolderCompositeKeyAttributes() []string {
+	return []string{l.Holder, strconv.Itoa(l.Type), l.Owner, l.Title, l.Hash, l.ID}
+}
+
+func (l *License) getListingCompositeKeyAttributes() []string {
+	return []string{strconv.Itoa(l.Type), l.Owner, l.Title, l.ID}
+}
+
+type LicenseListRequest struct {
+	ID     string `json:"id" validate:"required,max=64,charset=hex"`                                /*许可证编号*/
+	Holder string `json:"holder" validate:"required,max=64,charset=name"`                           /*持有账户*/
+	Price  int64  `json:"price" validate:"min=0,max=1000000000000"`                                 /*转售价格*/
+	Buyer  string `json:"buyer,omitempty" metadata:"buyer,optional" validate:"max=64,charset=name"` /*指定买方，直接转让，可选*/
+}
+
+type LicensePurchaseRequest struct {
+	ID      string `json:"id" validate:"required,max=64,charset=hex"`                                    /*许可证编号*/
+	Buyer   string `json:"buyer" validate:"required,max=64,charset=name"`                                /*买方账户*/
+	Spender string `json:"spender,omitempty" metadata:"spender,optional" validate:"max=64,charset=name"` /*代买方支付的账户，按买方授权额度扣减，可选*/
+}
+
+// 许可证编号：交易ID和数据存证的 SHA256
+func NewLicenseID(txID string, data DataEvidenceRequest) string {
+	hashUtil := HashUtil{algor: SHA256}
+	return hashUtil.checksum([]byte(fmt.Sprintf("%s:%d:%s:%s:%s", txID, data.Type, data.Owner, data.Title, data.Hash)))
+}
+
+const LicenseIndexName = "license"
+
+func GetLicenseCompositeKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return CreateCompositeKey(stub, LicenseIndexName, []string{id})
+}
+
+// 转售挂牌索引：数据类型、数据归属方、标签、许可证编号，值为许可证编号
+const LicenseListingIndexName = "licenseListing"
+
+func GetLicenseListingCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, LicenseListingIndexName, attributes)
+}
+
+// 持有人索引：持有账户、数据类型、数据归属方、标签、数据Hash、许可证编号，值为许可证编号
+const LicenseHolderIndexName = "licenseHolder"
+
+func GetLicenseHolderCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, LicenseHolderIndexName, attributes)
+}
+
+func GetLicense(stub shim.ChaincodeStubInterface, id string) (*License, error) {
+	licenseKey, err := GetLicenseCompositeKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	licenseAsBytes, err := GetState(stub, licenseKey)
+	if err != nil {
+		return nil, err
+	}
+	if licenseAsBytes == nil {
+		return nil, NewError(CodeLicenseNotFound, ErrorData{"id": id})
+	}
+	license := License{}
+	if err := UnmarshalRecord(SchemaLicense, licenseKey, licenseAsBytes, &license); err != nil {
+		return nil, err
+	}
+	return &license, nil
+}
+
+// 保存许可证、持有人索引和挂牌索引
+func PutLicense(stub shim.ChaincodeStubInterface, license *License) error {
+	licenseKey, err := GetLicenseCompositeKey(stub, license.ID)
+	if err != nil {
+		return err
+	}
+	if err := PutState(stub, licenseKey, license.toBytes()); err != nil {
+		return err
+	}
+	holderKey, err := GetLicenseHolderCompositeKey(stub, license.getHolderCompositeKeyAttributes())
+	if err != nil {
+		return err
+	}
+	if err := PutState(stub, holderKey, []byte(license.ID)); err != nil {
+		return err
+	}
+	listingKey, err := GetLicenseListingCompositeKey(stub, license.getListingCompositeKeyAttributes())
+	if err != nil {
+		return err
+	}
+	if !license.Listed {
+		if err := stub.DelState(listingKey); err != nil {
+			return LedgerError("DelState", err)
+		}
+		return nil
+	}
+	return PutState(stub, listingKey, []byte(license.ID))
+}
+
+// 删除原持有人索引，许可证转让给新持有人前调用
+func DeleteLicenseHolder(stub shim.ChaincodeStubInterface, license *License) error {
+	holderKey, err := GetLicenseHolderCompositeKey(stub, license.getHolderCompositeKeyAttributes())
+	if err != nil {
+		return err
+	}
+	if err := stub.DelState(holderKey); err != nil {
+		return LedgerError("DelState", err)
+	}
+	return nil
+}
+
+// 购买数据生成许可证
+func MintLicense(stub shim.ChaincodeStubInterface, buyer string, data *DataTransferEntity, timeUnix int64, events *EventBatch) (*License, error) {
+	license := License{
+		ID:       NewLicenseID(stub.GetTxID(), data.Core),
+		Holder:   buyer,
+		Type:     data.Core.Type,
+		Owner:    data.Core.Owner,
+		Title:    data.Core.Title,
+		Hash:     data.Core.Hash,
+		Terms:    data.Terms,
+		Price:    data.Price,
+		Size:     data.Description.Size,
+		Buyer:    buyer,
+		IssuedAt: timeUnix,
+	}
+	if err := PutLicense(stub, &license); err != nil {
+		return nil, err
+	}
+	events.add(EventLicenseMinted, LicenseEventPayload{
+		ID:     license.ID,
+		Holder: license.Holder,
+		Type:   license.Type,
+		Owner:  license.Owner,
+		Title:  license.Title,
+		Hash:   license.Hash,
+	})
+	return &license, nil
+}
+
+// 查找账户持有的数据许可证，未持有时返回空字符串
+func FindHeldLicense(stub shim.ChaincodeStubInterface, holder string, dataType int, owner, title, hash string) (string, error) {
+	resultIterator, err := stub.GetStateByPartialCompositeKey(LicenseHolderIndexName, []string{holder, strconv.Itoa(dataType), owner, title, hash})
+	if err != nil {
+		return "", LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	if !resultIterator.HasNext() {
+		return "", nil
+	}
+	item, err := resultIterator.Next()
+	if err != nil {
+		return "", LedgerError("Next", err)
+	}
+	return string(item.Value), nil
+}
+
+type LicenseContract struct {
+	contractapi.Contract
+	transferContract *TransferContract
+}
+
+func (s *LicenseContract) GetEvaluateTransactions() []string {
+	return []string{"ShowLicense", "ShowLicenses", "OwnerOf", "BalanceOf", "ShowLicenseListings"}
+}
+
+// 持有人挂牌转售许可证，重新挂牌覆盖原价格和指定买方
+func (s *LicenseContract) ListLicense(ctx contractapi.TransactionContextInterface, request LicenseListRequest) (*License, error) {
+
+	stub := ctx.GetStub()
+	license, err := GetLicense(stub, request.ID)
+	if err != nil {
+		return nil, err
+	}
+	if license.Holder != request.Holder {
+		return nil, NewError(CodeLicenseNotHeld, ErrorData{"buyer": request.Holder, "hash": license.Hash})
+	}
+	if request.Buyer == license.Holder {
+		return nil, InvalidArgumentError("buyer must differ from the holder")
+	}
+	holder, err := NewAccountRepository(stub).Get(license.Holder)
+	if err != nil {
+		return nil, err
+	}
+	if err := holder.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	if err := holder.checkActive(); err != nil {
+		return nil, err
+	}
+	if _, err := getResaleTitle(stub, license); err != nil {
+		return nil, err
+	}
+
+	license.Listed = true
+	license.ListPrice = request.Price
+	license.ListedFor = request.Buyer
+	if err := PutLicense(stub, license); err != nil {
+		return nil, err
+	}
+	fmt.Printf("listLicense - end %s %s %d \n", license.ID, license.Holder, license.ListPrice)
+
+	events := NewEventBatch(stub)
+	events.add(EventLicenseListed, LicenseListingEventPayload{
+		ID:     license.ID,
+		Holder: license.Holder,
+		Listed: true,
+		Price:  license.ListPrice,
+		Buyer:  license.ListedFor,
+	})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return license, nil
+}
+
+// 撤销许可证挂牌
+func (s *LicenseContract) UnlistLicense(ctx contractapi.TransactionContextInterface, id string, holder string) (*License, error) {
+
+	stub := ctx.GetStub()
+	license, err := GetLicense(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if license.Holder != holder {
+		return nil, NewError(CodeLicenseNotHeld, ErrorData{"buyer": holder, "hash": license.Hash})
+	}
+	account, err := NewAccountRepository(stub).Get(holder)
+	if err != nil {
+		return nil, err
+	}
+	if err := account.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	if !license.Listed {
+		return nil, NewError(CodeLicenseNotListed, ErrorData{"id": id})
+	}
+
+	license.Listed = false
+	license.ListPrice = 0
+	license.ListedFor = ""
+	if err := PutLicense(stub, license); err != nil {
+		return nil, err
+	}
+
+	events := NewEventBatch(stub)
+	events.add(EventLicenseListed, LicenseListingEventPayload{ID: license.ID, Holder: license.Holder})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return license, nil
+}
+
+// 购买挂牌的许可证，价格超过审批阈值时创建待审批提案
+func (s *LicenseContract) BuyLicense(ctx contractapi.TransactionContextInterface, request LicensePurchaseRequest) (*AccountTokenResponse, error) {
+
+	stub := ctx.GetStub()
+	events := NewEventBatch(stub)
+	result, err := s.buyLicense(stub, request, nil, events)
+	if err != nil {
+		return nil, err
+	}
+	if err = events.emit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// proposal 不为空时为执行已审批的提案，价格不能超过审批积分
+func (s *LicenseContract) buyLicense(stub shim.ChaincodeStubInterface, request LicensePurchaseRequest, proposal *Proposal, events *EventBatch) (*AccountTokenResponse, error) {
+
+	license, err := GetLicense(stub, request.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !license.Listed {
+		return nil, NewError(CodeLicenseNotListed, ErrorData{"id": license.ID})
+	}
+	if license.ListedFor != "" && license.ListedFor != request.Buyer {
+		return nil, NewError(CodeLicenseReserved, ErrorData{"id": license.ID, "buyer": license.ListedFor})
+	}
+	if license.Holder == request.Buyer {
+		return nil, InvalidArgumentError("buyer already holds license %s", license.ID)
+	}
+	title, err := getResaleTitle(stub, license)
+	if err != nil {
+		return nil, err
+	}
+
+	price := license.ListPrice
+	if proposal != nil {
+		if price > proposal.Amount {
+			return nil, NewError(CodeProposalAmountChanged, ErrorData{"id": proposal.ID, "amount": proposal.Amount, "current": price})
+		}
+	} else {
+		policy, err := GetApprovalPolicy(stub)
+		if err != nil {
+			return nil, err
+		}
+		exceeded, err := policy.accumulate(stub, ProposalLicense, request.Buyer, price)
+		if err != nil {
+			return nil, err
+		}
+		if exceeded {
+			return SubmitProposal(stub, policy, ProposalLicense, request.Buyer, price, request, events)
+		}
+	}
+
+	buyer, err := NewAccountRepository(stub).Get(request.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	if err := buyer.checkActive(); err != nil {
+		return nil, err
+	}
+	spender := ""
+	if request.Spender != "" && request.Spender != buyer.Name && price > 0 {
+		spender = request.Spender
+		if err := s.transferContract.spendAllowance(stub, buyer.Name, spender, map[int]int64{license.Type: price}, events); err != nil {
+			return nil, err
+		}
+	}
+
+	// 版税向下取整，其余支付给持有人；数据归属方购回时不支付版税
+	var royalty int64
+	if buyer.Name != license.Owner {
+		royalty = price * int64(title.Royalty) / 100
+	}
+	toAccounts := make(map[string]int64)
+	if royalty > 0 {
+		toAccounts[license.Owner] += royalty
+	}
+	if price > royalty {
+		toAccounts[license.Holder] += price - royalty
+	}
+	result, err := s.transferContract.transferToken(stub, buyer, toAccounts)
+	if err != nil {
+		return nil, err
+	}
+
+	seller := license.Holder
+	if err := DeleteLicenseHolder(stub, license); err != nil {
+		return nil, err
+	}
+	license.Holder = buyer.Name
+	license.Listed = false
+	license.ListPrice = 0
+	license.ListedFor = ""
+	if err := PutLicense(stub, license); err != nil {
+		return nil, err
+	}
+	if buyer.Name != license.Owner {
+		if err := RecordResale(stub, license.Owner, royalty, events); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("buyLicense - end %s %s -> %s %d royalty %d \n", license.ID, seller, license.Holder, price, royalty)
+
+	events.add(EventLicenseTransferred, LicenseTransferEventPayload{
+		ID:      license.ID,
+		From:    seller,
+		To:      license.Holder,
+		Owner:   license.Owner,
+		Price:   price,
+		Royalty: royalty,
+		Spender: spender,
+	})
+	return result, nil
+}
+
+// 许可证所属标签须允许转售
+func getResaleTitle(stub shim.ChaincodeStubInterface, license *License) (*DataTitleDescription, error) {
+	titleKey, err := GetDataTitleCompositeKey(stub, []string{strconv.Itoa(license.Type), license.Owner, license.Title})
+	if err != nil {
+		return nil, err
+	}
+	title, err := GetDataTitle(stub, titleKey)
+	if err != nil {
+		return nil, err
+	}
+	if !title.Resale {
+		return nil, NewError(CodeResaleDisabled, ErrorData{"type": license.Type, "owner": license.Owner, "title": license.Title})
+	}
+	return title, nil
+}
+
+// 查询挂牌转售的许可证，title 为空时返回数据归属方该类型的全部挂牌
+func (s *LicenseContract) ShowLicenseListings(ctx contractapi.TransactionContextInterface, dataType int, owner string, title string) ([]License, error) {
+
+	stub := ctx.GetStub()
+	attributes := []string{strconv.Itoa(dataType), owner}
+	if title != "" {
+		attributes = append(attributes, title)
+	}
+	retDataList := []License{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(LicenseListingIndexName, attributes)
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		license, err := GetLicense(stub, string(item.Value))
+		if err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, *license)
+	}
+	return retDataList, nil
+}
+
+func (s *LicenseContract) ShowLicense(ctx contractapi.TransactionContextInterface, id string) (*License, error) {
+	return GetLicense(ctx.GetStub(), id)
+}
+
+// 查询账户持有的许可证，按数据类型、归属方、标签、数据Hash排序
+func (s *LicenseContract) ShowLicenses(ctx contractapi.TransactionContextInterface, holder string) ([]License, error) {
+
+	stub := ctx.GetStub()
+	retDataList := []License{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(LicenseHolderIndexName, []string{holder})
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		license, err := GetLicense(stub, string(item.Value))
+		if err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, *license)
+	}
+	return retDataList, nil
+}
+
+// ERC-721 ownerOf
+func (s *LicenseContract) OwnerOf(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	license, err := GetLicense(ctx.GetStub(), id)
+	if err != nil {
+		return "", err
+	}
+	return license.Holder, nil
+}
+
+// ERC-721 balanceOf：账户持有的许可证数量
+func (s *LicenseContract) BalanceOf(ctx contractapi.TransactionContextInterface, holder string) (int, error) {
+
+	stub := ctx.GetStub()
+	resultIterator, err := stub.GetStateByPartialCompositeKey(LicenseHolderIndexName, []string{holder})
+	if err != nil {
+		return 0, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	count := 0
+	for resultIterator.HasNext() {
+		if _, err := resultIterator.Next(); err != nil {
+			return 0, LedgerError("Next", err)
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,30 @@
+//go:build metadata
+// +build metadata
+
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+/*
+ * 合约元数据输出：
+ * 1. 使用 metadata 构建标签编译，go run -tags metadata . metadata 输出合约元数据(JSON Schema)，供客户端生成调用代码
+ * 2. shimtest 只在该标签下链接，部署的合约不包含
+ */
+
+func init() {
+	commands["metadata"] = (*SmartContract).printMetadata
+}
+
+func (s *SmartContract) printMetadata() error {
+	stub := shimtest.NewMockStub("chaincode_token", s.chaincode)
+	response := stub.MockInvoke("metadata", [][]byte{[]byte("org.hyperledger.fabric:GetMetadata")})
+	if response.Status != shim.OK {
+		return fmt.Errorf("%s", response.Message)
+	}
+	fmt.Println(string(response.Payload))
+	return nil
+}
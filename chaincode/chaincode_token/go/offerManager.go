@@ -0,0 +1,480 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 数据报价议价实现：
+ * 1. 买方按数据单价报价，报价须在标签价格区间 [min, max] 内，编号为报价交易ID
+ * 2. 双方轮流还价，由最近一次报价的另一方接受、还价；任一方可以拒绝
+ * 3. 接受报价即按报价价格执行 transferData，超过审批阈值时报价保持已接受，提案执行后完成
+ * 4. 待处理报价超过过期时间后视为过期，状态在查询时计算，不写入账本
+ */
+
+const (
+	OfferPending  = "pending"
+	OfferAccepted = "accepted"
+	OfferExecuted = "executed"
+	OfferRejected = "rejected"
+	OfferExpired  = "expired"
+)
+
+// 报价方
+const (
+	OfferByBuyer  = "buyer"
+	OfferBySeller = "seller"
+)
+
+type Offer struct {
+	ID        string `json:"id"`                                              /*报价编号，创建报价的交易ID*/
+	Buyer     string `json:"buyer"`                                           /*买方账户*/
+	Type      int    `json:"type"`                                            /*数据类型*/
+	Owner     string `json:"owner"`                                           /*数据归属方，卖方*/
+	Title     string `json:"title"`                                           /*数据标签名称*/
+	Hash      string `json:"hash"`                                            /*数据Hash*/
+	Price     int    `json:"price"`                                           /*当前报价单价*/
+	By        string `json:"by"`                                              /*最近一次报价方：buyer、seller*/
+	Status    string `json:"status"`                                          /*状态：pending、accepted、executed、rejected、expired*/
+	CreatedAt int64  `json:"createdAt"`                                       /*创建时间*/
+	ExpiresAt int64  `json:"expiresAt"`                                       /*过期时间*/
+	ClosedAt  int64  `json:"closedAt,omitempty" metadata:"closedAt,optional"` /*完成或拒绝时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (o *Offer) toBytes() []byte {
+	o.SchemaVersion = CurrentSchemaVersion(SchemaOffer)
+	dataAsBytes, _ := json.Marshal(o)
+	return dataAsBytes
+}
+
+func (o *Offer) statusAt(timeUnix int64) string {
+	if o.Status == OfferPending && timeUnix > o.ExpiresAt {
+		return OfferExpired
+	}
+	return o.Status
+}
+
+// 账户在报价中的角色，非报价双方返回空字符串
+func (o *Offer) partyOf(account string) string {
+	switch account {
+	case o.Buyer:
+		return OfferByBuyer
+	case o.Owner:
+		return OfferBySeller
+	}
+	return ""
+}
+
+func (o *Offer) getDataEvidence() DataEvidenceRequest {
+	return DataEvidenceRequest{Type: o.Type, Owner: o.Owner, Title: o.Title, Hash: o.Hash}
+}
+
+// 按报价购买的交易请求只能包含报价的数据
+func (o *Offer) matches(request TransferRequest) error {
+	if request.Buyer != o.Buyer || len(request.Data) != 1 || request.Data[0] != o.getDataEvidence() {
+		return InvalidArgumentError("request doesn't match offer %s", o.ID)
+	}
+	return nil
+}
+
+// 报价后标签价格区间可能已调整，成交时重新校验
+func (o *Offer) unitPrice(price *DataTitlePrice) (int, error) {
+	if err := price.validValue(o.Price); err != nil {
+		return 0, err
+	}
+	return o.Price, nil
+}
+
+// 报价不锁定买方积分
+func (o *Offer) release(buyer *Account, events *EventBatch) {
+}
+
+func (o *Offer) complete(stub shim.ChaincodeStubInterface, events *EventBatch) error {
+	return closeOffer(stub, o, OfferExecuted, events)
+}
+
+func (o *Offer) toEventPayload() OfferEventPayload {
+	return OfferEventPayload{
+		ID:        o.ID,
+		Buyer:     o.Buyer,
+		Type:      o.Type,
+		Owner:     o.Owner,
+		Title:     o.Title,
+		Hash:      o.Hash,
+		Price:     o.Price,
+		By:        o.By,
+		Status:    o.Status,
+		ExpiresAt: o.ExpiresAt,
+	}
+}
+
+type OfferRequest struct {
+	Buyer     string `json:"buyer" validate:"required,max=64,charset=name"`  /*买方账户*/
+	Type      int    `json:"type" validate:"min=0,max=65535"`                /*数据类型*/
+	Owner     string `json:"owner" validate:"required,max=64,charset=name"`  /*数据归属方*/
+	Title     string `json:"title" validate:"required,max=128,charset=text"` /*数据标签名称*/
+	Hash      string `json:"hash" validate:"required,max=128,charset=hex"`   /*数据Hash*/
+	Price     int    `json:"price" validate:"min=1,max=1000000000"`          /*报价单价*/
+	ExpiresAt int64  `json:"expiresAt" validate:"min=1"`                     /*过期时间*/
+}
+
+type OfferCounterRequest struct {
+	ID        string `json:"id" validate:"required,max=128,charset=name"`                        /*报价编号*/
+	Account   string `json:"account" validate:"required,max=64,charset=name"`                    /*还价方账户，买方或数据归属方*/
+	Price     int    `json:"price" validate:"min=1,max=1000000000"`                              /*还价单价*/
+	ExpiresAt int64  `json:"expiresAt,omitempty" metadata:"expiresAt,optional" validate:"min=0"` /*新的过期时间，0 表示不变*/
+}
+
+const OfferIndexName = "offer"
+
+func GetOfferCompositeKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return CreateCompositeKey(stub, OfferIndexName, []string{id})
+}
+
+// 买方报价索引：买方、报价编号，值为报价编号
+const OfferBuyerIndexName = "offerBuyer"
+
+// 标签报价索引：数据类型、数据归属方、标签、报价编号，值为报价编号
+const OfferTitleIndexName = "offerTitle"
+
+func GetOffer(stub shim.ChaincodeStubInterface, id string) (*Offer, error) {
+	offerKey, err := GetOfferCompositeKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	offerAsBytes, err := GetState(stub, offerKey)
+	if err != nil {
+		return nil, err
+	}
+	if offerAsBytes == nil {
+		return nil, NewError(CodeOfferNotFound, ErrorData{"id": id})
+	}
+	offer := Offer{}
+	if err := UnmarshalRecord(SchemaOffer, offerKey, offerAsBytes, &offer); err != nil {
+		return nil, err
+	}
+	return &offer, nil
+}
+
+// 保存报价，新建报价时同时写入买方和标签索引
+func PutOffer(stub shim.ChaincodeStubInterface, offer *Offer, created bool) error {
+	offerKey, err := GetOfferCompositeKey(stub, offer.ID)
+	if err != nil {
+		return err
+	}
+	if err := PutState(stub, offerKey, offer.toBytes()); err != nil {
+		return err
+	}
+	if !created {
+		return nil
+	}
+	buyerKey, err := CreateCompositeKey(stub, OfferBuyerIndexName, []string{offer.Buyer, offer.ID})
+	if err != nil {
+		return err
+	}
+	if err := PutState(stub, buyerKey, []byte(offer.ID)); err != nil {
+		return err
+	}
+	titleKey, err := CreateCompositeKey(stub, OfferTitleIndexName, []string{strconv.Itoa(offer.Type), offer.Owner, offer.Title, offer.ID})
+	if err != nil {
+		return err
+	}
+	return PutState(stub, titleKey, []byte(offer.ID))
+}
+
+// 读取已接受、待执行的报价
+func GetAcceptedOffer(stub shim.ChaincodeStubInterface, id string) (*Offer, error) {
+	offer, err := GetOffer(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if offer.Status != OfferAccepted {
+		return nil, NewError(CodeOfferClosed, ErrorData{"id": id, "status": offer.Status})
+	}
+	return offer, nil
+}
+
+// 关闭报价：成交或拒绝
+func closeOffer(stub shim.ChaincodeStubInterface, offer *Offer, status string, events *EventBatch) error {
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return err
+	}
+	offer.Status = status
+	offer.ClosedAt = txTime
+	if err := PutOffer(stub, offer, false); err != nil {
+		return err
+	}
+	events.add(EventOfferChanged, offer.toEventPayload())
+	return nil
+}
+
+type OfferContract struct {
+	contractapi.Contract
+	transferContract *TransferContract
+}
+
+func (s *OfferContract) GetEvaluateTransactions() []string {
+	return []string{"ShowOffer", "ShowOffers", "ShowTitleOffers"}
+}
+
+// 买方报价，单价须在标签价格区间内
+func (s *OfferContract) MakeOffer(ctx contractapi.TransactionContextInterface, request OfferRequest) (*Offer, error) {
+
+	stub := ctx.GetStub()
+	if request.Buyer == request.Owner {
+		return nil, InvalidArgumentError("buyer and owner must differ")
+	}
+	repository := NewAccountRepository(stub)
+	buyer, err := repository.Get(request.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	if err := buyer.checkActive(); err != nil {
+		return nil, err
+	}
+	if _, err := repository.GetOpen(request.Owner); err != nil {
+		return nil, err
+	}
+	data := DataEvidenceRequest{Type: request.Type, Owner: request.Owner, Title: request.Title, Hash: request.Hash}
+	if _, err := GetDataDescription(stub, data.getDataCompositeKeyAttributes()); err != nil {
+		return nil, err
+	}
+	if err := s.validPrice(stub, data, request.Price); err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	if request.ExpiresAt <= txTime {
+		return nil, InvalidArgumentError("expiresAt %d isn't after the transaction time %d", request.ExpiresAt, txTime)
+	}
+
+	offer := Offer{
+		ID:        stub.GetTxID(),
+		Buyer:     request.Buyer,
+		Type:      request.Type,
+		Owner:     request.Owner,
+		Title:     request.Title,
+		Hash:      request.Hash,
+		Price:     request.Price,
+		By:        OfferByBuyer,
+		Status:    OfferPending,
+		CreatedAt: txTime,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if err := PutOffer(stub, &offer, true); err != nil {
+		return nil, err
+	}
+	fmt.Printf("makeOffer - end %s %s %s %d \n", offer.ID, offer.Buyer, offer.Title, offer.Price)
+
+	events := NewEventBatch(stub)
+	events.add(EventOfferChanged, offer.toEventPayload())
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &offer, nil
+}
+
+// 还价，只能由最近一次报价的另一方提出
+func (s *OfferContract) CounterOffer(ctx contractapi.TransactionContextInterface, request OfferCounterRequest) (*Offer, error) {
+
+	stub := ctx.GetStub()
+	offer, err := s.getPending(stub, request.ID)
+	if err != nil {
+		return nil, err
+	}
+	party, err := s.checkTurn(offer, request.Account)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validPrice(stub, offer.getDataEvidence(), request.Price); err != nil {
+		return nil, err
+	}
+	if request.ExpiresAt != 0 {
+		txTime, err := GetTxTimeUnix(stub)
+		if err != nil {
+			return nil, err
+		}
+		if request.ExpiresAt <= txTime {
+			return nil, InvalidArgumentError("expiresAt %d isn't after the transaction time %d", request.ExpiresAt, txTime)
+		}
+		offer.ExpiresAt = request.ExpiresAt
+	}
+
+	offer.Price = request.Price
+	offer.By = party
+	if err := PutOffer(stub, offer, false); err != nil {
+		return nil, err
+	}
+	fmt.Printf("counterOffer - end %s %s %d \n", offer.ID, party, offer.Price)
+
+	events := NewEventBatch(stub)
+	events.add(EventOfferChanged, offer.toEventPayload())
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return offer, nil
+}
+
+// 接受报价并按报价价格购买，返回买方积分，超过审批阈值时返回提案编号
+func (s *OfferContract) AcceptOffer(ctx contractapi.TransactionContextInterface, id string, account string) (*AccountTokenResponse, error) {
+
+	stub := ctx.GetStub()
+	offer, err := s.getPending(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.checkTurn(offer, account); err != nil {
+		return nil, err
+	}
+
+	events := NewEventBatch(stub)
+	offer.Status = OfferAccepted
+	if err := PutOffer(stub, offer, false); err != nil {
+		return nil, err
+	}
+	events.add(EventOfferChanged, offer.toEventPayload())
+
+	request := TransferRequest{Buyer: offer.Buyer, Data: []DataEvidenceRequest{offer.getDataEvidence()}, Offer: offer.ID}
+	result, err := s.transferContract.transferData(stub, request, nil, offer, events)
+	if err != nil {
+		return nil, err
+	}
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 买方撤回或卖方拒绝报价
+func (s *OfferContract) RejectOffer(ctx contractapi.TransactionContextInterface, id string, account string) (*Offer, error) {
+
+	stub := ctx.GetStub()
+	offer, err := s.getPending(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if offer.partyOf(account) == "" {
+		return nil, NewError(CodeNotOfferParty, ErrorData{"id": id, "account": account})
+	}
+
+	events := NewEventBatch(stub)
+	if err := closeOffer(stub, offer, OfferRejected, events); err != nil {
+		return nil, err
+	}
+	fmt.Printf("rejectOffer - end %s %s \n", id, account)
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return offer, nil
+}
+
+func (s *OfferContract) ShowOffer(ctx contractapi.TransactionContextInterface, id string) (*Offer, error) {
+
+	stub := ctx.GetStub()
+	offer, err := GetOffer(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	offer.Status = offer.statusAt(txTime)
+	return offer, nil
+}
+
+// 查询买方的全部报价
+func (s *OfferContract) ShowOffers(ctx contractapi.TransactionContextInterface, buyer string) ([]Offer, error) {
+	return s.listOffers(ctx.GetStub(), OfferBuyerIndexName, []string{buyer})
+}
+
+// 查询数据归属方收到的报价，title 为空时返回该类型的全部标签
+func (s *OfferContract) ShowTitleOffers(ctx contractapi.TransactionContextInterface, dataType int, owner string, title string) ([]Offer, error) {
+	attributes := []string{strconv.Itoa(dataType), owner}
+	if title != "" {
+		attributes = append(attributes, title)
+	}
+	return s.listOffers(ctx.GetStub(), OfferTitleIndexName, attributes)
+}
+
+func (s *OfferContract) listOffers(stub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]Offer, error) {
+
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	retDataList := []Offer{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		offer, err := GetOffer(stub, string(item.Value))
+		if err != nil {
+			return nil, err
+		}
+		offer.Status = offer.statusAt(txTime)
+		retDataList = append(retDataList, *offer)
+	}
+	return retDataList, nil
+}
+
+// 读取可还价、接受或拒绝的报价
+func (s *OfferContract) getPending(stub shim.ChaincodeStubInterface, id string) (*Offer, error) {
+	offer, err := GetOffer(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	switch offer.statusAt(txTime) {
+	case OfferPending:
+		return offer, nil
+	case OfferExpired:
+		return nil, NewError(CodeOfferExpired, ErrorData{"id": id, "expiresAt": offer.ExpiresAt})
+	default:
+		return nil, NewError(CodeOfferClosed, ErrorData{"id": id, "status": offer.Status})
+	}
+}
+
+// 只有最近一次报价的另一方可以接受或还价，返回该方角色
+func (s *OfferContract) checkTurn(offer *Offer, account string) (string, error) {
+	party := offer.partyOf(account)
+	if party == "" {
+		return "", NewError(CodeNotOfferParty, ErrorData{"id": offer.ID, "account": account})
+	}
+	if party == offer.By {
+		return "", NewError(CodeOfferTurn, ErrorData{"id": offer.ID, "account": account})
+	}
+	return party, nil
+}
+
+// 报价单价须在标签当前价格区间内
+func (s *OfferContract) validPrice(stub shim.ChaincodeStubInterface, data DataEvidenceRequest, price int) error {
+	titleKey, err := GetDataTitleCompositeKey(stub, data.getDataTitleCompositeKeyAttributes())
+	if err != nil {
+		return err
+	}
+	title, err := GetDataTitle(stub, titleKey)
+	if err != nil {
+		return err
+	}
+	return title.Price.validValue(price)
+}
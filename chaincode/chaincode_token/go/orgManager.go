@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 组织合约实现：
+ * 1. 组织与 MSP ID 一一绑定，由该 MSP 的管理员注册和管理
+ * 2. 组织成员为登录账户，一个账户只能属于一个组织
+ * 3. 组织钱包积分由成员本人从成员账户划入，供成员购买数据，成员按额度累计使用，额度为 0 时不能使用组织钱包
+ * 4. 划入组织钱包和使用组织钱包购买数据须由成员本人提交，组织钱包积分转回成员账户由管理员提交
+ * 5. 使用组织钱包的交易记录按组织索引，供组织查询
+ */
+
+type Organization struct {
+	Name  string `json:"name"`  /*组织名称*/
+	MSPID string `json:"mspId"` /*绑定的 MSP ID*/
+	Token int64  `json:"token"` /*组织钱包积分*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (o *Organization) toBytes() []byte {
+	o.SchemaVersion = CurrentSchemaVersion(SchemaOrg)
+	dataAsBytes, _ := json.Marshal(o)
+	return dataAsBytes
+}
+
+// 组织钱包不允许透支
+func (o *Organization) pay(amount int64) error {
+	if o.Token < amount {
+		return NewError(CodeOrgBalanceInsufficient, ErrorData{"org": o.Name, "token": o.Token, "amount": amount})
+	}
+	o.Token -= amount
+	return nil
+}
+
+type OrgMember struct {
+	Org     string `json:"org"`     /*组织名称*/
+	Account string `json:"account"` /*成员账户名称*/
+	Limit   int64  `json:"limit"`   /*组织钱包累计使用额度*/
+	Spent   int64  `json:"spent"`   /*已使用组织钱包积分*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (m *OrgMember) toBytes() []byte {
+	m.SchemaVersion = CurrentSchemaVersion(SchemaOrgMember)
+	dataAsBytes, _ := json.Marshal(m)
+	return dataAsBytes
+}
+
+func (m *OrgMember) spend(amount int64) error {
+	if m.Spent+amount > m.Limit {
+		return NewError(CodeMemberLimitExceeded, ErrorData{"org": m.Org, "name": m.Account, "limit": m.Limit, "spent": m.Spent, "amount": amount})
+	}
+	m.Spent += amount
+	return nil
+}
+
+const OrgIndexName = "org"
+
+func GetOrgCompositeKey(stub shim.ChaincodeStubInterface, name string) (string, error) {
+	return CreateCompositeKey(stub, OrgIndexName, []string{name})
+}
+
+// MSP ID 到组织名称的索引
+const OrgMspIndexName = "orgMsp"
+
+func GetOrgMspCompositeKey(stub shim.ChaincodeStubInterface, mspID string) (string, error) {
+	return CreateCompositeKey(stub, OrgMspIndexName, []string{mspID})
+}
+
+const OrgMemberIndexName = "orgMember"
+
+func GetOrgMemberCompositeKey(stub shim.ChaincodeStubInterface, org, account string) (string, error) {
+	return CreateCompositeKey(stub, OrgMemberIndexName, []string{org, account})
+}
+
+// 使用组织钱包的交易记录：组织、交易时间、买方、数据类型、归属方、标签
+const OrgPurchaseIndexName = "orgPurchase"
+
+func GetOrgPurchaseCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, OrgPurchaseIndexName, attributes)
+}
+
+func GetOrganization(stub shim.ChaincodeStubInterface, name string) (*Organization, error) {
+	orgKey, err := GetOrgCompositeKey(stub, name)
+	if err != nil {
+		return nil, err
+	}
+	orgAsBytes, err := GetState(stub, orgKey)
+	if err != nil {
+		return nil, err
+	}
+	if orgAsBytes == nil {
+		return nil, NewError(CodeOrgNotFound, ErrorData{"name": name})
+	}
+	org := Organization{}
+	if err := UnmarshalRecord(SchemaOrg, orgKey, orgAsBytes, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func PutOrganization(stub shim.ChaincodeStubInterface, org *Organization) error {
+	orgKey, err := GetOrgCompositeKey(stub, org.Name)
+	if err != nil {
+		return err
+	}
+	return PutState(stub, orgKey, org.toBytes())
+}
+
+func GetOrgMember(stub shim.ChaincodeStubInterface, org, account string) (*OrgMember, error) {
+	memberKey, err := GetOrgMemberCompositeKey(stub, org, account)
+	if err != nil {
+		return nil, err
+	}
+	memberAsBytes, err := GetState(stub, memberKey)
+	if err != nil {
+		return nil, err
+	}
+	if memberAsBytes == nil {
+		return nil, NewError(CodeOrgMemberNotFound, ErrorData{"org": org, "name": account})
+	}
+	member := OrgMember{}
+	if err := UnmarshalRecord(SchemaOrgMember, memberKey, memberAsBytes, &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func PutOrgMember(stub shim.ChaincodeStubInterface, member *OrgMember) error {
+	memberKey, err := GetOrgMemberCompositeKey(stub, member.Org, member.Account)
+	if err != nil {
+		return err
+	}
+	return PutState(stub, memberKey, member.toBytes())
+}
+
+func DeleteOrgMember(stub shim.ChaincodeStubInterface, org, account string) error {
+	memberKey, err := GetOrgMemberCompositeKey(stub, org, account)
+	if err != nil {
+		return err
+	}
+	if err := stub.DelState(memberKey); err != nil {
+		return LedgerError("DelState", err)
+	}
+	return nil
+}
+
+// 校验交易提交方为组织绑定 MSP 的管理员
+func RequireOrgAdmin(stub shim.ChaincodeStubInterface, org *Organization) error {
+	if err := RequireAdmin(stub); err != nil {
+		return err
+	}
+	mspID, err := GetCreatorMSPID(stub)
+	if err != nil {
+		return err
+	}
+	if mspID != org.MSPID {
+		return NewError(CodePermissionDenied, ErrorData{"role": AdminRole + "@" + org.MSPID})
+	}
+	return nil
+}
+
+type OrgPurchaseResponse struct {
+	Org     string                   `json:"org"`
+	Token   int64                    `json:"token"`   /*查询范围内使用的组织钱包积分*/
+	Records []TransferRecordResponse `json:"records"` /*交易记录，按交易时间升序*/
+}
+
+type OrgContract struct {
+	contractapi.Contract
+}
+
+func (s *OrgContract) GetEvaluateTransactions() []string {
+	return []string{"ShowOrg", "ShowOrgMembers", "ShowOrgPurchases"}
+}
+
+// 注册组织，绑定交易提交方所属 MSP
+func (s *OrgContract) RegisterOrg(ctx contractapi.TransactionContextInterface, name string) (*Organization, error) {
+
+	stub := ctx.GetStub()
+	if err := RequireAdmin(stub); err != nil {
+		return nil, err
+	}
+	mspID, err := GetCreatorMSPID(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	orgKey, err := GetOrgCompositeKey(stub, name)
+	if err != nil {
+		return nil, err
+	}
+	existAsBytes, err := GetState(stub, orgKey)
+	if err != nil {
+		return nil, err
+	}
+	if existAsBytes != nil {
+		return nil, NewError(CodeOrgExists, ErrorData{"name": name})
+	}
+	mspKey, err := GetOrgMspCompositeKey(stub, mspID)
+	if err != nil {
+		return nil, err
+	}
+	boundAsBytes, err := GetState(stub, mspKey)
+	if err != nil {
+		return nil, err
+	}
+	if boundAsBytes != nil {
+		return nil, NewError(CodeOrgMspBound, ErrorData{"mspId": mspID, "org": string(boundAsBytes)})
+	}
+
+	org := Organization{Name: name, MSPID: mspID}
+	if err := PutState(stub, orgKey, org.toBytes()); err != nil {
+		return nil, err
+	}
+	if err := PutState(stub, mspKey, []byte(name)); err != nil {
+		return nil, err
+	}
+	fmt.Printf("registerOrg - end %s %s \n", name, mspID)
+
+	events := NewEventBatch(stub)
+	events.add(EventOrgRegistered, OrgEventPayload{Name: name, MSPID: mspID})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (s *OrgContract) AddOrgMember(ctx contractapi.TransactionContextInterface, orgName string, name string, limit int64) error {
+
+	stub := ctx.GetStub()
+	org, err := GetOrganization(stub, orgName)
+	if err != nil {
+		return err
+	}
+	if err := RequireOrgAdmin(stub, org); err != nil {
+		return err
+	}
+
+	repository := NewAccountRepository(stub)
+	account, err := repository.GetOpen(name)
+	if err != nil {
+		return err
+	}
+	if account.Org != "" {
+		return NewError(CodeAccountInOrg, ErrorData{"name": name, "org": account.Org})
+	}
+	account.Org = org.Name
+	if err := repository.Save(account); err != nil {
+		return err
+	}
+	member := OrgMember{Org: org.Name, Account: name, Limit: limit}
+	if err := PutOrgMember(stub, &member); err != nil {
+		return err
+	}
+
+	events := NewEventBatch(stub)
+	events.add(EventOrgMemberChanged, OrgMemberEventPayload{Org: org.Name, Name: name, Limit: limit})
+	return events.emit()
+}
+
+func (s *OrgContract) RemoveOrgMember(ctx contractapi.TransactionContextInterface, orgName string, name string) error {
+
+	stub := ctx.GetStub()
+	org, err := GetOrganization(stub, orgName)
+	if err != nil {
+		return err
+	}
+	if err := RequireOrgAdmin(stub, org); err != nil {
+		return err
+	}
+	if _, err := GetOrgMember(stub, org.Name, name); err != nil {
+		return err
+	}
+
+	repository := NewAccountRepository(stub)
+	account, err := repository.Get(name)
+	if err != nil {
+		return err
+	}
+	account.Org = ""
+	if err := repository.Save(account); err != nil {
+		return err
+	}
+	if err := DeleteOrgMember(stub, org.Name, name); err != nil {
+		return err
+	}
+
+	events := NewEventBatch(stub)
+	events.add(EventOrgMemberChanged, OrgMemberEventPayload{Org: org.Name, Name: name, Removed: true})
+	return events.emit()
+}
+
+// 调整成员额度，已使用积分不变
+func (s *OrgContract) SetMemberLimit(ctx contractapi.TransactionContextInterface, orgName string, name string, limit int64) error {
+
+	stub := ctx.GetStub()
+	org, err := GetOrganization(stub, orgName)
+	if err != nil {
+		return err
+	}
+	if err := RequireOrgAdmin(stub, org); err != nil {
+		return err
+	}
+	member, err := GetOrgMember(stub, org.Name, name)
+	if err != nil {
+		return err
+	}
+	member.Limit = limit
+	if err := PutOrgMember(stub, member); err != nil {
+		return err
+	}
+
+	events := NewEventBatch(stub)
+	events.add(EventOrgMemberChanged, OrgMemberEventPayload{Org: org.Name, Name: name, Limit: limit, Spent: member.Spent})
+	return events.emit()
+}
+
+/*
+ * 成员账户与组织钱包之间划转积分，积分总量不变：
+ * 正数从成员账户转入组织钱包，须由成员本人提交；负数从组织钱包转回成员账户，须由组织管理员提交
+ */
+func (s *OrgContract) FundOrg(ctx contractapi.TransactionContextInterface, orgName string, name string, amount int64) (*AccountTokenResponse, error) {
+
+	if amount == 0 {
+		return nil, InvalidArgumentError("amount must not be 0")
+	}
+	stub := ctx.GetStub()
+	org, err := GetOrganization(stub, orgName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := GetOrgMember(stub, org.Name, name); err != nil {
+		return nil, err
+	}
+	repository := NewAccountRepository(stub)
+	account, err := repository.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if amount > 0 {
+		err = account.checkCreator(stub)
+	} else {
+		err = RequireOrgAdmin(stub, org)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := account.checkActive(); err != nil {
+		return nil, err
+	}
+	if amount > 0 {
+		if account.Token < amount {
+			return nil, NewError(CodeTokenInsufficient, ErrorData{"name": account.Name, "token": account.Token, "amount": amount})
+		}
+		account.Token -= amount
+		org.Token += amount
+	} else {
+		if err := org.pay(-amount); err != nil {
+			return nil, err
+		}
+		account.Token -= amount
+	}
+	if err := repository.Save(account); err != nil {
+		return nil, err
+	}
+	if err := PutOrganization(stub, org); err != nil {
+		return nil, err
+	}
+	fmt.Printf("fundOrg - end %s %d %s %d \n", org.Name, org.Token, account.Name, account.Token)
+
+	events := NewEventBatch(stub)
+	events.add(EventOrgFunded, OrgTokenEventPayload{Org: org.Name, Amount: amount, Balance: org.Token, Account: account.Name, AccountBalance: account.Token})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &AccountTokenResponse{Name: org.Name, Token: org.Token}, nil
+}
+
+func (s *OrgContract) ShowOrg(ctx contractapi.TransactionContextInterface, name string) (*Organization, error) {
+	return GetOrganization(ctx.GetStub(), name)
+}
+
+func (s *OrgContract) ShowOrgMembers(ctx contractapi.TransactionContextInterface, name string) ([]OrgMember, error) {
+
+	stub := ctx.GetStub()
+	if _, err := GetOrganization(stub, name); err != nil {
+		return nil, err
+	}
+
+	retDataList := []OrgMember{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(OrgMemberIndexName, []string{name})
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		member := OrgMember{}
+		if err := UnmarshalRecord(SchemaOrgMember, item.Key, item.Value, &member); err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, member)
+	}
+	return retDataList, nil
+}
+
+// 查询使用组织钱包的交易记录，to 为 0 时不限制截止时间
+func (s *OrgContract) ShowOrgPurchases(ctx contractapi.TransactionContextInterface, name string, from int64, to int64) (*OrgPurchaseResponse, error) {
+
+	stub := ctx.GetStub()
+	if _, err := GetOrganization(stub, name); err != nil {
+		return nil, err
+	}
+
+	retData := OrgPurchaseResponse{Org: name, Records: []TransferRecordResponse{}}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(OrgPurchaseIndexName, []string{name})
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		attributes, err := SplitCompositeKey(stub, item.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		timeUnix, err := strconv.ParseInt(attributes[1], 10, 64)
+		if err != nil {
+			return nil, CorruptedStateError(item.Key)
+		}
+		if timeUnix < from {
+			continue
+		}
+		if to > 0 && timeUnix > to {
+			break
+		}
+		dataType, err := strconv.Atoi(attributes[3])
+		if err != nil {
+			return nil, CorruptedStateError(item.Key)
+		}
+
+		var record DataTransferRecord
+		if err := UnmarshalRecord(SchemaTransfer, item.Key, item.Value, &record); err != nil {
+			return nil, err
+		}
+		retData.Records = append(retData.Records, TransferRecordResponse{
+			Buyer:  attributes[2],
+			Type:   dataType,
+			Owner:  attributes[4],
+			Title:  attributes[5],
+			Record: record,
+		})
+		retData.Token += int64(record.Price * record.Size)
+	}
+	return &retData, nil
+}
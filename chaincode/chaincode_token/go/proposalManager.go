@@ -0,0 +1,493 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/*
+ * 大额交易审批实现：
+ * 1. 管理员在通道配置中设置审批策略：增发阈值、交易阈值、审批人身份列表、所需审批数、提案有效期
+ * 2. mintToken 增发积分、transferData 交易费用、transferToken 转账积分按周期累计，超过阈值时不执行，创建待审批提案并返回提案编号
+ * 3. 审批人身份为 "MSP ID/证书 CN"，提交方不能审批自己的提案，达到所需审批数时在该审批交易中执行
+ * 4. 提案创建时记录审批人和所需审批数，之后修改审批策略不影响已创建的提案
+ * 5. 提交方或管理员可撤销待审批提案，过期提案不能审批，无需撤销
+ */
+
+// 提案类型
+const (
+	ProposalMint     = "mint"
+	ProposalTransfer = "transfer"
+	ProposalToken    = "token"
+	ProposalLicense  = "license"
+)
+
+// 提案状态，expired 不写入账本，由查询时根据过期时间返回
+const (
+	ProposalPending   = "pending"
+	ProposalExecuted  = "executed"
+	ProposalCancelled = "cancelled"
+	ProposalExpired   = "expired"
+)
+
+const ConfigApproval = "approval"
+
+// 未设置累计周期时按天累计
+const DefaultApprovalPeriod int64 = 86400
+
+// 未配置审批策略时不需要审批
+type ApprovalPolicy struct {
+	MintThreshold     int64    `json:"mintThreshold" validate:"min=0,max=1000000000000"`                                    /*周期内累计增发积分超过该值需要审批，0 表示不审批*/
+	TransferThreshold int64    `json:"transferThreshold" validate:"min=0,max=1000000000000"`                                /*账户周期内累计交易费用和转账积分超过该值需要审批，0 表示不审批*/
+	Approvers         []string `json:"approvers" validate:"required,max=100,unique=." item:"required,max=256,charset=text"` /*审批人身份，MSP ID/证书 CN，始终必填，关闭审批时将阈值设为 0*/
+	Required          int      `json:"required" validate:"min=1,max=100"`                                                   /*执行所需审批数*/
+	TTL               int64    `json:"ttl" validate:"min=60,max=31536000"`                                                  /*提案有效期，秒*/
+	Period            int64    `json:"period,omitempty" metadata:"period,optional" validate:"min=0,max=31536000"`           /*阈值累计周期，秒，0 表示 1 天*/
+}
+
+// 周期内未经审批执行的累计积分
+type ApprovalUsage struct {
+	Period int64 `json:"period"` /*周期开始时间*/
+	Amount int64 `json:"amount"` /*累计积分*/
+}
+
+// 累计积分组合键：增发为 [mint]，交易为 [transfer, 支付账户]
+const ApprovalUsageIndexName = "approvalUsage"
+
+/*
+ * 本周期累计积分加上本次积分超过阈值时返回 true，需要创建提案；否则将本次积分计入累计
+ * 负数增发（销毁）按绝对值计入增发累计；transferData、transferToken、buyLicense 共用支付账户的交易累计
+ * 周期按交易时间从 Unix 纪元起对齐，审批通过后执行的提案不计入累计
+ */
+func (p *ApprovalPolicy) accumulate(stub shim.ChaincodeStubInterface, kind string, account string, amount int64) (bool, error) {
+	if amount < 0 {
+		amount = -amount
+	}
+	var threshold int64
+	var attributes []string
+	switch kind {
+	case ProposalMint:
+		threshold, attributes = p.MintThreshold, []string{ProposalMint}
+	case ProposalTransfer, ProposalToken, ProposalLicense:
+		threshold, attributes = p.TransferThreshold, []string{ProposalTransfer, account}
+	}
+	if threshold == 0 {
+		return false, nil
+	}
+
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return false, err
+	}
+	period := p.Period
+	if period == 0 {
+		period = DefaultApprovalPeriod
+	}
+	usage := ApprovalUsage{Period: txTime - txTime%period}
+
+	usageKey, err := CreateCompositeKey(stub, ApprovalUsageIndexName, attributes)
+	if err != nil {
+		return false, err
+	}
+	usageAsBytes, err := GetState(stub, usageKey)
+	if err != nil {
+		return false, err
+	}
+	if usageAsBytes != nil {
+		var stored ApprovalUsage
+		if err := json.Unmarshal(usageAsBytes, &stored); err != nil {
+			return false, CorruptedStateError(usageKey)
+		}
+		if stored.Period == usage.Period {
+			usage.Amount = stored.Amount
+		}
+	}
+	if usage.Amount+amount > threshold {
+		return true, nil
+	}
+	usage.Amount += amount
+	usageAsBytes, _ = json.Marshal(usage)
+	return false, PutState(stub, usageKey, usageAsBytes)
+}
+
+func GetApprovalPolicy(stub shim.ChaincodeStubInterface) (*ApprovalPolicy, error) {
+	policy := ApprovalPolicy{Approvers: []string{}}
+	configKey, err := GetConfigCompositeKey(stub, ConfigApproval)
+	if err != nil {
+		return nil, err
+	}
+	policyAsBytes, err := GetState(stub, configKey)
+	if err != nil {
+		return nil, err
+	}
+	if policyAsBytes == nil {
+		return &policy, nil
+	}
+	if err := json.Unmarshal(policyAsBytes, &policy); err != nil {
+		return nil, CorruptedStateError(configKey)
+	}
+	return &policy, nil
+}
+
+type Proposal struct {
+	ID        string   `json:"id"`                                              /*提案编号，创建提案的交易ID*/
+	Kind      string   `json:"kind"`                                            /*提案类型：mint、transfer、token、license*/
+	Account   string   `json:"account"`                                         /*增发或支付账户*/
+	Amount    int64    `json:"amount"`                                          /*涉及积分，执行时不能超过该值*/
+	Request   string   `json:"request"`                                         /*原交易请求，JSON*/
+	Proposer  string   `json:"proposer"`                                        /*提交方身份*/
+	Approvers []string `json:"approvers"`                                       /*审批人身份*/
+	Required  int      `json:"required"`                                        /*执行所需审批数*/
+	Approvals []string `json:"approvals"`                                       /*已审批身份，按审批顺序*/
+	Status    string   `json:"status"`                                          /*提案状态*/
+	CreatedAt int64    `json:"createdAt"`                                       /*创建时间*/
+	ExpiresAt int64    `json:"expiresAt"`                                       /*过期时间*/
+	ClosedAt  int64    `json:"closedAt,omitempty" metadata:"closedAt,optional"` /*执行或撤销时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (p *Proposal) toBytes() []byte {
+	p.SchemaVersion = CurrentSchemaVersion(SchemaProposal)
+	dataAsBytes, _ := json.Marshal(p)
+	return dataAsBytes
+}
+
+// 待审批提案超过有效期时返回 expired
+func (p *Proposal) statusAt(timeUnix int64) string {
+	if p.Status == ProposalPending && timeUnix > p.ExpiresAt {
+		return ProposalExpired
+	}
+	return p.Status
+}
+
+func (p *Proposal) isApprover(identity string) bool {
+	for _, approver := range p.Approvers {
+		if approver == identity {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proposal) hasApproved(identity string) bool {
+	for _, approval := range p.Approvals {
+		if approval == identity {
+			return true
+		}
+	}
+	return false
+}
+
+type MintProposalRequest struct {
+	Name   string `json:"name"`   /*增发账户*/
+	Amount int64  `json:"amount"` /*增发积分*/
+}
+
+const ProposalIndexName = "proposal"
+
+func GetProposalCompositeKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return CreateCompositeKey(stub, ProposalIndexName, []string{id})
+}
+
+func GetProposal(stub shim.ChaincodeStubInterface, id string) (*Proposal, error) {
+	proposalKey, err := GetProposalCompositeKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	proposalAsBytes, err := GetState(stub, proposalKey)
+	if err != nil {
+		return nil, err
+	}
+	if proposalAsBytes == nil {
+		return nil, NewError(CodeProposalNotFound, ErrorData{"id": id})
+	}
+	proposal := Proposal{}
+	if err := UnmarshalRecord(SchemaProposal, proposalKey, proposalAsBytes, &proposal); err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+func PutProposal(stub shim.ChaincodeStubInterface, proposal *Proposal) error {
+	proposalKey, err := GetProposalCompositeKey(stub, proposal.ID)
+	if err != nil {
+		return err
+	}
+	return PutState(stub, proposalKey, proposal.toBytes())
+}
+
+// 创建待审批提案，返回账户当前积分和提案编号
+func SubmitProposal(stub shim.ChaincodeStubInterface, policy *ApprovalPolicy, kind, account string, amount int64, request interface{}, events *EventBatch) (*AccountTokenResponse, error) {
+
+	accountInfo, err := NewAccountRepository(stub).GetOpen(account)
+	if err != nil {
+		return nil, err
+	}
+	proposer, err := GetCreatorIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	requestAsBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+
+	proposal := Proposal{
+		ID:        stub.GetTxID(),
+		Kind:      kind,
+		Account:   account,
+		Amount:    amount,
+		Request:   string(requestAsBytes),
+		Proposer:  proposer,
+		Approvers: policy.Approvers,
+		Required:  policy.Required,
+		Approvals: []string{},
+		Status:    ProposalPending,
+		CreatedAt: txTime,
+		ExpiresAt: txTime + policy.TTL,
+	}
+	if err := PutProposal(stub, &proposal); err != nil {
+		return nil, err
+	}
+	fmt.Printf("submit proposal - end %s %s %s %d \n", proposal.ID, kind, account, amount)
+
+	events.add(EventProposalCreated, ProposalEventPayload{
+		ID:        proposal.ID,
+		Kind:      kind,
+		Account:   account,
+		Amount:    amount,
+		Proposer:  proposer,
+		Required:  proposal.Required,
+		ExpiresAt: proposal.ExpiresAt,
+	})
+	return &AccountTokenResponse{Name: accountInfo.Name, Token: accountInfo.Token, Proposal: proposal.ID}, nil
+}
+
+// 管理员设置审批策略，两个阈值都为 0 时关闭审批
+func (s *ConfigContract) SetApprovalPolicy(ctx contractapi.TransactionContextInterface, policy ApprovalPolicy) error {
+
+	stub := ctx.GetStub()
+	if err := RequireAdmin(stub); err != nil {
+		return err
+	}
+	if policy.Required > len(policy.Approvers) {
+		return InvalidArgumentError("required approvals %d exceed %d approvers", policy.Required, len(policy.Approvers))
+	}
+
+	configKey, err := GetConfigCompositeKey(stub, ConfigApproval)
+	if err != nil {
+		return err
+	}
+	policyAsBytes, _ := json.Marshal(policy)
+	if err := PutState(stub, configKey, policyAsBytes); err != nil {
+		return err
+	}
+	fmt.Printf("setApprovalPolicy - end %s \n", string(policyAsBytes))
+
+	events := NewEventBatch(stub)
+	events.add(EventConfigChanged, ConfigEventPayload{Name: ConfigApproval, Value: string(policyAsBytes)})
+	return events.emit()
+}
+
+func (s *ConfigContract) ShowApprovalPolicy(ctx contractapi.TransactionContextInterface) (*ApprovalPolicy, error) {
+	return GetApprovalPolicy(ctx.GetStub())
+}
+
+type ProposalContract struct {
+	contractapi.Contract
+	transferContract *TransferContract
+	licenseContract  *LicenseContract
+}
+
+func (s *ProposalContract) GetEvaluateTransactions() []string {
+	return []string{"ShowProposal", "ShowPendingProposals"}
+}
+
+// 审批提案，达到所需审批数时执行原交易，执行失败时本次审批不生效
+func (s *ProposalContract) ApproveProposal(ctx contractapi.TransactionContextInterface, id string) (*Proposal, error) {
+
+	stub := ctx.GetStub()
+	proposal, err := s.getPending(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := GetCreatorIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !proposal.isApprover(identity) {
+		return nil, NewError(CodeNotApprover, ErrorData{"id": id, "identity": identity})
+	}
+	if identity == proposal.Proposer {
+		return nil, NewError(CodeSelfApproval, ErrorData{"id": id, "identity": identity})
+	}
+	if proposal.hasApproved(identity) {
+		return nil, NewError(CodeAlreadyApproved, ErrorData{"id": id, "identity": identity})
+	}
+	proposal.Approvals = append(proposal.Approvals, identity)
+
+	events := NewEventBatch(stub)
+	events.add(EventProposalApproved, ProposalActionEventPayload{ID: id, Identity: identity, Approvals: len(proposal.Approvals)})
+	if len(proposal.Approvals) >= proposal.Required {
+		if err := s.execute(stub, proposal, events); err != nil {
+			return nil, err
+		}
+		if proposal.ClosedAt, err = GetTxTimeUnix(stub); err != nil {
+			return nil, err
+		}
+		proposal.Status = ProposalExecuted
+		events.add(EventProposalExecuted, ProposalActionEventPayload{ID: id, Approvals: len(proposal.Approvals)})
+	}
+	if err := PutProposal(stub, proposal); err != nil {
+		return nil, err
+	}
+	fmt.Printf("approveProposal - end %s %s %d/%d \n", id, identity, len(proposal.Approvals), proposal.Required)
+
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+// 提交方或管理员撤销待审批提案
+func (s *ProposalContract) CancelProposal(ctx contractapi.TransactionContextInterface, id string) (*Proposal, error) {
+
+	stub := ctx.GetStub()
+	proposal, err := s.getPending(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := GetCreatorIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	if identity != proposal.Proposer {
+		if err := RequireAdmin(stub); err != nil {
+			return nil, err
+		}
+	}
+	if proposal.ClosedAt, err = GetTxTimeUnix(stub); err != nil {
+		return nil, err
+	}
+	proposal.Status = ProposalCancelled
+	if err := PutProposal(stub, proposal); err != nil {
+		return nil, err
+	}
+	fmt.Printf("cancelProposal - end %s %s \n", id, identity)
+
+	events := NewEventBatch(stub)
+	events.add(EventProposalCancelled, ProposalActionEventPayload{ID: id, Identity: identity, Approvals: len(proposal.Approvals)})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+func (s *ProposalContract) ShowProposal(ctx contractapi.TransactionContextInterface, id string) (*Proposal, error) {
+
+	stub := ctx.GetStub()
+	proposal, err := GetProposal(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	proposal.Status = proposal.statusAt(txTime)
+	return proposal, nil
+}
+
+// 查询未过期的待审批提案
+func (s *ProposalContract) ShowPendingProposals(ctx contractapi.TransactionContextInterface) ([]Proposal, error) {
+
+	stub := ctx.GetStub()
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	retDataList := []Proposal{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(ProposalIndexName, []string{})
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		proposal := Proposal{}
+		if err := UnmarshalRecord(SchemaProposal, item.Key, item.Value, &proposal); err != nil {
+			return nil, err
+		}
+		if proposal.statusAt(txTime) == ProposalPending {
+			retDataList = append(retDataList, proposal)
+		}
+	}
+	return retDataList, nil
+}
+
+// 读取可审批或撤销的提案
+func (s *ProposalContract) getPending(stub shim.ChaincodeStubInterface, id string) (*Proposal, error) {
+	proposal, err := GetProposal(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	switch proposal.statusAt(txTime) {
+	case ProposalPending:
+		return proposal, nil
+	case ProposalExpired:
+		return nil, NewError(CodeProposalExpired, ErrorData{"id": id, "expiresAt": proposal.ExpiresAt})
+	default:
+		return nil, NewError(CodeProposalClosed, ErrorData{"id": id, "status": proposal.Status})
+	}
+}
+
+// 执行提案记录的原交易
+func (s *ProposalContract) execute(stub shim.ChaincodeStubInterface, proposal *Proposal, events *EventBatch) error {
+	switch proposal.Kind {
+	case ProposalMint:
+		request := MintProposalRequest{}
+		if err := json.Unmarshal([]byte(proposal.Request), &request); err != nil {
+			return InternalError(err)
+		}
+		_, err := mintToken(stub, request.Name, request.Amount, events)
+		return err
+	case ProposalTransfer:
+		request := TransferRequest{}
+		if err := json.Unmarshal([]byte(proposal.Request), &request); err != nil {
+			return InternalError(err)
+		}
+		_, err := s.transferContract.transferData(stub, request, proposal, nil, events)
+		return err
+	case ProposalToken:
+		request := TokenTransferRequest{}
+		if err := json.Unmarshal([]byte(proposal.Request), &request); err != nil {
+			return InternalError(err)
+		}
+		_, err := transferAccountToken(stub, request, events)
+		return err
+	case ProposalLicense:
+		request := LicensePurchaseRequest{}
+		if err := json.Unmarshal([]byte(proposal.Request), &request); err != nil {
+			return InternalError(err)
+		}
+		_, err := s.licenseContract.buyLicense(stub, request, proposal, events)
+		return err
+	}
+	return InternalError(fmt.Errorf("unknown proposal kind %s", proposal.Kind))
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"math"
+	"strconv"
+)
+
+/*
+ * 数据标签评分实现：
+ * 1. 通过 checkTransferred 校验的买方可以对购买的数据评分（1-5）并提交评价内容Hash，评价内容保存在链下
+ * 2. 每次购买只能评分一次：按买方持有的许可证区分，许可证实现前的交易按买方和数据Hash区分
+ * 3. 标签评分汇总单独保存评分次数和总分，showTitles、searchTitles 返回汇总的次数和平均分
+ * 4. 评分同时计入数据归属方信誉
+ * 5. 评分须由买方提交，数据归属方不能为自己的数据评分
+ */
+
+type Rating struct {
+	Type      int    `json:"type"`                                          /*数据类型*/
+	Owner     string `json:"owner"`                                         /*数据归属方*/
+	Title     string `json:"title"`                                         /*数据标签名称*/
+	Hash      string `json:"hash"`                                          /*数据Hash*/
+	Buyer     string `json:"buyer"`                                         /*评分的买方*/
+	License   string `json:"license,omitempty" metadata:"license,optional"` /*购买的许可证编号，许可证实现前的交易为空*/
+	Score     int    `json:"score"`                                         /*评分 1-5*/
+	Review    string `json:"review,omitempty" metadata:"review,optional"`   /*评价内容Hash*/
+	CreatedAt int64  `json:"createdAt"`                                     /*评分时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (r *Rating) toBytes() []byte {
+	r.SchemaVersion = CurrentSchemaVersion(SchemaRating)
+	dataAsBytes, _ := json.Marshal(r)
+	return dataAsBytes
+}
+
+// 标签评分汇总，保存总分避免平均分累积误差
+type TitleRating struct {
+	Count int `json:"count"` /*评分次数*/
+	Total int `json:"total"` /*评分总和*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (t *TitleRating) toBytes() []byte {
+	t.SchemaVersion = CurrentSchemaVersion(SchemaTitleRating)
+	dataAsBytes, _ := json.Marshal(t)
+	return dataAsBytes
+}
+
+// 平均分保留两位小数
+func (t *TitleRating) toSummary() *RatingSummary {
+	average := math.Round(float64(t.Total)*100/float64(t.Count)) / 100
+	return &RatingSummary{Count: t.Count, Average: average}
+}
+
+type RatingSummary struct {
+	Count   int     `json:"count"`   /*评分次数*/
+	Average float64 `json:"average"` /*平均分*/
+}
+
+type RatingRequest struct {
+	Buyer  string `json:"buyer" validate:"required,max=64,charset=name"`                              /*买方账户*/
+	Type   int    `json:"type" validate:"min=0,max=65535"`                                            /*数据类型*/
+	Owner  string `json:"owner" validate:"required,max=64,charset=name"`                              /*数据归属方*/
+	Title  string `json:"title" validate:"required,max=128,charset=text"`                             /*数据标签名称*/
+	Hash   string `json:"hash" validate:"required,max=128,charset=hex"`                               /*数据Hash*/
+	Score  int    `json:"score" validate:"min=1,max=5"`                                               /*评分 1-5*/
+	Review string `json:"review,omitempty" metadata:"review,optional" validate:"max=128,charset=hex"` /*评价内容Hash，可选*/
+}
+
+const RatingIndexName = "rating"
+
+// 评分键：数据类型、归属方、标签、数据Hash、买方、许可证编号
+func GetRatingCompositeKey(stub shim.ChaincodeStubInterface, rating *Rating) (string, error) {
+	return CreateCompositeKey(stub, RatingIndexName, []string{strconv.Itoa(rating.Type), rating.Owner, rating.Title, rating.Hash, rating.Buyer, rating.License})
+}
+
+const TitleRatingIndexName = "titleRating"
+
+func GetTitleRatingCompositeKey(stub shim.ChaincodeStubInterface, titleAttributes []string) (string, error) {
+	return CreateCompositeKey(stub, TitleRatingIndexName, titleAttributes)
+}
+
+// 读取标签评分汇总，没有评分时返回空汇总
+func GetTitleRating(stub shim.ChaincodeStubInterface, titleAttributes []string) (*TitleRating, error) {
+	ratingKey, err := GetTitleRatingCompositeKey(stub, titleAttributes)
+	if err != nil {
+		return nil, err
+	}
+	ratingAsBytes, err := GetState(stub, ratingKey)
+	if err != nil {
+		return nil, err
+	}
+	titleRating := TitleRating{}
+	if ratingAsBytes == nil {
+		return &titleRating, nil
+	}
+	if err := UnmarshalRecord(SchemaTitleRating, ratingKey, ratingAsBytes, &titleRating); err != nil {
+		return nil, err
+	}
+	return &titleRating, nil
+}
+
+// 查询标签评分汇总，没有评分时返回 nil
+func GetRatingSummary(stub shim.ChaincodeStubInterface, titleAttributes []string) (*RatingSummary, error) {
+	titleRating, err := GetTitleRating(stub, titleAttributes)
+	if err != nil || titleRating.Count == 0 {
+		return nil, err
+	}
+	return titleRating.toSummary(), nil
+}
+
+type RatingContract struct {
+	contractapi.Contract
+	transferContract *TransferContract
+}
+
+func (s *RatingContract) GetEvaluateTransactions() []string {
+	return []string{"ShowTitleRatings"}
+}
+
+// 买方对已购买的数据评分，每次购买只能评分一次
+func (s *RatingContract) RateTitle(ctx contractapi.TransactionContextInterface, request RatingRequest) (*Rating, error) {
+
+	if request.Buyer == request.Owner {
+		return nil, InvalidArgumentError("owner can't rate title %s", request.Title)
+	}
+	stub := ctx.GetStub()
+	buyer, err := NewAccountRepository(stub).Get(request.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	if err := buyer.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	if err := buyer.checkActive(); err != nil {
+		return nil, err
+	}
+	data := DownloadTitle{Title: request.Title, Hash: request.Hash}
+	checkRequest := TransferCheckRequest{Buyer: request.Buyer, Type: request.Type, Owner: request.Owner, Data: []DownloadTitle{data}}
+	_, _, licenseID, err := s.transferContract.verifyTransferred(stub, &checkRequest, data)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	rating := Rating{
+		Type:      request.Type,
+		Owner:     request.Owner,
+		Title:     request.Title,
+		Hash:      request.Hash,
+		Buyer:     request.Buyer,
+		License:   licenseID,
+		Score:     request.Score,
+		Review:    request.Review,
+		CreatedAt: txTime,
+	}
+	ratingKey, err := GetRatingCompositeKey(stub, &rating)
+	if err != nil {
+		return nil, err
+	}
+	exist, err := GetState(stub, ratingKey)
+	if err != nil {
+		return nil, err
+	}
+	if exist != nil {
+		return nil, NewError(CodeRatingExists, ErrorData{"buyer": rating.Buyer, "hash": rating.Hash})
+	}
+	if err := PutState(stub, ratingKey, rating.toBytes()); err != nil {
+		return nil, err
+	}
+
+	titleAttributes := []string{strconv.Itoa(rating.Type), rating.Owner, rating.Title}
+	titleRating, err := GetTitleRating(stub, titleAttributes)
+	if err != nil {
+		return nil, err
+	}
+	titleRating.Count++
+	titleRating.Total += rating.Score
+	titleRatingKey, err := GetTitleRatingCompositeKey(stub, titleAttributes)
+	if err != nil {
+		return nil, err
+	}
+	if err := PutState(stub, titleRatingKey, titleRating.toBytes()); err != nil {
+		return nil, err
+	}
+	summary := titleRating.toSummary()
+	fmt.Printf("rateTitle - end %s %s %d %d \n", rating.Buyer, rating.Hash, rating.Score, summary.Count)
+
+	events := NewEventBatch(stub)
+	reputation, err := GetReputation(stub, rating.Owner)
+	if err != nil {
+		return nil, err
+	}
+	reputation.Ratings++
+	reputation.RatingTotal += rating.Score
+	events.add(EventTitleRated, RatingEventPayload{
+		Type:    rating.Type,
+		Owner:   rating.Owner,
+		Title:   rating.Title,
+		Hash:    rating.Hash,
+		Buyer:   rating.Buyer,
+		License: rating.License,
+		Score:   rating.Score,
+		Review:  rating.Review,
+		Count:   summary.Count,
+		Average: summary.Average,
+	})
+	if err := PutReputation(stub, reputation, events); err != nil {
+		return nil, err
+	}
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+// 查询标签的全部评分，按数据Hash、买方排序
+func (s *RatingContract) ShowTitleRatings(ctx contractapi.TransactionContextInterface, dataType int, owner string, title string) ([]Rating, error) {
+
+	stub := ctx.GetStub()
+	retDataList := []Rating{}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(RatingIndexName, []string{strconv.Itoa(dataType), owner, title})
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		rating := Rating{}
+		if err := UnmarshalRecord(SchemaRating, item.Key, item.Value, &rating); err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, rating)
+	}
+	return retDataList, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 数据归属方信誉实现：
+ * 1. 每个数据归属方一条信誉记录，记录售出数据数、售出积分、收到的评分次数和总分，以及悬赏、转售、未成交拍卖和争议
+ * 2. 同一交易内每个归属方只写入一次：
+ *    transferData 生成交易记录时累计售出数据，发放悬赏时同时累计悬赏次数
+ *    buyLicense 转售许可证时累计转售次数和版税
+ *    拍卖未成交时按原因累计：归属方有出价时取消、归属方不能交易或数据已不存在；成交方不能交易或购买提案被取消、过期
+ *    rateTitle 评分时累计评分，recordDispute 登记争议时累计争议次数和退款积分
+ * 3. 信誉分 0-100 = 评分分 (0-80) + 经验分 (0-20) - 失信扣分 (0-40)，均为整数运算、向下取整，不低于 0：
+ *    评分分 = 80 * (评分总和 + 3*5 - (评分次数 + 5)) / (4 * (评分次数 + 5))，即加入 5 个 3 分先验评分后的平均分映射到 0-80
+ *    经验分 = min(售出数据数, 100) / 5
+ *    失信扣分 = 40 * 失信次数 / (售出数据数 + 失信次数 + 5)，失信次数为归属方原因未成交的拍卖数与争议次数之和
+ *    没有记录的归属方为 40 分
+ * 4. 管理员通过 recordDispute 登记成立的争议，每条交易记录只能登记一次，可以同时从归属方账户退款给买方
+ */
+
+// 信誉分计算参数
+const (
+	reputationPriorCount  = 5   /*先验评分次数*/
+	reputationPriorScore  = 3   /*先验评分*/
+	reputationRatingScore = 80  /*评分分上限*/
+	reputationSalesCap    = 100 /*计入经验分的售出数据数上限*/
+	reputationSalesStep   = 5   /*每个经验分对应的售出数据数*/
+	reputationPenalty     = 40  /*失信扣分上限*/
+)
+
+type Reputation struct {
+	Owner       string `json:"owner"`                                             /*数据归属方*/
+	Sales       int    `json:"sales"`                                             /*售出数据数*/
+	Volume      int64  `json:"volume"`                                            /*售出获得积分*/
+	Ratings     int    `json:"ratings"`                                           /*收到的评分次数*/
+	RatingTotal int    `json:"ratingTotal"`                                       /*收到的评分总和*/
+	Bounties    int    `json:"bounties"`                                          /*获得的悬赏次数，已计入售出数据数*/
+	Resales     int    `json:"resales"`                                           /*许可证转售次数*/
+	Royalties   int64  `json:"royalties"`                                         /*转售获得版税积分*/
+	Cancelled   int    `json:"cancelled"`                                         /*归属方原因未成交的拍卖数*/
+	Abandoned   int    `json:"abandoned"`                                         /*成交方原因未成交的拍卖数，不扣分*/
+	Disputes    int    `json:"disputes"`                                          /*成立的争议次数*/
+	Refunds     int64  `json:"refunds"`                                           /*争议退款积分*/
+	Score       int    `json:"score"`                                             /*信誉分 0-100*/
+	UpdatedAt   int64  `json:"updatedAt,omitempty" metadata:"updatedAt,optional"` /*最近更新时间，没有记录时为空*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (r *Reputation) toBytes() []byte {
+	r.SchemaVersion = CurrentSchemaVersion(SchemaReputation)
+	dataAsBytes, _ := json.Marshal(r)
+	return dataAsBytes
+}
+
+func (r *Reputation) computeScore() int {
+	count := r.Ratings + reputationPriorCount
+	total := r.RatingTotal + reputationPriorScore*reputationPriorCount
+	ratingScore := reputationRatingScore * (total - count) / (4 * count)
+	sales := r.Sales
+	if sales > reputationSalesCap {
+		sales = reputationSalesCap
+	}
+	failures := r.Cancelled + r.Disputes
+	penalty := reputationPenalty * failures / (r.Sales + failures + reputationPriorCount)
+	score := ratingScore + sales/reputationSalesStep - penalty
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+const ReputationIndexName = "reputation"
+
+func GetReputationCompositeKey(stub shim.ChaincodeStubInterface, owner string) (string, error) {
+	return CreateCompositeKey(stub, ReputationIndexName, []string{owner})
+}
+
+// 读取归属方信誉，没有记录时返回初始信誉
+func GetReputation(stub shim.ChaincodeStubInterface, owner string) (*Reputation, error) {
+	reputationKey, err := GetReputationCompositeKey(stub, owner)
+	if err != nil {
+		return nil, err
+	}
+	reputationAsBytes, err := GetState(stub, reputationKey)
+	if err != nil {
+		return nil, err
+	}
+	reputation := Reputation{Owner: owner}
+	if reputationAsBytes == nil {
+		reputation.Score = reputation.computeScore()
+		return &reputation, nil
+	}
+	if err := UnmarshalRecord(SchemaReputation, reputationKey, reputationAsBytes, &reputation); err != nil {
+		return nil, err
+	}
+	return &reputation, nil
+}
+
+// 重新计算信誉分并写入账本，同一交易内每个归属方只能调用一次
+func PutReputation(stub shim.ChaincodeStubInterface, reputation *Reputation, events *EventBatch) error {
+	timeUnix, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return err
+	}
+	reputationKey, err := GetReputationCompositeKey(stub, reputation.Owner)
+	if err != nil {
+		return err
+	}
+	reputation.Score = reputation.computeScore()
+	reputation.UpdatedAt = timeUnix
+	if err := PutState(stub, reputationKey, reputation.toBytes()); err != nil {
+		return err
+	}
+	fmt.Printf("reputation - %s score %d \n", reputation.Owner, reputation.Score)
+	events.add(EventReputationChanged, ReputationEventPayload{
+		Owner:     reputation.Owner,
+		Sales:     reputation.Sales,
+		Volume:    reputation.Volume,
+		Ratings:   reputation.Ratings,
+		Royalties: reputation.Royalties,
+		Cancelled: reputation.Cancelled,
+		Disputes:  reputation.Disputes,
+		Score:     reputation.Score,
+	})
+	return nil
+}
+
+// 拍卖未成交，byOwner 为归属方原因时扣分
+func RecordAuctionUnsold(stub shim.ChaincodeStubInterface, owner string, byOwner bool, events *EventBatch) error {
+	reputation, err := GetReputation(stub, owner)
+	if err != nil {
+		return err
+	}
+	if byOwner {
+		reputation.Cancelled++
+	} else {
+		reputation.Abandoned++
+	}
+	return PutReputation(stub, reputation, events)
+}
+
+// 许可证转售，版税为 0 时同样计入转售次数
+func RecordResale(stub shim.ChaincodeStubInterface, owner string, royalty int64, events *EventBatch) error {
+	reputation, err := GetReputation(stub, owner)
+	if err != nil {
+		return err
+	}
+	reputation.Resales++
+	reputation.Royalties += royalty
+	return PutReputation(stub, reputation, events)
+}
+
+// 成立的争议，每条交易记录只能登记一次
+type Dispute struct {
+	ID        string `json:"id"`                                            /*争议编号，登记交易ID*/
+	Buyer     string `json:"buyer"`                                         /*买方*/
+	Type      int    `json:"type"`                                          /*数据类型*/
+	Owner     string `json:"owner"`                                         /*数据归属方*/
+	Title     string `json:"title"`                                         /*数据标签名称*/
+	Hash      string `json:"hash"`                                          /*数据Hash*/
+	Refund    int64  `json:"refund"`                                        /*退款积分，从归属方账户转给买方*/
+	Reason    string `json:"reason"`                                        /*争议原因*/
+	License   string `json:"license,omitempty" metadata:"license,optional"` /*争议交易的许可证编号*/
+	CreatedAt int64  `json:"createdAt"`                                     /*登记时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (d *Dispute) toBytes() []byte {
+	d.SchemaVersion = CurrentSchemaVersion(SchemaDispute)
+	dataAsBytes, _ := json.Marshal(d)
+	return dataAsBytes
+}
+
+type DisputeRequest struct {
+	Buyer  string `json:"buyer" validate:"required,max=64,charset=name"`   /*买方*/
+	Type   int    `json:"type" validate:"min=0,max=65535"`                 /*数据类型*/
+	Owner  string `json:"owner" validate:"required,max=64,charset=name"`   /*数据归属方*/
+	Title  string `json:"title" validate:"required,max=128,charset=text"`  /*数据标签名称*/
+	Hash   string `json:"hash" validate:"required,max=128,charset=hex"`    /*数据Hash*/
+	Refund int64  `json:"refund" validate:"min=0,max=1000000000000"`       /*退款积分，不超过交易支付积分，0 为不退款*/
+	Reason string `json:"reason" validate:"required,max=256,charset=text"` /*争议原因*/
+}
+
+// 争议组合键：买方、数据类型、归属方、标签名称、数据Hash
+const DisputeIndexName = "dispute"
+
+func GetDisputeCompositeKey(stub shim.ChaincodeStubInterface, request DisputeRequest) (string, error) {
+	return CreateCompositeKey(stub, DisputeIndexName, []string{request.Buyer, strconv.Itoa(request.Type), request.Owner, request.Title, request.Hash})
+}
+
+type ReputationContract struct {
+	contractapi.Contract
+}
+
+func (s *ReputationContract) GetEvaluateTransactions() []string {
+	return []string{"ShowReputation"}
+}
+
+func (s *ReputationContract) ShowReputation(ctx contractapi.TransactionContextInterface, owner string) (*Reputation, error) {
+
+	stub := ctx.GetStub()
+	if _, err := NewAccountRepository(stub).Get(owner); err != nil {
+		return nil, err
+	}
+	return GetReputation(stub, owner)
+}
+
+// 管理员登记成立的争议，退款从归属方账户转给买方，不允许透支；许可证不收回
+func (s *ReputationContract) RecordDispute(ctx contractapi.TransactionContextInterface, request DisputeRequest) (*Dispute, error) {
+
+	stub := ctx.GetStub()
+	if err := RequireAdmin(stub); err != nil {
+		return nil, err
+	}
+	data := DataEvidenceRequest{Type: request.Type, Owner: request.Owner, Title: request.Title, Hash: request.Hash}
+	transferKey, err := GetTransferRecordCompositeKey(stub, data.getDataTransferCompositeKeyAttributes(request.Buyer))
+	if err != nil {
+		return nil, err
+	}
+	record, err := GetTransferRecord(stub, transferKey)
+	if err != nil {
+		return nil, err
+	}
+	if record.Hash != request.Hash {
+		return nil, NewError(CodeTransferHashMismatch, ErrorData{"hash": request.Hash})
+	}
+	if paid := int64(record.Price * record.Size); request.Refund > paid {
+		return nil, InvalidArgumentError("refund %d exceeds the %d tokens paid", request.Refund, paid)
+	}
+
+	disputeKey, err := GetDisputeCompositeKey(stub, request)
+	if err != nil {
+		return nil, err
+	}
+	disputeAsBytes, err := GetState(stub, disputeKey)
+	if err != nil {
+		return nil, err
+	}
+	if disputeAsBytes != nil {
+		return nil, NewError(CodeDisputeExists, ErrorData{"buyer": request.Buyer, "owner": request.Owner, "title": request.Title, "hash": request.Hash})
+	}
+
+	events := NewEventBatch(stub)
+	if request.Refund > 0 {
+		repository := NewAccountRepository(stub)
+		owner, err := repository.Get(request.Owner)
+		if err != nil {
+			return nil, err
+		}
+		buyer, err := repository.Get(request.Buyer)
+		if err != nil {
+			return nil, err
+		}
+		if owner.Token < request.Refund {
+			return nil, NewError(CodeTokenInsufficient, ErrorData{"name": owner.Name, "token": owner.Token, "amount": request.Refund})
+		}
+		if err := owner.transfer(buyer, request.Refund); err != nil {
+			return nil, err
+		}
+		if err := repository.Save(owner); err != nil {
+			return nil, err
+		}
+		if err := repository.Save(buyer); err != nil {
+			return nil, err
+		}
+		events.add(EventTokenTransferred, TokenTransferEventPayload{
+			From:        owner.Name,
+			To:          buyer.Name,
+			Amount:      request.Refund,
+			FromBalance: owner.Token,
+			ToBalance:   buyer.Token,
+		})
+	}
+
+	timeUnix, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	dispute := Dispute{
+		ID:        stub.GetTxID(),
+		Buyer:     request.Buyer,
+		Type:      request.Type,
+		Owner:     request.Owner,
+		Title:     request.Title,
+		Hash:      request.Hash,
+		Refund:    request.Refund,
+		Reason:    request.Reason,
+		License:   record.License,
+		CreatedAt: timeUnix,
+	}
+	if err := PutState(stub, disputeKey, dispute.toBytes()); err != nil {
+		return nil, err
+	}
+	events.add(EventDisputeRecorded, DisputeEventPayload{
+		ID:      dispute.ID,
+		Buyer:   dispute.Buyer,
+		Type:    dispute.Type,
+		Owner:   dispute.Owner,
+		Title:   dispute.Title,
+		Hash:    dispute.Hash,
+		Refund:  dispute.Refund,
+		License: dispute.License,
+	})
+
+	reputation, err := GetReputation(stub, request.Owner)
+	if err != nil {
+		return nil, err
+	}
+	reputation.Disputes++
+	reputation.Refunds += request.Refund
+	if err := PutReputation(stub, reputation, events); err != nil {
+		return nil, err
+	}
+	fmt.Printf("recordDispute - end %s %s %s %d \n", dispute.ID, dispute.Buyer, dispute.Owner, dispute.Refund)
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
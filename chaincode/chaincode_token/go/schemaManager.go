@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+/*
+ * 账本数据版本管理实现：
+ * 1. 账本记录携带 schemaVersion 字段，未携带的历史记录为版本 0
+ * 2. schemaMigrations 按记录类别登记升级函数，第 i 个函数将版本 i 升级到 i+1，当前版本为升级函数个数
+ * 3. 读取记录时在内存中升级到当前版本，写入时始终使用当前版本；查询交易不回写账本
+ * 4. 管理员通过 migrate 分批回写旧版本记录，全部完成后在通道配置中记录已迁移版本
+ * 修改账本记录结构时只允许追加升级函数，不允许修改已登记的函数
+ */
+
+// 记录类别
+const (
+	SchemaAccount        = "account"
+	SchemaData           = "data"
+	SchemaTitle          = "title"
+	SchemaTransfer       = "transfer"
+	SchemaOrg            = "org"
+	SchemaOrgMember      = "orgMember"
+	SchemaProposal       = "proposal"
+	SchemaAllowance      = "allowance"
+	SchemaLicense        = "license"
+	SchemaOffer          = "offer"
+	SchemaAuction        = "auction"
+	SchemaAuctionBid     = "auctionBid"
+	SchemaBounty         = "bounty"
+	SchemaBountyResponse = "bountyResponse"
+	SchemaRating         = "rating"
+	SchemaTitleRating    = "titleRating"
+	SchemaReputation     = "reputation"
+	SchemaTicket         = "ticket"
+	SchemaDataKey        = "dataKey"
+	SchemaDispute        = "dispute"
+)
+
+// 升级函数，修改 JSON 解码后的记录
+type SchemaMigration func(record map[string]interface{}) error
+
+var schemaMigrations = map[string][]SchemaMigration{
+	SchemaAccount:        {migrateAccountV1},
+	SchemaData:           {tagSchemaVersion},
+	SchemaTitle:          {tagSchemaVersion},
+	SchemaTransfer:       {tagSchemaVersion},
+	SchemaOrg:            {},
+	SchemaOrgMember:      {},
+	SchemaProposal:       {},
+	SchemaAllowance:      {},
+	SchemaLicense:        {},
+	SchemaOffer:          {},
+	SchemaAuction:        {},
+	SchemaAuctionBid:     {},
+	SchemaBounty:         {},
+	SchemaBountyResponse: {},
+	SchemaRating:         {},
+	SchemaTitleRating:    {},
+	SchemaReputation:     {},
+	SchemaTicket:         {},
+	SchemaDataKey:        {tagSchemaVersion},
+	SchemaDispute:        {},
+}
+
+// 各类别记录所在的组合键索引
+var schemaIndexes = map[string][]string{
+	SchemaAccount:        {AccountIndexName},
+	SchemaData:           {DataIndexName},
+	SchemaTitle:          {DataTitleIndexName},
+	SchemaTransfer:       {TransferIndexName, TransferTimeIndexName, SaleIndexName, OrgPurchaseIndexName},
+	SchemaOrg:            {OrgIndexName},
+	SchemaOrgMember:      {OrgMemberIndexName},
+	SchemaProposal:       {ProposalIndexName},
+	SchemaAllowance:      {AllowanceIndexName},
+	SchemaLicense:        {LicenseIndexName},
+	SchemaOffer:          {OfferIndexName},
+	SchemaAuction:        {AuctionIndexName},
+	SchemaAuctionBid:     {AuctionBidIndexName},
+	SchemaBounty:         {BountyIndexName},
+	SchemaBountyResponse: {BountyResponseIndexName},
+	SchemaRating:         {RatingIndexName},
+	SchemaTitleRating:    {TitleRatingIndexName},
+	SchemaReputation:     {ReputationIndexName},
+	SchemaTicket:         {TicketIndexName},
+	SchemaDataKey:        {DataKeyIndexName},
+	SchemaDispute:        {DisputeIndexName},
+}
+
+var schemaKinds = []string{SchemaAccount, SchemaData, SchemaTitle, SchemaTransfer, SchemaOrg, SchemaOrgMember, SchemaProposal, SchemaAllowance, SchemaLicense, SchemaOffer, SchemaAuction, SchemaAuctionBid, SchemaBounty, SchemaBountyResponse, SchemaRating, SchemaTitleRating, SchemaReputation, SchemaTicket, SchemaDataKey, SchemaDispute}
+
+// 版本 0 到 1：只增加版本号
+func tagSchemaVersion(record map[string]interface{}) error {
+	return nil
+}
+
+// 版本 0 到 1：旧版本未保存冻结标记
+func migrateAccountV1(record map[string]interface{}) error {
+	if _, ok := record["frozen"]; !ok {
+		record["frozen"] = false
+	}
+	return nil
+}
+
+func CurrentSchemaVersion(kind string) int {
+	return len(schemaMigrations[kind])
+}
+
+// 读取记录版本号
+func schemaVersionOf(key string, value []byte) (int, error) {
+	header := struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}{}
+	if err := json.Unmarshal(value, &header); err != nil {
+		return 0, CorruptedStateError(key)
+	}
+	return header.SchemaVersion, nil
+}
+
+// 将记录升级到当前版本，返回升级后的 JSON，已是当前版本时原样返回
+func UpgradeRecord(kind, key string, value []byte) ([]byte, error) {
+	version, err := schemaVersionOf(key, value)
+	if err != nil {
+		return nil, err
+	}
+	current := CurrentSchemaVersion(kind)
+	if version == current {
+		return value, nil
+	}
+	if version > current {
+		return nil, NewError(CodeUnsupportedSchema, ErrorData{"key": key, "version": version, "current": current})
+	}
+
+	// 保留数值精度，避免大整数转换为浮点数
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	record := make(map[string]interface{})
+	if err := decoder.Decode(&record); err != nil {
+		return nil, CorruptedStateError(key)
+	}
+	for _, migration := range schemaMigrations[kind][version:] {
+		if err := migration(record); err != nil {
+			return nil, err
+		}
+	}
+	record["schemaVersion"] = current
+
+	upgraded, err := json.Marshal(record)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+	return upgraded, nil
+}
+
+// 解析账本记录，旧版本记录先升级到当前版本
+func UnmarshalRecord(kind, key string, value []byte, record interface{}) error {
+	upgraded, err := UpgradeRecord(kind, key, value)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(upgraded, record); err != nil {
+		return CorruptedStateError(key)
+	}
+	return nil
+}
+
+func getSchemaConfigName(kind string) string {
+	return "schema." + kind
+}
+
+// 读取通道配置中记录的已迁移版本，未迁移时为 0
+func GetMigratedSchemaVersion(stub shim.ChaincodeStubInterface, kind string) (int, error) {
+	configKey, err := GetConfigCompositeKey(stub, getSchemaConfigName(kind))
+	if err != nil {
+		return 0, err
+	}
+	versionAsBytes, err := GetState(stub, configKey)
+	if err != nil {
+		return 0, err
+	}
+	if versionAsBytes == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(versionAsBytes))
+	if err != nil {
+		return 0, CorruptedStateError(configKey)
+	}
+	return version, nil
+}
+
+type SchemaVersionResponse struct {
+	Kind     string `json:"kind"`     /*记录类别*/
+	Version  int    `json:"version"`  /*合约当前版本*/
+	Migrated int    `json:"migrated"` /*账本已全部迁移到的版本*/
+}
+
+type SchemaMigrateResponse struct {
+	Kind      string `json:"kind"`      /*记录类别*/
+	Version   int    `json:"version"`   /*迁移目标版本*/
+	Migrated  int    `json:"migrated"`  /*本次回写的记录数*/
+	Remaining bool   `json:"remaining"` /*是否还有未迁移记录，需要继续调用*/
+}
+
+// 管理员分批回写旧版本记录，每次最多回写 limit 条
+func (s *ConfigContract) Migrate(ctx contractapi.TransactionContextInterface, kind string, limit int) (*SchemaMigrateResponse, error) {
+
+	stub := ctx.GetStub()
+	if err := RequireAdmin(stub); err != nil {
+		return nil, err
+	}
+	indexes, ok := schemaIndexes[kind]
+	if !ok {
+		return nil, InvalidArgumentError("unknown record kind %s", kind)
+	}
+
+	retData := SchemaMigrateResponse{Kind: kind, Version: CurrentSchemaVersion(kind)}
+	for _, indexName := range indexes {
+		migrated, remaining, err := s.migrateIndex(stub, kind, indexName, limit-retData.Migrated)
+		if err != nil {
+			return nil, err
+		}
+		retData.Migrated += migrated
+		if remaining {
+			retData.Remaining = true
+			break
+		}
+	}
+	fmt.Printf("migrate - %s migrated %d remaining %t \n", kind, retData.Migrated, retData.Remaining)
+	if retData.Remaining {
+		return &retData, nil
+	}
+
+	// 全部记录已是当前版本，记录已迁移版本
+	migrated, err := GetMigratedSchemaVersion(stub, kind)
+	if err != nil {
+		return nil, err
+	}
+	if migrated == retData.Version {
+		return &retData, nil
+	}
+	configKey, err := GetConfigCompositeKey(stub, getSchemaConfigName(kind))
+	if err != nil {
+		return nil, err
+	}
+	version := strconv.Itoa(retData.Version)
+	if err := PutState(stub, configKey, []byte(version)); err != nil {
+		return nil, err
+	}
+	events := NewEventBatch(stub)
+	events.add(EventConfigChanged, ConfigEventPayload{Name: getSchemaConfigName(kind), Value: version})
+	if err := events.emit(); err != nil {
+		return nil, err
+	}
+	return &retData, nil
+}
+
+// 回写索引下的旧版本记录，返回回写条数，达到 limit 后仍有旧版本记录时 remaining 为 true
+func (s *ConfigContract) migrateIndex(stub shim.ChaincodeStubInterface, kind, indexName string, limit int) (int, bool, error) {
+	resultIterator, err := stub.GetStateByPartialCompositeKey(indexName, []string{})
+	if err != nil {
+		return 0, false, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+
+	current := CurrentSchemaVersion(kind)
+	count := 0
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return count, false, LedgerError("Next", err)
+		}
+		version, err := schemaVersionOf(item.Key, item.Value)
+		if err != nil {
+			return count, false, err
+		}
+		if version == current {
+			continue
+		}
+		if count == limit {
+			return count, true, nil
+		}
+		upgraded, err := UpgradeRecord(kind, item.Key, item.Value)
+		if err != nil {
+			return count, false, err
+		}
+		if err := PutState(stub, item.Key, upgraded); err != nil {
+			return count, false, err
+		}
+		count++
+	}
+	return count, false, nil
+}
+
+func (s *ConfigContract) ShowSchemaVersion(ctx contractapi.TransactionContextInterface) ([]SchemaVersionResponse, error) {
+
+	stub := ctx.GetStub()
+	retDataList := []SchemaVersionResponse{}
+	for _, kind := range schemaKinds {
+		migrated, err := GetMigratedSchemaVersion(stub, kind)
+		if err != nil {
+			return nil, err
+		}
+		retDataList = append(retDataList, SchemaVersionResponse{
+			Kind:     kind,
+			Version:  CurrentSchemaVersion(kind),
+			Migrated: migrated,
+		})
+	}
+	return retDataList, nil
+}
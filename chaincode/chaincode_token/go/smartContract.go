@@ -3,8 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"os"
+	"strings"
 )
 
 type DataHash struct {
@@ -12,25 +15,248 @@ type DataHash struct {
 	Hash string `json:"Hash"`
 }
 
+/*
+ * 合约入口：
+ * 1. 各业务合约基于 contractapi 实现，支持 "合约名:交易名" 方式调用
+ * 2. 兼容历史函数名调用，按 legacyRoutes 转发到对应合约交易
+ * 3. 合约元数据通过 org.hyperledger.fabric:GetMetadata 查询，或以 metadata 标签构建后执行 "<chaincode> metadata" 输出
+ * 4. 业务接口统一返回 {code, message, data}，错误码定义见 errorManager.go
+ */
+
+// 系统合约前缀，返回内容不做封装
+const SystemContractPrefix = "org.hyperledger.fabric:"
+
+// 历史函数名到合约交易的映射
+type legacyRoute struct {
+	function string                       /*合约名:交易名*/
+	adapt    func(args []string) []string /*参数转换，为空时原样传递*/
+}
+
+var legacyRoutes = map[string]legacyRoute{
+	// account manager
+	"createAccount":   {function: "AccountContract:CreateAccount"},
+	"showAccount":     {function: "AccountContract:ShowAccount", adapt: argsToJSONArray},
+	"frozenAccount":   {function: "AccountContract:FrozenAccount"},
+	"deleteAccount":   {function: "AccountContract:DeleteAccount"},
+	"mintToken":       {function: "AccountContract:MintToken"},
+	"changeSecret":    {function: "AccountContract:ChangeSecret"},
+	"setEncryptKey":   {function: "AccountContract:SetEncryptKey"},
+	"migrateAccounts": {function: "AccountContract:MigrateAccounts"},
+	"closeAccount":    {function: "AccountContract:CloseAccount", adapt: padArgs(2, "")},
+	"transferToken":   {function: "AccountContract:TransferToken"},
+	// data manager
+	"setDataEvidence":  {function: "DataContract:SetDataEvidence"},
+	"showDataEvidence": {function: "DataContract:ShowDataEvidence"},
+	"setTitle":         {function: "DataContract:SetTitle"},
+	"showTitles":       {function: "DataContract:ShowTitles"},
+	"showNameOfTitles": {function: "DataContract:ShowNameOfTitles"},
+	"searchTitles":     {function: "DataContract:SearchTitles"},
+	// data transfer manager
+	"transferData":       {function: "TransferContract:TransferData"},
+	"showTransferRecord": {function: "TransferContract:ShowTransferRecord", adapt: padArgs(4, "0")},
+	"showSalesRecord":    {function: "TransferContract:ShowSalesRecord", adapt: salesRecordArgs},
+	"checkTransferred":   {function: "TransferContract:CheckTransferred"},
+	"showDataExtend":     {function: "TransferContract:ShowDataExtend"},
+	// data key exchange
+	"postDataKey":  {function: "DataKeyContract:PostDataKey"},
+	"fetchDataKey": {function: "DataKeyContract:FetchDataKey"},
+	// organization
+	"registerOrg":      {function: "OrgContract:RegisterOrg"},
+	"addOrgMember":     {function: "OrgContract:AddOrgMember"},
+	"removeOrgMember":  {function: "OrgContract:RemoveOrgMember"},
+	"setMemberLimit":   {function: "OrgContract:SetMemberLimit"},
+	"fundOrg":          {function: "OrgContract:FundOrg"},
+	"showOrg":          {function: "OrgContract:ShowOrg"},
+	"showOrgMembers":   {function: "OrgContract:ShowOrgMembers"},
+	"showOrgPurchases": {function: "OrgContract:ShowOrgPurchases", adapt: padArgs(3, "0")},
+	// fungible token (ERC-20)
+	"name":         {function: "TokenContract:Name"},
+	"symbol":       {function: "TokenContract:Symbol"},
+	"decimals":     {function: "TokenContract:Decimals"},
+	"totalSupply":  {function: "TokenContract:TotalSupply"},
+	"balanceOf":    {function: "TokenContract:BalanceOf"},
+	"transfer":     {function: "TokenContract:Transfer"},
+	"transferFrom": {function: "TokenContract:TransferFrom"},
+	"approve":      {function: "TokenContract:Approve"},
+	"allowance":    {function: "TokenContract:Allowance"},
+	// spending allowance
+	"approveSpender": {function: "AllowanceContract:ApproveSpender"},
+	"showAllowances": {function: "AllowanceContract:ShowAllowances", adapt: padArgs(2, "")},
+	// data license (ERC-721)
+	"showLicense":         {function: "LicenseContract:ShowLicense"},
+	"showLicenses":        {function: "LicenseContract:ShowLicenses"},
+	"ownerOf":             {function: "LicenseContract:OwnerOf"},
+	"listLicense":         {function: "LicenseContract:ListLicense"},
+	"unlistLicense":       {function: "LicenseContract:UnlistLicense"},
+	"buyLicense":          {function: "LicenseContract:BuyLicense"},
+	"showLicenseListings": {function: "LicenseContract:ShowLicenseListings", adapt: padArgs(3, "")},
+	// price offer
+	"makeOffer":       {function: "OfferContract:MakeOffer"},
+	"counterOffer":    {function: "OfferContract:CounterOffer"},
+	"acceptOffer":     {function: "OfferContract:AcceptOffer"},
+	"rejectOffer":     {function: "OfferContract:RejectOffer"},
+	"showOffer":       {function: "OfferContract:ShowOffer"},
+	"showOffers":      {function: "OfferContract:ShowOffers"},
+	"showTitleOffers": {function: "OfferContract:ShowTitleOffers", adapt: padArgs(3, "")},
+	// sealed-bid auction
+	"createAuction":   {function: "AuctionContract:CreateAuction"},
+	"commitBid":       {function: "AuctionContract:CommitBid"},
+	"revealBid":       {function: "AuctionContract:RevealBid"},
+	"settleAuction":   {function: "AuctionContract:SettleAuction"},
+	"cancelAuction":   {function: "AuctionContract:CancelAuction"},
+	"showAuction":     {function: "AuctionContract:ShowAuction"},
+	"showAuctions":    {function: "AuctionContract:ShowAuctions", adapt: padArgs(3, "")},
+	"showAuctionBids": {function: "AuctionContract:ShowAuctionBids"},
+	// data request bounty
+	"postBounty":          {function: "BountyContract:PostBounty"},
+	"respondBounty":       {function: "BountyContract:RespondBounty"},
+	"awardBounty":         {function: "BountyContract:AwardBounty"},
+	"closeBounty":         {function: "BountyContract:CloseBounty"},
+	"showBounty":          {function: "BountyContract:ShowBounty"},
+	"showBounties":        {function: "BountyContract:ShowBounties", adapt: padArgs(2, "false")},
+	"showBuyerBounties":   {function: "BountyContract:ShowBuyerBounties"},
+	"showBountyResponses": {function: "BountyContract:ShowBountyResponses"},
+	// title rating
+	"rateTitle":        {function: "RatingContract:RateTitle"},
+	"showTitleRatings": {function: "RatingContract:ShowTitleRatings"},
+	"showReputation":   {function: "ReputationContract:ShowReputation"},
+	"recordDispute":    {function: "ReputationContract:RecordDispute"},
+	// download ticket
+	"issueTicket":  {function: "TicketContract:IssueTicket"},
+	"verifyTicket": {function: "TicketContract:VerifyTicket"},
+	// approval proposal
+	"approveProposal":      {function: "ProposalContract:ApproveProposal"},
+	"cancelProposal":       {function: "ProposalContract:CancelProposal"},
+	"showProposal":         {function: "ProposalContract:ShowProposal"},
+	"showPendingProposals": {function: "ProposalContract:ShowPendingProposals"},
+	// channel config
+	"setLanguage":        {function: "ConfigContract:SetLanguage"},
+	"showLanguage":       {function: "ConfigContract:ShowLanguage"},
+	"migrate":            {function: "ConfigContract:Migrate"},
+	"showSchemaVersion":  {function: "ConfigContract:ShowSchemaVersion"},
+	"setApprovalPolicy":  {function: "ConfigContract:SetApprovalPolicy"},
+	"showApprovalPolicy": {function: "ConfigContract:ShowApprovalPolicy"},
+}
+
+// 可变参数合并为一个JSON数组参数
+func argsToJSONArray(args []string) []string {
+	if args == nil {
+		args = []string{}
+	}
+	argsAsBytes, _ := json.Marshal(args)
+	return []string{string(argsAsBytes)}
+}
+
+// 可选参数补齐默认值
+func padArgs(size int, value string) func(args []string) []string {
+	return func(args []string) []string {
+		padded := append([]string{}, args...)
+		for len(padded) < size {
+			padded = append(padded, value)
+		}
+		return padded
+	}
+}
+
+// 旧版查询条件中指定 type 即按数据类型过滤
+func salesRecordArgs(args []string) []string {
+	if len(args) != 1 {
+		return args
+	}
+	var request map[string]interface{}
+	if err := json.Unmarshal([]byte(args[0]), &request); err != nil {
+		return args
+	}
+	if _, ok := request["type"]; ok {
+		if _, ok := request["byType"]; !ok {
+			request["byType"] = true
+		}
+	}
+	requestAsBytes, _ := json.Marshal(request)
+	return []string{string(requestAsBytes)}
+}
+
+// 替换调用函数名和参数，其余接口由原始 stub 提供
+type legacyStub struct {
+	shim.ChaincodeStubInterface
+	function string
+	params   []string
+}
+
+func (l *legacyStub) GetFunctionAndParameters() (string, []string) {
+	return l.function, l.params
+}
+
+func (l *legacyStub) GetStringArgs() []string {
+	return append([]string{l.function}, l.params...)
+}
+
+func (l *legacyStub) GetArgs() [][]byte {
+	var args [][]byte
+	for _, arg := range l.GetStringArgs() {
+		args = append(args, []byte(arg))
+	}
+	return args
+}
+
 // Define the Smart Contract structure
 type SmartContract struct {
-	accountContract  *AccountContract
-	dataContract     *DataContract
-	transferContract *TransferContract
+	chaincode *contractapi.ContractChaincode
 }
 
-func NewSmartContract() *SmartContract {
-	return &SmartContract{
-		accountContract:  &AccountContract{},
-		dataContract:     &DataContract{},
-		transferContract: &TransferContract{},
+func NewSmartContract() (*SmartContract, error) {
+	accountContract := &AccountContract{}
+	accountContract.BeforeTransaction = NewRequestValidator("AccountContract", accountContract)
+	dataContract := &DataContract{}
+	dataContract.BeforeTransaction = NewRequestValidator("DataContract", dataContract)
+	transferContract := &TransferContract{}
+	transferContract.BeforeTransaction = NewRequestValidator("TransferContract", transferContract)
+	dataKeyContract := &DataKeyContract{transferContract: transferContract}
+	dataKeyContract.BeforeTransaction = NewRequestValidator("DataKeyContract", dataKeyContract)
+	orgContract := &OrgContract{}
+	orgContract.BeforeTransaction = NewRequestValidator("OrgContract", orgContract)
+	tokenContract := &TokenContract{}
+	tokenContract.BeforeTransaction = NewRequestValidator("TokenContract", tokenContract)
+	licenseContract := &LicenseContract{transferContract: transferContract}
+	licenseContract.BeforeTransaction = NewRequestValidator("LicenseContract", licenseContract)
+	offerContract := &OfferContract{transferContract: transferContract}
+	offerContract.BeforeTransaction = NewRequestValidator("OfferContract", offerContract)
+	auctionContract := &AuctionContract{transferContract: transferContract}
+	auctionContract.BeforeTransaction = NewRequestValidator("AuctionContract", auctionContract)
+	bountyContract := &BountyContract{transferContract: transferContract}
+	bountyContract.BeforeTransaction = NewRequestValidator("BountyContract", bountyContract)
+	ratingContract := &RatingContract{transferContract: transferContract}
+	ratingContract.BeforeTransaction = NewRequestValidator("RatingContract", ratingContract)
+	reputationContract := &ReputationContract{}
+	reputationContract.BeforeTransaction = NewRequestValidator("ReputationContract", reputationContract)
+	ticketContract := &TicketContract{transferContract: transferContract}
+	ticketContract.BeforeTransaction = NewRequestValidator("TicketContract", ticketContract)
+	allowanceContract := &AllowanceContract{}
+	allowanceContract.BeforeTransaction = NewRequestValidator("AllowanceContract", allowanceContract)
+	proposalContract := &ProposalContract{transferContract: transferContract, licenseContract: licenseContract}
+	proposalContract.BeforeTransaction = NewRequestValidator("ProposalContract", proposalContract)
+	configContract := &ConfigContract{}
+	configContract.BeforeTransaction = NewRequestValidator("ConfigContract", configContract)
+
+	chaincode, err := contractapi.NewChaincode(accountContract, dataContract, transferContract, dataKeyContract, orgContract, tokenContract, licenseContract, offerContract, auctionContract, bountyContract, ratingContract, reputationContract, ticketContract, allowanceContract, proposalContract, configContract)
+	if err != nil {
+		return nil, err
 	}
+	return &SmartContract{chaincode: chaincode}, nil
 }
 
 func (s *SmartContract) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	// parse user cert test
-	if hashByte := GetCreatorAddress(stub); hashByte != nil {
+	if hashByte, err := GetCreatorAddress(stub); err == nil {
 		fmt.Printf("creator address is %s \n", string(hashByte))
+	} else {
+		fmt.Printf("parse creator address error: %s \n", err.Error())
+	}
+
+	// 积分信息配置：Init '{"name":"...","symbol":"...","decimals":N}'
+	_, args := stub.GetFunctionAndParameters()
+	if err := InitTokenInfo(stub, args); err != nil {
+		return shim.Error(string(ErrorResponse(ParseChaincodeError(err.Error()), GetRequestLanguage(stub)).toBytes()))
 	}
 	return shim.Success(nil)
 }
@@ -44,50 +270,54 @@ func (s *SmartContract) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return shim.Success(valBytes)
 	case "invoke":
 		return shim.Success(nil)
-	// account manager
-	case "createAccount":
-		return s.accountContract.createAccount(stub, args)
-	case "showAccount":
-		return s.accountContract.showAccount(stub, args)
-	case "frozenAccount":
-		return s.accountContract.frozenAccount(stub, args)
-	case "deleteAccount":
-		return s.accountContract.deleteAccount(stub, args)
-	case "mintToken":
-		return s.accountContract.mintToken(stub, args)
-	case "changeSecret":
-		return s.accountContract.changeSecret(stub, args)
-	// data manager
-	case "setDataEvidence":
-		return s.dataContract.setDataEvidence(stub, args)
-	case "showDataEvidence":
-		return s.dataContract.showDataEvidence(stub, args)
-	case "setTitle":
-		return s.dataContract.setTitle(stub, args)
-	case "showTitles":
-		return s.dataContract.showTitles(stub, args)
-	case "showNameOfTitles":
-		return s.dataContract.showNameOfTitles(stub, args)
-	case "searchTitles":
-		return s.dataContract.searchTitles(stub, args)
-	// data transfer manager
-	case "transferData":
-		return s.transferContract.transferData(stub, args)
-	case "showTransferRecord":
-		return s.transferContract.showTransferRecord(stub, args)
-	case "checkTransferred":
-		return s.transferContract.checkTransferred(stub, args)
-	default:
 	}
 
-	return shim.Error("Invalid Smart Contract function name.")
+	if strings.HasPrefix(function, SystemContractPrefix) {
+		return s.chaincode.Invoke(stub)
+	}
+
+	if route, ok := legacyRoutes[function]; ok {
+		params := args
+		if route.adapt != nil {
+			params = route.adapt(args)
+		}
+		stub = &legacyStub{ChaincodeStubInterface: stub, function: route.function, params: params}
+	}
+	return wrapResponse(stub, s.chaincode.Invoke(stub))
+}
+
+// 合约返回内容按请求语言封装为统一结构，错误时状态码保持为错误，保证交易不被提交
+func wrapResponse(stub shim.ChaincodeStubInterface, response pb.Response) pb.Response {
+	if response.Status < shim.ERRORTHRESHOLD {
+		return shim.Success(SuccessResponse(response.Payload, GetRequestLanguage(stub)).toBytes())
+	}
+	chaincodeError := ParseChaincodeError(response.Message)
+	return shim.Error(string(ErrorResponse(chaincodeError, GetRequestLanguage(stub)).toBytes()))
 }
 
+// 命令行子命令，按构建标签注册，不随合约部署，见 metadata.go
+var commands = map[string]func(s *SmartContract) error{}
+
 // The main function is only relevant in unit test mode. Only included here for completeness.
 func main() {
 
 	// Create a new Smart Contract
-	err := shim.Start(NewSmartContract())
+	smartContract, err := NewSmartContract()
+	if err != nil {
+		fmt.Printf("Error creating new Smart Contract: %s \n", err)
+		return
+	}
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(smartContract); err != nil {
+				fmt.Printf("Error running %s: %s \n", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	err = shim.Start(smartContract)
 	if err != nil {
 		fmt.Printf("Error creating new Smart Contract: %s \n", err)
 	}
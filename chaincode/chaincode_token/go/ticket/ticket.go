@@ -0,0 +1,332 @@
+package ticket
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"math/big"
+	"strings"
+	"time"
+)
+
+/*
+ * 数据下载凭证校验，供下载网关使用：
+ * 1. 买方通过 TicketContract:IssueTicket 获取凭证，凭证编号为签发交易ID，绑定买方、数据、许可证和过期时间
+ * 2. 凭证由签发交易的背书签名证明：网关通过 qscc GetTransactionByID 读取签发交易，
+ *    确认交易有效、调用的是合约的 IssueTicket，背书签名由通道组织 MSP 签发的证书验证通过，
+ *    且背书的合约返回结果与出示的凭证一致
+ * 3. 再校验过期时间，并调用 TicketContract:VerifyTicket 确认买方仍持有购买的数据
+ * 签发交易须由买方账户地址对应的身份提交，凭证证明买方本人购买并申请了凭证；
+ * 凭证在有效期内可由任何持有者出示，网关应通过买方认证的连接下发凭证并使用较短的有效期
+ */
+
+// 合约函数名称
+const (
+	IssueFunction  = "TicketContract:IssueTicket"
+	VerifyFunction = "TicketContract:VerifyTicket"
+	legacyIssue    = "issueTicket"
+
+	// qscc 按交易ID查询交易的函数
+	QueryFunction = "GetTransactionByID"
+)
+
+var (
+	ErrNotIssued   = errors.New("ticket wasn't issued by a valid IssueTicket transaction")
+	ErrEndorsement = errors.New("ticket transaction lacks trusted endorsements")
+	ErrMismatch    = errors.New("ticket doesn't match the issuing transaction")
+	ErrExpired     = errors.New("ticket expired")
+)
+
+type Ticket struct {
+	ID        string `json:"id"`                /*凭证编号，签发交易ID*/
+	Buyer     string `json:"buyer"`             /*买方账户*/
+	Type      int    `json:"type"`              /*数据类型*/
+	Owner     string `json:"owner"`             /*数据归属方*/
+	Title     string `json:"title"`             /*数据标签名称*/
+	Hash      string `json:"hash"`              /*数据Hash*/
+	License   string `json:"license,omitempty"` /*买方持有的许可证编号，许可证实现前的交易为空*/
+	IssuedAt  int64  `json:"issuedAt"`          /*签发时间*/
+	ExpiresAt int64  `json:"expiresAt"`         /*过期时间*/
+}
+
+func (t *Ticket) Expired(now time.Time) bool {
+	return now.Unix() > t.ExpiresAt
+}
+
+func Parse(data []byte) (*Ticket, error) {
+	ticket := Ticket{}
+	if err := json.Unmarshal(data, &ticket); err != nil {
+		return nil, fmt.Errorf("invalid ticket: %w", err)
+	}
+	if ticket.ID == "" {
+		return nil, errors.New("invalid ticket: missing id")
+	}
+	return &ticket, nil
+}
+
+// 查询合约的接口，与 fabric-sdk-go gateway.Contract 的 EvaluateTransaction 一致
+type Contract interface {
+	EvaluateTransaction(name string, args ...string) ([]byte, error)
+}
+
+// 通道组织 MSP 的证书，背书节点证书须由根证书或中间证书签发
+type MSP struct {
+	Roots         *x509.CertPool
+	Intermediates *x509.CertPool /*可选*/
+}
+
+// 签发凭证的通道和合约
+type Channel struct {
+	Name         string         /*通道名称*/
+	Chaincode    string         /*合约名称*/
+	MSPs         map[string]MSP /*按 MSP ID 配置的通道组织*/
+	Endorsements int            /*至少需要背书的组织数，默认 1*/
+}
+
+// 由 PEM 格式证书生成证书池
+func NewCertPool(pems ...[]byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, data := range pems {
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificate found in pem")
+		}
+	}
+	return pool, nil
+}
+
+// 合约返回的业务错误
+type LedgerError struct {
+	Code    int
+	Message string
+}
+
+func (e *LedgerError) Error() string {
+	return fmt.Sprintf("ledger rejected ticket: %d %s", e.Code, e.Message)
+}
+
+type response struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type Verifier struct {
+	contract Contract         /*数据交易合约*/
+	qscc     Contract         /*通道的 qscc 系统合约*/
+	channel  Channel          /*签发凭证的通道*/
+	Now      func() time.Time /*当前时间，默认 time.Now*/
+}
+
+func NewVerifier(contract Contract, qscc Contract, channel Channel) *Verifier {
+	if channel.Endorsements < 1 {
+		channel.Endorsements = 1
+	}
+	return &Verifier{contract: contract, qscc: qscc, channel: channel, Now: time.Now}
+}
+
+// 校验凭证，返回账本记录的凭证
+func (v *Verifier) Verify(data []byte) (*Ticket, error) {
+	ticket, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	endorsed, err := v.endorsedTicket(ticket.ID)
+	if err != nil {
+		return nil, err
+	}
+	if *endorsed != *ticket {
+		return nil, ErrMismatch
+	}
+	if ticket.Expired(v.Now()) {
+		return nil, ErrExpired
+	}
+
+	payload, err := v.contract.EvaluateTransaction(VerifyFunction, ticket.ID)
+	if err != nil {
+		if ledgerError := findLedgerError(err.Error()); ledgerError != nil {
+			return nil, ledgerError
+		}
+		return nil, fmt.Errorf("verify ticket %s: %w", ticket.ID, err)
+	}
+	recorded, err := parseResponse(payload)
+	if err != nil {
+		return nil, fmt.Errorf("verify ticket %s: %w", ticket.ID, err)
+	}
+	if *recorded != *endorsed {
+		return nil, ErrMismatch
+	}
+	return recorded, nil
+}
+
+// 读取签发交易，校验交易和背书后返回背书的合约返回结果中的凭证
+func (v *Verifier) endorsedTicket(txID string) (*Ticket, error) {
+	transactionAsBytes, err := v.qscc.EvaluateTransaction(QueryFunction, v.channel.Name, txID)
+	if err != nil {
+		return nil, fmt.Errorf("query ticket transaction %s: %w", txID, err)
+	}
+	processed := peer.ProcessedTransaction{}
+	if err := proto.Unmarshal(transactionAsBytes, &processed); err != nil {
+		return nil, fmt.Errorf("query ticket transaction %s: %w", txID, err)
+	}
+	if processed.ValidationCode != int32(peer.TxValidationCode_VALID) || processed.TransactionEnvelope == nil {
+		return nil, ErrNotIssued
+	}
+
+	payload := common.Payload{}
+	if err := proto.Unmarshal(processed.TransactionEnvelope.Payload, &payload); err != nil || payload.Header == nil {
+		return nil, ErrNotIssued
+	}
+	channelHeader := common.ChannelHeader{}
+	if err := proto.Unmarshal(payload.Header.ChannelHeader, &channelHeader); err != nil {
+		return nil, ErrNotIssued
+	}
+	if channelHeader.Type != int32(common.HeaderType_ENDORSER_TRANSACTION) || channelHeader.TxId != txID || channelHeader.ChannelId != v.channel.Name {
+		return nil, ErrNotIssued
+	}
+	transaction := peer.Transaction{}
+	if err := proto.Unmarshal(payload.Data, &transaction); err != nil || len(transaction.Actions) != 1 {
+		return nil, ErrNotIssued
+	}
+	actionPayload := peer.ChaincodeActionPayload{}
+	if err := proto.Unmarshal(transaction.Actions[0].Payload, &actionPayload); err != nil || actionPayload.Action == nil {
+		return nil, ErrNotIssued
+	}
+	if err := v.checkInvocation(actionPayload.ChaincodeProposalPayload); err != nil {
+		return nil, err
+	}
+	if err := v.checkEndorsements(actionPayload.Action); err != nil {
+		return nil, err
+	}
+
+	responsePayload := peer.ProposalResponsePayload{}
+	if err := proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, &responsePayload); err != nil {
+		return nil, ErrNotIssued
+	}
+	chaincodeAction := peer.ChaincodeAction{}
+	if err := proto.Unmarshal(responsePayload.Extension, &chaincodeAction); err != nil || chaincodeAction.Response == nil {
+		return nil, ErrNotIssued
+	}
+	if chaincodeAction.ChaincodeId == nil || chaincodeAction.ChaincodeId.Name != v.channel.Chaincode {
+		return nil, ErrNotIssued
+	}
+	endorsed, err := parseResponse(chaincodeAction.Response.Payload)
+	if err != nil || endorsed.ID != txID {
+		return nil, ErrNotIssued
+	}
+	return endorsed, nil
+}
+
+// 交易调用的是合约的 IssueTicket
+func (v *Verifier) checkInvocation(proposalPayloadAsBytes []byte) error {
+	proposalPayload := peer.ChaincodeProposalPayload{}
+	if err := proto.Unmarshal(proposalPayloadAsBytes, &proposalPayload); err != nil {
+		return ErrNotIssued
+	}
+	invocation := peer.ChaincodeInvocationSpec{}
+	if err := proto.Unmarshal(proposalPayload.Input, &invocation); err != nil {
+		return ErrNotIssued
+	}
+	spec := invocation.ChaincodeSpec
+	if spec == nil || spec.ChaincodeId == nil || spec.ChaincodeId.Name != v.channel.Chaincode || spec.Input == nil || len(spec.Input.Args) == 0 {
+		return ErrNotIssued
+	}
+	if function := string(spec.Input.Args[0]); function != IssueFunction && function != legacyIssue {
+		return ErrNotIssued
+	}
+	return nil
+}
+
+// 背书签名为背书节点私钥对合约返回结果和背书节点身份的签名，节点证书须由配置的 MSP 签发
+func (v *Verifier) checkEndorsements(action *peer.ChaincodeEndorsedAction) error {
+	endorsedBy := make(map[string]bool)
+	for _, endorsement := range action.Endorsements {
+		identity := msp.SerializedIdentity{}
+		if err := proto.Unmarshal(endorsement.Endorser, &identity); err != nil {
+			continue
+		}
+		org, ok := v.channel.MSPs[identity.Mspid]
+		if !ok {
+			continue
+		}
+		cert, err := parseCertificate(identity.IdBytes)
+		if err != nil {
+			continue
+		}
+		options := x509.VerifyOptions{Roots: org.Roots, Intermediates: org.Intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+		if _, err := cert.Verify(options); err != nil {
+			continue
+		}
+		signed := append(append([]byte{}, action.ProposalResponsePayload...), endorsement.Endorser...)
+		if verifySignature(cert, signed, endorsement.Signature) {
+			endorsedBy[identity.Mspid] = true
+		}
+	}
+	if len(endorsedBy) < v.channel.Endorsements {
+		return ErrEndorsement
+	}
+	return nil
+}
+
+func parseCertificate(certPem []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		return nil, errors.New("decode endorser certificate error")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// Fabric 节点使用 ECDSA 签名 SHA-256 摘要
+func verifySignature(cert *x509.Certificate, message []byte, signature []byte) bool {
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	sig := ecdsaSignature{}
+	if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 || sig.R == nil || sig.S == nil {
+		return false
+	}
+	digest := sha256.Sum256(message)
+	return ecdsa.Verify(publicKey, digest[:], sig.R, sig.S)
+}
+
+// 解析合约统一返回结构中的凭证
+func parseResponse(payload []byte) (*Ticket, error) {
+	result := response{}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if result.Code != 0 {
+		return nil, &LedgerError{Code: result.Code, Message: result.Message}
+	}
+	ticket := Ticket{}
+	if err := json.Unmarshal(result.Data, &ticket); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &ticket, nil
+}
+
+// 合约校验失败时响应状态为 500，SDK 返回的错误信息中包含合约返回结构
+func findLedgerError(message string) *LedgerError {
+	index := strings.Index(message, `{"code":`)
+	if index < 0 {
+		return nil
+	}
+	result := response{}
+	if err := json.NewDecoder(strings.NewReader(message[index:])).Decode(&result); err != nil || result.Code == 0 {
+		return nil
+	}
+	return &LedgerError{Code: result.Code, Message: result.Message}
+}
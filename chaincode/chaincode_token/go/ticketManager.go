@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/*
+ * 数据下载凭证实现：
+ * 1. 买方通过 checkTransferred 相同的校验后获取凭证，凭证编号为签发交易ID，绑定买方、数据、许可证和过期时间，
+ *    签发须由买方账户地址对应的身份提交
+ * 2. 合约不能保存私钥，凭证由签发交易的背书签名证明：返回的凭证包含在背书节点签名的交易结果中
+ * 3. 下载网关使用 ticket 包通过 qscc 读取签发交易，按通道组织 MSP 校验背书签名和过期时间，
+ *    再通过 verifyTicket 确认账本记录一致、买方仍持有购买的数据
+ */
+
+type DownloadTicket struct {
+	ID        string `json:"id"`                                            /*凭证编号，签发交易ID*/
+	Buyer     string `json:"buyer"`                                         /*买方账户*/
+	Type      int    `json:"type"`                                          /*数据类型*/
+	Owner     string `json:"owner"`                                         /*数据归属方*/
+	Title     string `json:"title"`                                         /*数据标签名称*/
+	Hash      string `json:"hash"`                                          /*数据Hash*/
+	License   string `json:"license,omitempty" metadata:"license,optional"` /*买方持有的许可证编号，许可证实现前的交易为空*/
+	IssuedAt  int64  `json:"issuedAt"`                                      /*签发时间*/
+	ExpiresAt int64  `json:"expiresAt"`                                     /*过期时间*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
+}
+
+// 写入账本时使用当前记录版本
+func (d *DownloadTicket) toBytes() []byte {
+	d.SchemaVersion = CurrentSchemaVersion(SchemaTicket)
+	dataAsBytes, _ := json.Marshal(d)
+	return dataAsBytes
+}
+
+func (d *DownloadTicket) getTransferCheckRequest() TransferCheckRequest {
+	return TransferCheckRequest{Buyer: d.Buyer, Type: d.Type, Owner: d.Owner, Data: []DownloadTitle{{Title: d.Title, Hash: d.Hash}}}
+}
+
+type TicketRequest struct {
+	Buyer string `json:"buyer" validate:"required,max=64,charset=name"`  /*买方账户*/
+	Type  int    `json:"type" validate:"min=0,max=65535"`                /*数据类型*/
+	Owner string `json:"owner" validate:"required,max=64,charset=name"`  /*数据归属方*/
+	Title string `json:"title" validate:"required,max=128,charset=text"` /*数据标签名称*/
+	Hash  string `json:"hash" validate:"required,max=128,charset=hex"`   /*数据Hash*/
+	TTL   int64  `json:"ttl" validate:"min=1,max=86400"`                 /*有效期，秒，最长一天*/
+}
+
+const TicketIndexName = "ticket"
+
+func GetTicketCompositeKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return CreateCompositeKey(stub, TicketIndexName, []string{id})
+}
+
+func GetTicket(stub shim.ChaincodeStubInterface, id string) (*DownloadTicket, error) {
+	ticketKey, err := GetTicketCompositeKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	ticketAsBytes, err := GetState(stub, ticketKey)
+	if err != nil {
+		return nil, err
+	}
+	if ticketAsBytes == nil {
+		return nil, NewError(CodeTicketNotFound, ErrorData{"id": id})
+	}
+	downloadTicket := DownloadTicket{}
+	if err := UnmarshalRecord(SchemaTicket, ticketKey, ticketAsBytes, &downloadTicket); err != nil {
+		return nil, err
+	}
+	return &downloadTicket, nil
+}
+
+type TicketContract struct {
+	contractapi.Contract
+	transferContract *TransferContract
+}
+
+func (s *TicketContract) GetEvaluateTransactions() []string {
+	return []string{"VerifyTicket"}
+}
+
+// 校验买方已购买数据后签发下载凭证
+func (s *TicketContract) IssueTicket(ctx contractapi.TransactionContextInterface, request TicketRequest) (*DownloadTicket, error) {
+
+	stub := ctx.GetStub()
+	buyer, err := NewAccountRepository(stub).Get(request.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	if err := buyer.checkCreator(stub); err != nil {
+		return nil, err
+	}
+	if err := buyer.checkActive(); err != nil {
+		return nil, err
+	}
+	downloadTicket := DownloadTicket{
+		ID:    stub.GetTxID(),
+		Buyer: request.Buyer,
+		Type:  request.Type,
+		Owner: request.Owner,
+		Title: request.Title,
+		Hash:  request.Hash,
+	}
+	checkRequest := downloadTicket.getTransferCheckRequest()
+	_, _, licenseID, err := s.transferContract.verifyTransferred(stub, &checkRequest, checkRequest.Data[0])
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	downloadTicket.License = licenseID
+	downloadTicket.IssuedAt = txTime
+	downloadTicket.ExpiresAt = txTime + request.TTL
+
+	ticketKey, err := GetTicketCompositeKey(stub, downloadTicket.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := PutState(stub, ticketKey, downloadTicket.toBytes()); err != nil {
+		return nil, err
+	}
+	fmt.Printf("issueTicket - end %s %s %s %d \n", downloadTicket.ID, downloadTicket.Buyer, downloadTicket.Hash, downloadTicket.ExpiresAt)
+	return &downloadTicket, nil
+}
+
+// 下载网关校验凭证：未过期，且买方仍持有购买的数据
+func (s *TicketContract) VerifyTicket(ctx contractapi.TransactionContextInterface, id string) (*DownloadTicket, error) {
+
+	stub := ctx.GetStub()
+	downloadTicket, err := GetTicket(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+	if txTime > downloadTicket.ExpiresAt {
+		return nil, NewError(CodeTicketExpired, ErrorData{"id": id, "expiresAt": downloadTicket.ExpiresAt})
+	}
+	// 许可证转售后原买方的凭证失效
+	checkRequest := downloadTicket.getTransferCheckRequest()
+	_, _, licenseID, err := s.transferContract.verifyTransferred(stub, &checkRequest, checkRequest.Data[0])
+	if err != nil {
+		return nil, err
+	}
+	if licenseID != downloadTicket.License {
+		return nil, NewError(CodeLicenseNotHeld, ErrorData{"buyer": downloadTicket.Buyer, "hash": downloadTicket.Hash})
+	}
+	return downloadTicket, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+ * 标准积分接口实现(ERC-20)：
+ * 1. 积分名称、符号、小数位数在 Init 时配置，保存在通道配置中
+ * 2. 余额即账户积分 Account.Token，账户按名称指定，与其他合约一致
+ * 3. transfer、transferFrom 即 transferToken，同样受交易审批阈值约束，须由转出账户或代付账户提交
+ * 4. approve、allowance 使用不限数据类型、不过期的代付额度，approve 须由授权账户提交
+ * 5. 交易除原有事件外增加 erc20.transfer、erc20.approval 事件
+ * 6. 发行总量保存在通道配置中，随增发、销毁更新；计数不存在时按账户、组织钱包和锁定积分合计一次
+ */
+
+const ConfigToken = "token"
+
+// 发行总量计数，只在增发、销毁和旧版账户迁移时变更
+const ConfigSupply = "supply"
+
+type TokenInfo struct {
+	Name     string `json:"name" validate:"required,max=64,charset=text"`   /*积分名称*/
+	Symbol   string `json:"symbol" validate:"required,max=16,charset=name"` /*积分符号*/
+	Decimals int    `json:"decimals" validate:"min=0,max=18"`               /*显示小数位数，账本积分为最小单位整数*/
+}
+
+// Init 未配置时使用的积分信息
+var defaultTokenInfo = TokenInfo{Name: "Token", Symbol: "TOKEN", Decimals: 0}
+
+func GetTokenInfo(stub shim.ChaincodeStubInterface) (*TokenInfo, error) {
+	info := defaultTokenInfo
+	configKey, err := GetConfigCompositeKey(stub, ConfigToken)
+	if err != nil {
+		return nil, err
+	}
+	infoAsBytes, err := GetState(stub, configKey)
+	if err != nil {
+		return nil, err
+	}
+	if infoAsBytes == nil {
+		return &info, nil
+	}
+	if err := json.Unmarshal(infoAsBytes, &info); err != nil {
+		return nil, CorruptedStateError(configKey)
+	}
+	return &info, nil
+}
+
+// 保存 Init 参数中的积分信息，参数不是 JSON 对象时视为旧版初始化参数，不做处理
+func InitTokenInfo(stub shim.ChaincodeStubInterface, args []string) error {
+	if len(args) != 1 || !strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		return nil
+	}
+	validator := RequestValidator{}
+	validator.validateParam("param0", args[0], reflect.TypeOf(TokenInfo{}), "")
+	if err := validator.error(); err != nil {
+		return err
+	}
+	info := TokenInfo{}
+	if err := json.Unmarshal([]byte(args[0]), &info); err != nil {
+		return InvalidArgumentError("invalid token info: %s", err.Error())
+	}
+
+	configKey, err := GetConfigCompositeKey(stub, ConfigToken)
+	if err != nil {
+		return err
+	}
+	infoAsBytes, _ := json.Marshal(info)
+	if err := PutState(stub, configKey, infoAsBytes); err != nil {
+		return err
+	}
+	fmt.Printf("init token - end %s \n", string(infoAsBytes))
+
+	events := NewEventBatch(stub)
+	events.add(EventConfigChanged, ConfigEventPayload{Name: ConfigToken, Value: string(infoAsBytes)})
+	return events.emit()
+}
+
+func GetTotalSupply(stub shim.ChaincodeStubInterface) (int64, error) {
+	configKey, err := GetConfigCompositeKey(stub, ConfigSupply)
+	if err != nil {
+		return 0, err
+	}
+	supplyAsBytes, err := GetState(stub, configKey)
+	if err != nil {
+		return 0, err
+	}
+	if supplyAsBytes == nil {
+		return countSupply(stub)
+	}
+	supply, err := strconv.ParseInt(string(supplyAsBytes), 10, 64)
+	if err != nil {
+		return 0, CorruptedStateError(configKey)
+	}
+	return supply, nil
+}
+
+// 增发为正数，销毁为负数
+func AddTotalSupply(stub shim.ChaincodeStubInterface, amount int64) error {
+	supply, err := GetTotalSupply(stub)
+	if err != nil {
+		return err
+	}
+	configKey, err := GetConfigCompositeKey(stub, ConfigSupply)
+	if err != nil {
+		return err
+	}
+	return PutState(stub, configKey, []byte(strconv.FormatInt(supply+amount, 10)))
+}
+
+// 计数启用前的发行总量：账户积分、组织钱包积分和锁定积分之和，透支账户的负数积分相抵
+func countSupply(stub shim.ChaincodeStubInterface) (int64, error) {
+	accounts, err := NewAccountRepository(stub).List()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, account := range accounts {
+		total += account.Token
+	}
+
+	resultIterator, err := stub.GetStateByPartialCompositeKey(OrgIndexName, []string{})
+	if err != nil {
+		return 0, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return 0, LedgerError("Next", err)
+		}
+		org := Organization{}
+		if err := UnmarshalRecord(SchemaOrg, item.Key, item.Value, &org); err != nil {
+			return 0, err
+		}
+		total += org.Token
+	}
+
+	_, locked, err := SumEscrow(stub, "")
+	if err != nil {
+		return 0, err
+	}
+	return total + locked, nil
+}
+
+type TokenContract struct {
+	contractapi.Contract
+}
+
+func (s *TokenContract) GetEvaluateTransactions() []string {
+	return []string{"Name", "Symbol", "Decimals", "TotalSupply", "BalanceOf", "Allowance"}
+}
+
+func (s *TokenContract) Name(ctx contractapi.TransactionContextInterface) (string, error) {
+	info, err := GetTokenInfo(ctx.GetStub())
+	if err != nil {
+		return "", err
+	}
+	return info.Name, nil
+}
+
+func (s *TokenContract) Symbol(ctx contractapi.TransactionContextInterface) (string, error) {
+	info, err := GetTokenInfo(ctx.GetStub())
+	if err != nil {
+		return "", err
+	}
+	return info.Symbol, nil
+}
+
+func (s *TokenContract) Decimals(ctx contractapi.TransactionContextInterface) (int, error) {
+	info, err := GetTokenInfo(ctx.GetStub())
+	if err != nil {
+		return 0, err
+	}
+	return info.Decimals, nil
+}
+
+// 发行总量，读取发行总量计数
+func (s *TokenContract) TotalSupply(ctx contractapi.TransactionContextInterface) (int64, error) {
+	return GetTotalSupply(ctx.GetStub())
+}
+
+func (s *TokenContract) BalanceOf(ctx contractapi.TransactionContextInterface, account string) (int64, error) {
+	accountInfo, err := NewAccountRepository(ctx.GetStub()).Get(account)
+	if err != nil {
+		return 0, err
+	}
+	return accountInfo.Token, nil
+}
+
+func (s *TokenContract) Transfer(ctx contractapi.TransactionContextInterface, from string, to string, value int64) (*AccountTokenResponse, error) {
+	return s.transfer(ctx.GetStub(), TokenTransferRequest{From: from, To: to, Amount: value})
+}
+
+// spender 使用 from 授权的代付额度转账
+func (s *TokenContract) TransferFrom(ctx contractapi.TransactionContextInterface, spender string, from string, to string, value int64) (*AccountTokenResponse, error) {
+	return s.transfer(ctx.GetStub(), TokenTransferRequest{From: from, To: to, Amount: value, Spender: spender})
+}
+
+// 须由 from 提交，TransferFrom 须由 spender 提交
+func (s *TokenContract) transfer(stub shim.ChaincodeStubInterface, request TokenTransferRequest) (*AccountTokenResponse, error) {
+
+	if err := checkTransferCreator(stub, request); err != nil {
+		return nil, err
+	}
+	events := NewEventBatch(stub)
+	result, err := submitTokenTransfer(stub, request, events)
+	if err != nil {
+		return nil, err
+	}
+	// 创建待审批提案时未转账
+	if result.Proposal == "" {
+		events.add(EventERC20Transfer, ERC20TransferEventPayload{From: request.From, To: request.To, Value: request.Amount})
+	}
+	if err = events.emit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 设置不限数据类型、不过期的代付额度，覆盖原额度，value 为 0 时撤销，须由 owner 提交
+func (s *TokenContract) Approve(ctx contractapi.TransactionContextInterface, owner string, spender string, value int64) error {
+
+	stub := ctx.GetStub()
+	events := NewEventBatch(stub)
+	request := AllowanceRequest{Owner: owner, Spender: spender, Type: AllowanceAnyType, Amount: value}
+	if _, err := approveSpender(stub, request, events); err != nil {
+		return err
+	}
+	events.add(EventERC20Approval, ERC20ApprovalEventPayload{Owner: owner, Spender: spender, Value: value})
+	return events.emit()
+}
+
+// 不限数据类型的可用代付额度，已过期时为 0
+func (s *TokenContract) Allowance(ctx contractapi.TransactionContextInterface, owner string, spender string) (int64, error) {
+
+	stub := ctx.GetStub()
+	allowance, err := FindAllowance(stub, owner, spender, AllowanceAnyType)
+	if err != nil || allowance == nil {
+		return 0, err
+	}
+	txTime, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return 0, err
+	}
+	return allowance.availableAt(txTime), nil
+}
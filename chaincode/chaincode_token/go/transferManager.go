@@ -3,10 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
 	"strconv"
-	"time"
 )
 
 /*
@@ -16,43 +16,101 @@ import (
  */
 
 type DataTransferRecord struct {
-	Hash  string `json:"hash"`  /*数据Hash*/
-	Price int    `json:"price"` /*实际交易价格*/
-	Time  int64  `json:"time"`  /*交易时间*/
-	Size  int    `json:"size"`  /*交易数据记录数*/
+	Hash    string `json:"hash"`                                          /*数据Hash*/
+	Price   int    `json:"price"`                                         /*实际交易价格*/
+	Time    int64  `json:"time"`                                          /*交易时间*/
+	Size    int    `json:"size"`                                          /*交易数据记录数*/
+	License string `json:"license,omitempty" metadata:"license,optional"` /*交易生成的许可证编号，许可证实现前的交易记录为空*/
+
+	SchemaVersion int `json:"schemaVersion"` /*账本记录版本*/
 }
 
+// 写入账本时使用当前记录版本
 func (d *DataTransferRecord) toBytes() []byte {
+	d.SchemaVersion = CurrentSchemaVersion(SchemaTransfer)
 	dataAsBytes, _ := json.Marshal(d)
 	return dataAsBytes
 }
 
-func GetTransferRecordCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, string) {
-	indexName := "transfer"
-	indexKey, err := stub.CreateCompositeKey(indexName, attributes)
-	if err != nil {
-		fmt.Printf("GetTransferRecordCompositeKey error: %s \n", err.Error())
-	}
-	return indexKey, indexName
+const TransferIndexName = "transfer"
+
+func GetTransferRecordCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, TransferIndexName, attributes)
+}
+
+const TransferTimeIndexName = "transferTime"
+
+func GetTransferTimeCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, TransferTimeIndexName, attributes)
+}
+
+const SaleIndexName = "sale"
+
+func GetSaleRecordCompositeKey(stub shim.ChaincodeStubInterface, attributes []string) (string, error) {
+	return CreateCompositeKey(stub, SaleIndexName, attributes)
 }
 
 func GetTransferRecord(stub shim.ChaincodeStubInterface, key string) (*DataTransferRecord, error) {
-	dataAsBytes, _ := stub.GetState(key)
+	dataAsBytes, err := GetState(stub, key)
+	if err != nil {
+		return nil, err
+	}
 	if dataAsBytes == nil {
-		return nil, fmt.Errorf("can't find transfer record by key %s", key)
+		attributes, err := SplitCompositeKey(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		return nil, NewError(CodeTransferNotFound, ErrorData{"buyer": attributes[0], "type": attributes[1], "owner": attributes[2], "title": attributes[3]})
 	}
 
 	var record DataTransferRecord
-	err := json.Unmarshal(dataAsBytes, &record)
-	if err != nil {
+	if err := UnmarshalRecord(SchemaTransfer, key, dataAsBytes, &record); err != nil {
 		return nil, err
 	}
 	return &record, nil
 }
 
 type TransferRequest struct {
-	Buyer string                `json:"buyer"`
-	Data  []DataEvidenceRequest `json:"data"`
+	Buyer   string                `json:"buyer" validate:"required,max=64,charset=name"`
+	Data    []DataEvidenceRequest `json:"data" validate:"required,max=100,unique=hash"`
+	Org     string                `json:"org,omitempty" metadata:"org,optional" validate:"max=64,charset=name"`          /*使用买方所属组织的钱包支付，可选*/
+	Spender string                `json:"spender,omitempty" metadata:"spender,optional" validate:"max=64,charset=name"`  /*代买方支付的账户，按买方授权额度扣减，可选*/
+	Offer   string                `json:"offer,omitempty" metadata:"offer,optional" validate:"max=128,charset=name"`     /*按已接受报价的价格购买，可选*/
+	Auction string                `json:"auction,omitempty" metadata:"auction,optional" validate:"max=128,charset=name"` /*按拍卖成交价购买，可选*/
+	Bounty  string                `json:"bounty,omitempty" metadata:"bounty,optional" validate:"max=128,charset=name"`   /*发放数据需求悬赏，可选*/
+}
+
+// 按约定价格成交的交易：已接受的报价、已确定成交方的拍卖、发放中的数据需求
+type TransferDeal interface {
+	// 交易请求只能包含约定的数据和买方
+	matches(request TransferRequest) error
+	// 成交单价，price 为标签当前价格
+	unitPrice(price *DataTitlePrice) (int, error)
+	// 支付前退回买方为该交易锁定的积分
+	release(buyer *Account, events *EventBatch)
+	// 成交后关闭
+	complete(stub shim.ChaincodeStubInterface, events *EventBatch) error
+}
+
+// 读取交易请求指定的报价、拍卖或数据需求，未指定时返回 nil
+func GetTransferDeal(stub shim.ChaincodeStubInterface, request TransferRequest) (TransferDeal, error) {
+	deals := 0
+	for _, id := range []string{request.Offer, request.Auction, request.Bounty} {
+		if id != "" {
+			deals++
+		}
+	}
+	switch {
+	case deals > 1:
+		return nil, InvalidArgumentError("offer, auction and bounty can't be combined")
+	case request.Offer != "":
+		return GetAcceptedOffer(stub, request.Offer)
+	case request.Auction != "":
+		return GetSettlingAuction(stub, request.Auction)
+	case request.Bounty != "":
+		return GetAwardingBounty(stub, request.Bounty)
+	}
+	return nil, nil
 }
 
 type TransferRecordResponse struct {
@@ -63,17 +121,55 @@ type TransferRecordResponse struct {
 	Record DataTransferRecord `json:"record"`
 }
 
+type SalesRecordRequest struct {
+	Owner  string `json:"owner" validate:"required,max=64,charset=name"`                             /*数据归属方*/
+	ByType bool   `json:"byType,omitempty" metadata:"byType,optional"`                               /*是否按数据类型过滤*/
+	Type   int    `json:"type,omitempty" metadata:"type,optional" validate:"min=0,max=65535"`        /*数据类型，ByType为true时有效*/
+	Title  string `json:"title,omitempty" metadata:"title,optional" validate:"max=128,charset=text"` /*数据标签名称，可选，需同时指定数据类型*/
+	From   int64  `json:"from,omitempty" metadata:"from,optional" validate:"min=0"`                  /*交易起始时间，可选*/
+	To     int64  `json:"to,omitempty" metadata:"to,optional" validate:"min=0"`                      /*交易截止时间，可选*/
+}
+
+func (s *SalesRecordRequest) getSaleRecordPartialCompositeKeyAttributes() []string {
+	attributes := []string{s.Owner}
+	if s.ByType {
+		attributes = append(attributes, strconv.Itoa(s.Type))
+		if s.Title != "" {
+			attributes = append(attributes, s.Title)
+		}
+	}
+	return attributes
+}
+
+func (s *SalesRecordRequest) inTimeRange(timeUnix int64) bool {
+	if s.From > 0 && timeUnix < s.From {
+		return false
+	}
+	if s.To > 0 && timeUnix > s.To {
+		return false
+	}
+	return true
+}
+
+type SalesRecordResponse struct {
+	Owner   string                   `json:"owner"`
+	Count   int                      `json:"count"`   /*售出数据记录数*/
+	Token   int64                    `json:"token"`   /*售出获得积分*/
+	Records []TransferRecordResponse `json:"records"` /*售出记录明细*/
+}
+
 type DownloadTitle struct {
-	Title  string `json:"title"`
-	Hash   string `json:"hash"`
-	Extend string `json:"extend,omitempty"` /*数据扩展信息，请求可以不填写*/
+	Title   string `json:"title" validate:"required,max=128,charset=text"`
+	Hash    string `json:"hash" validate:"required,max=128,charset=hex"`
+	Extend  string `json:"extend,omitempty" metadata:"extend,optional" validate:"max=4096"` /*数据扩展信息，请求可以不填写*/
+	License string `json:"license,omitempty" metadata:"license,optional" validate:"empty"`  /*买方持有的许可证编号，由合约返回*/
 }
 
 type TransferCheckRequest struct {
-	Buyer string          `json:"buyer"`
-	Type  int             `json:"type"`
-	Owner string          `json:"owner"`
-	Data  []DownloadTitle `json:"data"` /*待校验交易的数据*/
+	Buyer string          `json:"buyer" validate:"required,max=64,charset=name"`
+	Type  int             `json:"type" validate:"min=0,max=65535"`
+	Owner string          `json:"owner" validate:"required,max=64,charset=name"`
+	Data  []DownloadTitle `json:"data" validate:"required,max=100,unique=hash"` /*待校验交易的数据*/
 }
 
 func (t *TransferCheckRequest) getTransferRecordCompositeKeyAttributes(title string) []string {
@@ -97,152 +193,422 @@ type DataTransferEntity struct {
 	Core        DataEvidenceRequest `json:"core"` /*数据基础信息*/
 	Description *DataDescription    `json:"description"`
 	Price       int                 `json:"price"` /*实际交易价格*/
+	Terms       string              `json:"terms"` /*标签许可条款*/
 }
 
 type TransferContract struct {
+	contractapi.Contract
 }
 
-func (s *TransferContract) transferData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (s *TransferContract) GetEvaluateTransactions() []string {
+	return []string{"ShowTransferRecord", "ShowSalesRecord", "CheckTransferred", "ShowDataExtend"}
+}
 
-	if len(args) != 1 {
-		return shim.Error("[transferData] Incorrect number of arguments. Expecting 1")
-	}
+// 交易费用超过审批阈值时创建待审批提案，审批通过后执行
+func (s *TransferContract) TransferData(ctx contractapi.TransactionContextInterface, request TransferRequest) (*AccountTokenResponse, error) {
 
-	var request TransferRequest
-	if err := json.Unmarshal([]byte(args[0]), &request); err != nil {
-		return shim.Error("[transferData] Failed to parse request.")
+	stub := ctx.GetStub()
+	events := NewEventBatch(stub)
+	result, err := s.transferData(stub, request, nil, nil, events)
+	if err != nil {
+		return nil, err
 	}
+	if err = events.emit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+/*
+ * proposal 为空时按审批策略判断是否需要审批，否则为执行已审批的提案，费用不能超过审批积分
+ * deal 为约定价格的报价、拍卖或数据需求，为空时读取请求指定的交易，成交后关闭
+ */
+func (s *TransferContract) transferData(stub shim.ChaincodeStubInterface, request TransferRequest, proposal *Proposal, deal TransferDeal, events *EventBatch) (*AccountTokenResponse, error) {
 
-	fromAccount, err := GetAccount(stub, request.Buyer)
+	if request.Org != "" && request.Spender != "" {
+		return nil, InvalidArgumentError("org and spender can't be combined")
+	}
+	if deal == nil {
+		var err error
+		if deal, err = GetTransferDeal(stub, request); err != nil {
+			return nil, err
+		}
+	}
+	if deal != nil {
+		if err := deal.matches(request); err != nil {
+			return nil, err
+		}
+	}
+	fromAccount, err := NewAccountRepository(stub).Get(request.Buyer)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("transfer from account [%s] is not exist.", request.Buyer))
+		return nil, err
 	}
 	fmt.Printf("transferToken fromAccount - begin [%s %d] \n", fromAccount.Name, fromAccount.Token)
 
-	if address := GetCreatorAddress(stub); address != nil {
+	if address, err := GetCreatorAddress(stub); err == nil {
 		/*if fromAccount.Address != string(address) {
-			return shim.Error("Incorrect address of from account")
+			return nil, NewError(CodeAccountAddressError, ErrorData{"name": fromAccount.Name})
 		}*/
-	} else {
 		fmt.Printf("transfer data creator address is %s \n", string(address))
+	} else {
+		fmt.Printf("transfer data creator address error: %s \n", err.Error())
+	}
+	// 组织钱包支付须由成员本人提交，审批执行时已在提交提案时校验
+	if request.Org != "" && proposal == nil {
+		if err := fromAccount.checkCreator(stub); err != nil {
+			return nil, err
+		}
 	}
 
 	// 数据交易费用：标签价格 * 数据条数
 	var toAccountData = make(map[string]int64)
+	var typeCost = make(map[int]int64)
 	var validData = make(map[string]*DataTransferEntity)
 	for _, info := range request.Data {
 		dataDetail, err1 := GetDataDescription(stub, info.getDataCompositeKeyAttributes())
 		if err1 != nil {
-			return shim.Error(err1.Error())
+			return nil, err1
 		}
 
-		dataTitleKey, _ := GetDataTitleCompositeKey(stub, info.getDataTitleCompositeKeyAttributes())
+		dataTitleKey, err := GetDataTitleCompositeKey(stub, info.getDataTitleCompositeKeyAttributes())
+		if err != nil {
+			return nil, err
+		}
 		dataTitle, err := GetDataTitle(stub, dataTitleKey)
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, err
+		}
+
+		// 独占拍卖的数据只能由拍卖成交方购买
+		auctionID, err := GetDataAuction(stub, info)
+		if err != nil {
+			return nil, err
+		}
+		if auction, ok := deal.(*Auction); auctionID != "" && (!ok || auction.ID != auctionID) {
+			return nil, NewError(CodeDataExclusive, ErrorData{"hash": info.Hash, "auction": auctionID})
 		}
 
+		price := dataTitle.Price.Value
+		if deal != nil {
+			if price, err = deal.unitPrice(&dataTitle.Price); err != nil {
+				return nil, err
+			}
+		}
 		if _, ok := toAccountData[info.Owner]; !ok {
 			toAccountData[info.Owner] = 0
 		}
-		toAccountData[info.Owner] += int64(dataTitle.Price.Value * dataDetail.Size)
+		toAccountData[info.Owner] += int64(price * dataDetail.Size)
+		typeCost[info.Type] += int64(price * dataDetail.Size)
 		validData[info.Hash] = &DataTransferEntity{
 			Core:        info,
 			Description: dataDetail,
-			Price:       dataTitle.Price.Value,
+			Price:       price,
+			Terms:       dataTitle.Terms,
 		}
 	}
 
 	if len(toAccountData) == 0 || len(validData) == 0 {
-		return shim.Error("Transfer details or accounts are empty.")
+		return nil, NewError(CodeTransferEmpty, nil)
 	}
 
-	result, err := s.transferToken(stub, fromAccount, toAccountData)
-	if err != nil {
-		return shim.Error(err.Error())
+	var total int64
+	for _, amount := range toAccountData {
+		total += amount
+	}
+	if proposal != nil {
+		if total > proposal.Amount {
+			return nil, NewError(CodeProposalAmountChanged, ErrorData{"id": proposal.ID, "amount": proposal.Amount, "current": total})
+		}
 	} else {
-		s.createTransferRecord(stub, fromAccount.Name, validData)
+		policy, err := GetApprovalPolicy(stub)
+		if err != nil {
+			return nil, err
+		}
+		exceeded, err := policy.accumulate(stub, ProposalTransfer, fromAccount.Name, total)
+		if err != nil {
+			return nil, err
+		}
+		if exceeded {
+			return SubmitProposal(stub, policy, ProposalTransfer, fromAccount.Name, total, request, events)
+		}
+	}
+
+	if request.Spender != "" && request.Spender != fromAccount.Name {
+		if err := s.spendAllowance(stub, fromAccount.Name, request.Spender, typeCost, events); err != nil {
+			return nil, err
+		}
 	}
 
-	return shim.Success(result)
+	if deal != nil {
+		deal.release(fromAccount, events)
+	}
+	var result *AccountTokenResponse
+	if request.Org != "" {
+		result, err = s.transferFromOrg(stub, request.Org, fromAccount, toAccountData)
+	} else {
+		result, err = s.transferToken(stub, fromAccount, toAccountData)
+	}
+	if err != nil {
+		return nil, err
+	} else if err = s.createTransferRecord(stub, request, validData, events); err != nil {
+		return nil, err
+	}
+	if deal != nil {
+		if err := deal.complete(stub, events); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
 }
 
-func (s *TransferContract) transferToken(stub shim.ChaincodeStubInterface, from *Account, toAccounts map[string]int64) ([]byte, error) {
+// 按数据类型扣减买方授权给代付账户的额度
+func (s *TransferContract) spendAllowance(stub shim.ChaincodeStubInterface, buyer, spender string, typeCost map[int]int64, events *EventBatch) error {
 
+	spending, err := NewAllowanceSpending(stub, buyer, spender)
+	if err != nil {
+		return err
+	}
+	var types []int
+	for dataType := range typeCost {
+		types = append(types, dataType)
+	}
+	sort.Ints(types)
+	for _, dataType := range types {
+		if err := spending.spend(dataType, typeCost[dataType]); err != nil {
+			return err
+		}
+	}
+	return spending.save(events)
+}
+
+func (s *TransferContract) transferToken(stub shim.ChaincodeStubInterface, from *Account, toAccounts map[string]int64) (*AccountTokenResponse, error) {
+
+	repository := NewAccountRepository(stub)
 	for _to, amount := range toAccounts {
-		toAccount, err := GetAccount(stub, _to)
+		toAccount, err := repository.Get(_to)
 		if err != nil {
 			fmt.Printf("failed to get account %s \n", _to)
 			return nil, err
 		}
-		msg, result := from.transfer(toAccount, amount)
-		if !result {
-			fmt.Printf("failed to transfer token, message: %s \n", msg)
-			return nil, fmt.Errorf("%s", msg)
+		if err := from.transfer(toAccount, amount); err != nil {
+			fmt.Printf("failed to transfer token, message: %s \n", err.Error())
+			return nil, err
 		}
 
-		toAccountKey, _ := GetAccountCompositeKey(stub, toAccount.Name)
-		err = stub.PutState(toAccountKey, toAccount.toBytes())
-		if err != nil {
+		if err = repository.Save(toAccount); err != nil {
 			fmt.Printf("failed to transfer token to %s, message: %s \n", toAccount.Name, err.Error())
 			return nil, err
 		}
 		fmt.Printf("transferData to account [%s %d] \n", toAccount.Name, toAccount.Token)
 	}
 
-	fromAccountKey, _ := GetAccountCompositeKey(stub, from.Name)
-	err := stub.PutState(fromAccountKey, from.toBytes())
-	if err != nil {
+	if err := repository.Save(from); err != nil {
 		fmt.Printf("failed to put state to account %s \n", from.Name)
 		return nil, err
 	}
 	fmt.Printf("transferToken fromAccount - end [%s, %d] \n", from.Name, from.Token)
 
-	retData := AccountTokenResponse{Name: from.Name, Token: from.Token}
-	retDataAsBytes := retData.toBytes()
+	return &AccountTokenResponse{Name: from.Name, Token: from.Token}, nil
+}
+
+// 买方使用所属组织钱包支付，计入成员额度，返回组织钱包积分
+func (s *TransferContract) transferFromOrg(stub shim.ChaincodeStubInterface, orgName string, buyer *Account, toAccounts map[string]int64) (*AccountTokenResponse, error) {
 
-	return retDataAsBytes, nil
+	if err := buyer.checkActive(); err != nil {
+		return nil, err
+	}
+	org, err := GetOrganization(stub, orgName)
+	if err != nil {
+		return nil, err
+	}
+	member, err := GetOrgMember(stub, org.Name, buyer.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, amount := range toAccounts {
+		total += amount
+	}
+	if err := member.spend(total); err != nil {
+		return nil, err
+	}
+	if err := org.pay(total); err != nil {
+		return nil, err
+	}
+
+	repository := NewAccountRepository(stub)
+	for _to, amount := range toAccounts {
+		toAccount, err := repository.Get(_to)
+		if err != nil {
+			return nil, err
+		}
+		if err := toAccount.checkActive(); err != nil {
+			return nil, err
+		}
+		toAccount.Token += amount
+		if err := repository.Save(toAccount); err != nil {
+			return nil, err
+		}
+		fmt.Printf("transferData to account [%s %d] \n", toAccount.Name, toAccount.Token)
+	}
+
+	if err := PutOrganization(stub, org); err != nil {
+		return nil, err
+	}
+	if err := PutOrgMember(stub, member); err != nil {
+		return nil, err
+	}
+	fmt.Printf("transferToken org - end [%s, %d] member %s spent %d \n", org.Name, org.Token, member.Account, member.Spent)
+
+	return &AccountTokenResponse{Name: org.Name, Token: org.Token}, nil
 }
 
-func (s *TransferContract) createTransferRecord(stub shim.ChaincodeStubInterface, from string, validData map[string]*DataTransferEntity) {
+// org 不为空时交易由组织钱包支付，同时写入组织交易索引
+func (s *TransferContract) createTransferRecord(stub shim.ChaincodeStubInterface, request TransferRequest, validData map[string]*DataTransferEntity, events *EventBatch) error {
+
+	from, org, spender := request.Buyer, request.Org, request.Spender
+	if spender == from {
+		spender = ""
+	}
 
-	timeUnix := time.Now().Unix()
-	for hash, data := range validData {
-		transferKey, _ := GetTransferRecordCompositeKey(stub, data.Core.getDataTransferCompositeKeyAttributes(from))
+	timeUnix, err := GetTxTimeUnix(stub)
+	if err != nil {
+		return err
+	}
+	// 按数据Hash排序，保证各背书节点生成的事件内容一致
+	hashList := make([]string, 0, len(validData))
+	for hash := range validData {
+		hashList = append(hashList, hash)
+	}
+	sort.Strings(hashList)
+	// 同一交易内每个归属方的信誉只写入一次，先按归属方累计
+	ownerSales := make(map[string]*Reputation)
+	for _, hash := range hashList {
+		data := validData[hash]
+		license, err := MintLicense(stub, from, data, timeUnix, events)
+		if err != nil {
+			return err
+		}
 		record := DataTransferRecord{
-			Hash:  hash,
-			Price: data.Price,
-			Time:  timeUnix,
-			Size:  data.Description.Size,
+			Hash:    hash,
+			Price:   data.Price,
+			Time:    timeUnix,
+			Size:    data.Description.Size,
+			License: license.ID,
+		}
+		transferKey, err := GetTransferRecordCompositeKey(stub, data.Core.getDataTransferCompositeKeyAttributes(from))
+		if err != nil {
+			return err
+		}
+		if err := PutState(stub, transferKey, record.toBytes()); err != nil {
+			return err
 		}
-		if err := stub.PutState(transferKey, record.toBytes()); err != nil {
-			fmt.Printf("Failed to save transfer record, key [%s], message [%s] \n", transferKey, err.Error())
+
+		// 交易时间索引
+		timeKey, err := GetTransferTimeCompositeKey(stub, data.Core.getDataTransferTimeCompositeKeyAttributes(from, timeUnix))
+		if err != nil {
+			return err
+		}
+		if err := PutState(stub, timeKey, record.toBytes()); err != nil {
+			return err
+		}
+
+		// 数据归属方销售索引
+		saleKey, err := GetSaleRecordCompositeKey(stub, data.Core.getDataSaleCompositeKeyAttributes(from))
+		if err != nil {
+			return err
+		}
+		if err := PutState(stub, saleKey, record.toBytes()); err != nil {
+			return err
+		}
+
+		// 组织钱包交易索引
+		if org != "" {
+			orgKey, err := GetOrgPurchaseCompositeKey(stub, []string{org, FormatTimeUnix(timeUnix), from, strconv.Itoa(data.Core.Type), data.Core.Owner, data.Core.Title})
+			if err != nil {
+				return err
+			}
+			if err := PutState(stub, orgKey, record.toBytes()); err != nil {
+				return err
+			}
+		}
+
+		events.add(EventDataPurchased, PurchaseEventPayload{
+			Buyer:   from,
+			Type:    data.Core.Type,
+			Owner:   data.Core.Owner,
+			Title:   data.Core.Title,
+			Hash:    hash,
+			Price:   record.Price,
+			Size:    record.Size,
+			Time:    record.Time,
+			Org:     org,
+			Spender: spender,
+			License: license.ID,
+		})
+
+		sales, ok := ownerSales[data.Core.Owner]
+		if !ok {
+			sales = &Reputation{}
+			ownerSales[data.Core.Owner] = sales
+		}
+		sales.Sales++
+		sales.Volume += int64(record.Price * record.Size)
+		if request.Bounty != "" {
+			sales.Bounties++
 		}
 	}
+
+	owners := make([]string, 0, len(ownerSales))
+	for owner := range ownerSales {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		reputation, err := GetReputation(stub, owner)
+		if err != nil {
+			return err
+		}
+		reputation.Sales += ownerSales[owner].Sales
+		reputation.Volume += ownerSales[owner].Volume
+		reputation.Bounties += ownerSales[owner].Bounties
+		if err := PutReputation(stub, reputation, events); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *TransferContract) showTransferRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// from、to 均为 0 时查询买方全部交易记录，否则按交易时间索引查询，to 为 0 时不限制截止时间
+func (s *TransferContract) ShowTransferRecord(ctx contractapi.TransactionContextInterface, buyer string, dataType int, from int64, to int64) ([]TransferRecordResponse, error) {
 
-	// args: [buyer, type]
-	if len(args) != 2 {
-		return shim.Error("[showTransferRecord] Incorrect number of arguments. Expecting 2")
+	stub := ctx.GetStub()
+	if from != 0 || to != 0 {
+		return s.showTransferRecordByTime(stub, buyer, dataType, from, to)
 	}
 
-	dataType, err := strconv.Atoi(args[1])
+	retDataList := []TransferRecordResponse{}
+	transferAttributes := []string{buyer, strconv.Itoa(dataType)}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(TransferIndexName, transferAttributes)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("[showTitles] Failed to parse data type %s", args[1]))
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
 	}
-
-	var retDataList []TransferRecordResponse
-	_, indexName := GetTransferRecordCompositeKey(stub, args)
-	resultIterator, _ := stub.GetStateByPartialCompositeKey(indexName, args)
 	defer resultIterator.Close()
 	for resultIterator.HasNext() {
-		item, _ := resultIterator.Next()
-		_, attributes, _ := stub.SplitCompositeKey(item.Key)
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		attributes, err := SplitCompositeKey(stub, item.Key)
+		if err != nil {
+			return nil, err
+		}
 
 		var record DataTransferRecord
-		_ = json.Unmarshal(item.Value, &record)
+		if err := UnmarshalRecord(SchemaTransfer, item.Key, item.Value, &record); err != nil {
+			return nil, err
+		}
 
 		retDataList = append(retDataList, TransferRecordResponse{
 			Buyer:  attributes[0],
@@ -252,47 +618,197 @@ func (s *TransferContract) showTransferRecord(stub shim.ChaincodeStubInterface,
 			Record: record,
 		})
 	}
-	retDataListAsBytes, _ := json.Marshal(retDataList)
 
-	return shim.Success(retDataListAsBytes)
+	return retDataList, nil
 }
 
-func (s *TransferContract) checkTransferred(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// 按交易时间索引查询，结果按交易时间升序
+func (s *TransferContract) showTransferRecordByTime(stub shim.ChaincodeStubInterface, buyer string, dataType int, from, to int64) ([]TransferRecordResponse, error) {
 
-	if len(args) != 1 {
-		return shim.Error("[checkTransferred] Incorrect number of arguments. Expecting 1")
+	retDataList := []TransferRecordResponse{}
+	timeAttributes := []string{buyer}
+	resultIterator, err := stub.GetStateByPartialCompositeKey(TransferTimeIndexName, timeAttributes)
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
 	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		attributes, err := SplitCompositeKey(stub, item.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		timeUnix, err := strconv.ParseInt(attributes[1], 10, 64)
+		if err != nil {
+			return nil, CorruptedStateError(item.Key)
+		}
+		if timeUnix < from {
+			continue
+		}
+		if to > 0 && timeUnix > to {
+			break
+		}
+		if attributes[2] != strconv.Itoa(dataType) {
+			continue
+		}
+
+		var record DataTransferRecord
+		if err := UnmarshalRecord(SchemaTransfer, item.Key, item.Value, &record); err != nil {
+			return nil, err
+		}
 
-	var request TransferCheckRequest
-	if err := json.Unmarshal([]byte(args[0]), &request); err != nil {
-		return shim.Error("[checkTransferred] Failed to parse request.")
+		retDataList = append(retDataList, TransferRecordResponse{
+			Buyer:  attributes[0],
+			Type:   dataType,
+			Owner:  attributes[3],
+			Title:  attributes[4],
+			Record: record,
+		})
+	}
+	return retDataList, nil
+}
+
+func (s *TransferContract) ShowSalesRecord(ctx contractapi.TransactionContextInterface, request SalesRecordRequest) (*SalesRecordResponse, error) {
+
+	if request.Owner == "" {
+		return nil, InvalidArgumentError("owner of data is required")
+	}
+
+	stub := ctx.GetStub()
+	retData := SalesRecordResponse{Owner: request.Owner, Records: []TransferRecordResponse{}}
+	saleAttributes := request.getSaleRecordPartialCompositeKeyAttributes()
+	resultIterator, err := stub.GetStateByPartialCompositeKey(SaleIndexName, saleAttributes)
+	if err != nil {
+		return nil, LedgerError("GetStateByPartialCompositeKey", err)
+	}
+	defer resultIterator.Close()
+	for resultIterator.HasNext() {
+		item, err := resultIterator.Next()
+		if err != nil {
+			return nil, LedgerError("Next", err)
+		}
+		attributes, err := SplitCompositeKey(stub, item.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var record DataTransferRecord
+		if err := UnmarshalRecord(SchemaTransfer, item.Key, item.Value, &record); err != nil {
+			return nil, err
+		}
+		if !request.inTimeRange(record.Time) {
+			continue
+		}
+
+		dataType, err := strconv.Atoi(attributes[1])
+		if err != nil {
+			return nil, CorruptedStateError(item.Key)
+		}
+		retData.Records = append(retData.Records, TransferRecordResponse{
+			Buyer:  attributes[3],
+			Type:   dataType,
+			Owner:  attributes[0],
+			Title:  attributes[2],
+			Record: record,
+		})
+		retData.Count += record.Size
+		retData.Token += int64(record.Price * record.Size)
+	}
+	return &retData, nil
+}
+
+/*
+ * 校验买方已购买数据，返回数据存证键、描述信息和买方持有的许可证编号：
+ * 买方持有该数据的许可证即可下载；没有许可证时，只接受许可证实现前的交易记录
+ */
+func (s *TransferContract) verifyTransferred(stub shim.ChaincodeStubInterface, request *TransferCheckRequest, data DownloadTitle) (string, *DataDescription, string, error) {
+	dataAttributes := request.getDataCompositeKeyAttributes(data.Title, data.Hash)
+	dataDetail, err := GetDataDescription(stub, dataAttributes)
+	if err != nil {
+		fmt.Printf("The data isn't exist. message: %s", err.Error())
+		return "", nil, "", err
 	}
+	dataKey, err := GetDataCompositeKey(stub, dataAttributes)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	licenseID, err := FindHeldLicense(stub, request.Buyer, request.Type, request.Owner, data.Title, data.Hash)
+	if err != nil {
+		return "", nil, "", err
+	}
+	if licenseID != "" {
+		return dataKey, dataDetail, licenseID, nil
+	}
+
+	transferAttributes := request.getTransferRecordCompositeKeyAttributes(data.Title)
+	transferRecordKey, err := GetTransferRecordCompositeKey(stub, transferAttributes)
+	if err != nil {
+		return "", nil, "", err
+	}
+	record, err := GetTransferRecord(stub, transferRecordKey)
+	if err != nil {
+		return "", nil, "", err
+	}
+	if record.License != "" {
+		return "", nil, "", NewError(CodeLicenseNotHeld, ErrorData{"buyer": request.Buyer, "hash": data.Hash})
+	}
+	if record.Hash != data.Hash {
+		return "", nil, "", NewError(CodeTransferHashMismatch, ErrorData{"hash": data.Hash})
+	}
+	return dataKey, dataDetail, "", nil
+}
+
+func (s *TransferContract) CheckTransferred(ctx contractapi.TransactionContextInterface, request TransferCheckRequest) (*TransferCheckResponse, error) {
 
-	retData := TransferCheckResponse{Type: request.Type, Owner: request.Owner}
+	stub := ctx.GetStub()
+	retData := TransferCheckResponse{Type: request.Type, Owner: request.Owner, Data: []DownloadTitle{}}
 	for _, data := range request.Data {
-		dataAttributes := request.getDataCompositeKeyAttributes(data.Title, data.Hash)
-		dataDetail, err := GetDataDescription(stub, dataAttributes)
+		dataKey, dataDetail, licenseID, err := s.verifyTransferred(stub, &request, data)
 		if err != nil {
-			fmt.Printf("The data isn't exist. message: %s", err.Error())
-			return shim.Error(err.Error())
+			return nil, err
 		}
 
-		transferAttributes := request.getTransferRecordCompositeKeyAttributes(data.Title)
-		transferRecordKey, _ := GetTransferRecordCompositeKey(stub, transferAttributes)
-		if record, err := GetTransferRecord(stub, transferRecordKey); err == nil {
-			if record.Hash != data.Hash {
-				return shim.Error(fmt.Sprintf("Invalid hash [%s] of transfer record.", record.Hash))
-			}
-			retData.Data = append(retData.Data, DownloadTitle{
-				Title:  data.Title,
-				Hash:   data.Title,
-				Extend: dataDetail.Extend,
-			})
-		} else {
-			return shim.Error(err.Error())
+		// 私有扩展信息仅在归属方组织节点可读，其他节点返回空
+		extend, err := GetDataPrivateExtend(stub, dataKey, dataDetail)
+		if err != nil {
+			fmt.Printf("checkTransferred skip private extend: %s \n", err.Error())
 		}
+		retData.Data = append(retData.Data, DownloadTitle{
+			Title:   data.Title,
+			Hash:    data.Hash,
+			Extend:  extend,
+			License: licenseID,
+		})
 	}
-	retDataAsBytes, _ := json.Marshal(retData)
+	return &retData, nil
+}
+
+// 买方购买成功后获取数据私有扩展信息，需在数据归属方组织的节点上查询
+func (s *TransferContract) ShowDataExtend(ctx contractapi.TransactionContextInterface, request TransferCheckRequest) (*TransferCheckResponse, error) {
+
+	stub := ctx.GetStub()
+	retData := TransferCheckResponse{Type: request.Type, Owner: request.Owner, Data: []DownloadTitle{}}
+	for _, data := range request.Data {
+		dataKey, dataDetail, licenseID, err := s.verifyTransferred(stub, &request, data)
+		if err != nil {
+			return nil, err
+		}
 
-	return shim.Success(retDataAsBytes)
+		extend, err := GetDataPrivateExtend(stub, dataKey, dataDetail)
+		if err != nil {
+			return nil, err
+		}
+		retData.Data = append(retData.Data, DownloadTitle{
+			Title:   data.Title,
+			Hash:    data.Hash,
+			Extend:  extend,
+			License: licenseID,
+		})
+	}
+	return &retData, nil
 }
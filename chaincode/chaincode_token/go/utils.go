@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"reflect"
 	"strings"
@@ -70,27 +71,155 @@ func Checksum(data []byte) string {
 	return hash
 }
 
-func GetCreatorAddress(stub shim.ChaincodeStubInterface) []byte {
-	creator, _ := stub.GetCreator()
+func GetCreatorAddress(stub shim.ChaincodeStubInterface) ([]byte, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return nil, NewError(CodeIdentityError, ErrorData{"cause": err.Error()})
+	}
 	creatorCertPem := string(creator)
 	begin := strings.Index(creatorCertPem, BeginCert)
-	end := strings.Index(creatorCertPem, EndCert) + len(EndCert)
-	certPem := creatorCertPem[begin:end]
-
-	if pemBlock, _ := pem.Decode([]byte(certPem)); pemBlock != nil {
-		if x509Cert, err := x509.ParseCertificate(pemBlock.Bytes); err == nil {
-			publicKeyBytes, _ := x509.MarshalPKIXPublicKey(x509Cert.PublicKey)
-			hashUtil := DefaultHashUtil()
-			return []byte(hashUtil.checksum(publicKeyBytes))
-		} else {
-			fmt.Printf("parse certificate error.\n")
+	end := strings.Index(creatorCertPem, EndCert)
+	if begin < 0 || end < begin {
+		return nil, NewError(CodeIdentityError, ErrorData{"cause": "creator certificate not found"})
+	}
+	certPem := creatorCertPem[begin : end+len(EndCert)]
+
+	pemBlock, _ := pem.Decode([]byte(certPem))
+	if pemBlock == nil {
+		return nil, NewError(CodeIdentityError, ErrorData{"cause": "decode cert error"})
+	}
+	x509Cert, err := x509.ParseCertificate(pemBlock.Bytes)
+	if err != nil {
+		return nil, NewError(CodeIdentityError, ErrorData{"cause": err.Error()})
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(x509Cert.PublicKey)
+	if err != nil {
+		return nil, NewError(CodeIdentityError, ErrorData{"cause": err.Error()})
+	}
+	hashUtil := DefaultHashUtil()
+	return []byte(hashUtil.checksum(publicKeyBytes)), nil
+}
+
+// 生成组合键，属性包含非法字符时返回参数错误
+func CreateCompositeKey(stub shim.ChaincodeStubInterface, indexName string, attributes []string) (string, error) {
+	indexKey, err := stub.CreateCompositeKey(indexName, attributes)
+	if err != nil {
+		return "", InvalidArgumentError("invalid attributes of %s key: %s", indexName, err.Error())
+	}
+	return indexKey, nil
+}
+
+// 拆分组合键，失败时视为账本数据损坏
+func SplitCompositeKey(stub shim.ChaincodeStubInterface, key string) ([]string, error) {
+	_, attributes, err := stub.SplitCompositeKey(key)
+	if err != nil {
+		return nil, CorruptedStateError(key)
+	}
+	return attributes, nil
+}
+
+// 读取账本状态，shim 错误转换为账本错误码
+func GetState(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+	valueAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, LedgerError("GetState", err)
+	}
+	return valueAsBytes, nil
+}
+
+func PutState(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+	if err := stub.PutState(key, value); err != nil {
+		return LedgerError("PutState", err)
+	}
+	return nil
+}
+
+// 解析PEM格式公钥(PKIX)，支持RSA、ECDSA
+func ParsePublicKeyPem(publicKeyPem string) (interface{}, error) {
+	pemBlock, _ := pem.Decode([]byte(publicKeyPem))
+	if pemBlock == nil {
+		return nil, NewError(CodeInvalidEncryptKey, ErrorData{"cause": "decode public key pem error"})
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(pemBlock.Bytes)
+	if err != nil {
+		return nil, NewError(CodeInvalidEncryptKey, ErrorData{"cause": err.Error()})
+	}
+	return publicKey, nil
+}
+
+// 公钥指纹，用于确认数据密钥使用的加密公钥，按 DER 字节计算，不受 PEM 格式空白影响
+func KeyFingerprint(publicKeyPem string) string {
+	pemBlock, _ := pem.Decode([]byte(publicKeyPem))
+	if pemBlock == nil {
+		return ""
+	}
+	hashUtil := HashUtil{algor: SHA256}
+	return hashUtil.checksum(pemBlock.Bytes)
+}
+
+// 获取交易提交方所属组织的 MSP ID
+func GetCreatorMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", NewError(CodeIdentityError, ErrorData{"cause": err.Error()})
+	}
+	return mspID, nil
+}
+
+// 获取交易提交方身份标识：MSP ID/证书 CN，用于审批人配置
+func GetCreatorIdentity(stub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := GetCreatorMSPID(stub)
+	if err != nil {
+		return "", err
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return "", NewError(CodeIdentityError, ErrorData{"cause": err.Error()})
+	}
+	return mspID + "/" + cert.Subject.CommonName, nil
+}
+
+// 证书 OU 为 admin 或证书属性 admin=true 的身份为管理员
+const AdminRole = "admin"
+
+// 校验交易提交方为管理员
+func RequireAdmin(stub shim.ChaincodeStubInterface) error {
+	isAdmin, err := cid.HasOUValue(stub, AdminRole)
+	if err != nil {
+		return NewError(CodeIdentityError, ErrorData{"cause": err.Error()})
+	}
+	if !isAdmin {
+		value, found, err := cid.GetAttributeValue(stub, AdminRole)
+		if err != nil {
+			return NewError(CodeIdentityError, ErrorData{"cause": err.Error()})
 		}
-	} else {
-		fmt.Printf("decode cert error.\n")
+		isAdmin = found && value == "true"
+	}
+	if !isAdmin {
+		return NewError(CodePermissionDenied, ErrorData{"role": AdminRole})
 	}
 	return nil
 }
 
+// 组织隐式私有数据集合名称，仅该组织节点保存集合数据
+func GetOrgCollectionName(mspID string) string {
+	return "_implicit_org_" + mspID
+}
+
+// 获取交易时间(秒)，取自交易头，保证各背书节点结果一致
+func GetTxTimeUnix(stub shim.ChaincodeStubInterface) (int64, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, LedgerError("GetTxTimestamp", err)
+	}
+	return txTimestamp.GetSeconds(), nil
+}
+
+// 时间格式化为定长字符串，用于组合键按时间排序
+func FormatTimeUnix(timeUnix int64) string {
+	return fmt.Sprintf("%019d", timeUnix)
+}
+
 // 集合去除重复数据
 func Duplicate(a interface{}) (ret []interface{}) {
 	va := reflect.ValueOf(a)
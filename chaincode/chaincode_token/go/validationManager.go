@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+ * 请求参数校验实现：
+ * 1. 在 contractapi 转换参数之前校验原始参数，一次返回全部字段错误
+ * 2. JSON 参数按结构体字段的 validate 标签校验，拒绝未知字段和类型不符的字段
+ * 3. 位置参数按 paramRules 校验，未声明规则的字符串参数按账户名称校验
+ * 4. 所有字符串不允许包含组合键分隔字符 U+0000、U+10FFFF
+ *
+ * validate 标签规则，逗号分隔：
+ *   required      字符串不能为空，数组不能为空
+ *   min=N,max=N   数值取值范围；字符串为字符长度，数组为元素个数
+ *   charset=X     字符集：name(字母、数字、_-.@)、text(可打印字符)、hex、base64
+ *   empty         请求中不允许填写，由合约生成；布尔字段只允许 false，对象字段不允许出现
+ *   unique=X      数组元素按字段 X 去重，X 为 . 时按元素本身去重
+ * item 标签为字符串数组元素的校验规则
+ */
+
+// 字段校验失败原因
+const (
+	ReasonInvalidJSON  = "invalid_json"
+	ReasonUnknownField = "unknown_field"
+	ReasonInvalidType  = "invalid_type"
+	ReasonRequired     = "required"
+	ReasonTooLong      = "too_long"
+	ReasonOutOfRange   = "out_of_range"
+	ReasonInvalidChars = "invalid_chars"
+	ReasonNotAllowed   = "not_allowed"
+	ReasonDuplicate    = "duplicate"
+)
+
+const nameRule = "required,max=64,charset=name"
+const dataTypeRule = "min=0,max=65535"
+const proposalIDRule = "required,max=128,charset=name"
+const licenseIDRule = "required,max=64,charset=hex"
+const offerIDRule = "required,max=128,charset=name"
+const auctionIDRule = "required,max=128,charset=name"
+const bountyIDRule = "required,max=128,charset=name"
+const ticketIDRule = "required,max=128,charset=name"
+
+// 位置参数校验规则，按参数顺序声明，空字符串表示只校验类型
+var paramRules = map[string][]string{
+	"AccountContract:ShowAccount":         {"max=100"},
+	"AccountContract:MintToken":           {nameRule, "min=-1000000000000,max=1000000000000"},
+	"AccountContract:SetEncryptKey":       {nameRule, "required,max=4096"},
+	"AccountContract:ChangeSecret":        {nameRule, "required,max=128"},
+	"AccountContract:CloseAccount":        {nameRule, "max=64,charset=name"},
+	"DataContract:ShowTitles":             {dataTypeRule, nameRule},
+	"DataContract:ShowNameOfTitles":       {dataTypeRule},
+	"TransferContract:ShowTransferRecord": {nameRule, dataTypeRule, "min=0", "min=0"},
+	"OrgContract:AddOrgMember":            {nameRule, nameRule, "min=0,max=1000000000000"},
+	"OrgContract:SetMemberLimit":          {nameRule, nameRule, "min=0,max=1000000000000"},
+	"OrgContract:FundOrg":                 {nameRule, nameRule, "min=-1000000000000,max=1000000000000"},
+	"OrgContract:ShowOrgPurchases":        {nameRule, "min=0", "min=0"},
+	"TokenContract:Transfer":              {nameRule, nameRule, "min=1,max=1000000000000"},
+	"TokenContract:TransferFrom":          {nameRule, nameRule, nameRule, "min=1,max=1000000000000"},
+	"TokenContract:Approve":               {nameRule, nameRule, "min=0,max=1000000000000"},
+	"AllowanceContract:ShowAllowances":    {nameRule, "max=64,charset=name"},
+	"ProposalContract:ApproveProposal":    {proposalIDRule},
+	"ProposalContract:CancelProposal":     {proposalIDRule},
+	"ProposalContract:ShowProposal":       {proposalIDRule},
+	"LicenseContract:ShowLicense":         {licenseIDRule},
+	"LicenseContract:OwnerOf":             {licenseIDRule},
+	"LicenseContract:UnlistLicense":       {licenseIDRule, nameRule},
+	"LicenseContract:ShowLicenseListings": {dataTypeRule, nameRule, "max=128,charset=text"},
+	"OfferContract:AcceptOffer":           {offerIDRule, nameRule},
+	"OfferContract:RejectOffer":           {offerIDRule, nameRule},
+	"OfferContract:ShowOffer":             {offerIDRule},
+	"OfferContract:ShowTitleOffers":       {dataTypeRule, nameRule, "max=128,charset=text"},
+	"AuctionContract:SettleAuction":       {auctionIDRule},
+	"AuctionContract:CancelAuction":       {auctionIDRule, nameRule},
+	"AuctionContract:ShowAuction":         {auctionIDRule},
+	"AuctionContract:ShowAuctions":        {dataTypeRule, nameRule, "max=128,charset=text"},
+	"AuctionContract:ShowAuctionBids":     {auctionIDRule},
+	"BountyContract:CloseBounty":          {bountyIDRule, nameRule},
+	"BountyContract:ShowBounty":           {bountyIDRule},
+	"BountyContract:ShowBounties":         {dataTypeRule},
+	"BountyContract:ShowBountyResponses":  {bountyIDRule},
+	"RatingContract:ShowTitleRatings":     {dataTypeRule, nameRule, "required,max=128,charset=text"},
+	"TicketContract:VerifyTicket":         {ticketIDRule, "required,max=64,charset=hex"},
+	"ConfigContract:SetLanguage":          {"required,max=8,charset=name"},
+	"ConfigContract:Migrate":              {"required,max=16,charset=name", "min=1,max=1000"},
+}
+
+type FieldError struct {
+	Field  string `json:"field"`  /*字段路径，如 param0.data[1].hash*/
+	Reason string `json:"reason"` /*失败原因*/
+}
+
+type fieldRules struct {
+	required bool
+	empty    bool
+	min      *int64
+	max      *int64
+	charset  string
+	unique   string
+}
+
+func parseFieldRules(tag string) fieldRules {
+	rules := fieldRules{}
+	for _, rule := range strings.Split(tag, ",") {
+		name, value := rule, ""
+		if index := strings.Index(rule, "="); index >= 0 {
+			name, value = rule[:index], rule[index+1:]
+		}
+		switch name {
+		case "required":
+			rules.required = true
+		case "empty":
+			rules.empty = true
+		case "min", "max":
+			if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+				if name == "min" {
+					rules.min = &number
+				} else {
+					rules.max = &number
+				}
+			}
+		case "charset":
+			rules.charset = value
+		case "unique":
+			rules.unique = value
+		}
+	}
+	return rules
+}
+
+type RequestValidator struct {
+	errors []FieldError
+}
+
+func (v *RequestValidator) add(field, reason string) {
+	v.errors = append(v.errors, FieldError{Field: field, Reason: reason})
+}
+
+// 存在字段错误时返回校验错误
+func (v *RequestValidator) error() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return NewError(CodeValidationFailed, ErrorData{"count": len(v.errors), "fields": v.errors})
+}
+
+func validCharset(value, charset string) bool {
+	for _, r := range value {
+		switch charset {
+		case "name":
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.@", r) {
+				return false
+			}
+		case "text":
+			if !unicode.IsPrint(r) {
+				return false
+			}
+		case "hex":
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		case "base64":
+			if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (v *RequestValidator) validateString(path, value string, rules fieldRules) {
+	if !utf8.ValidString(value) || strings.ContainsRune(value, 0) || strings.ContainsRune(value, utf8.MaxRune) {
+		v.add(path, ReasonInvalidChars)
+		return
+	}
+	length := int64(utf8.RuneCountInString(value))
+	switch {
+	case rules.empty && length > 0:
+		v.add(path, ReasonNotAllowed)
+	case rules.required && length == 0:
+		v.add(path, ReasonRequired)
+	case rules.max != nil && length > *rules.max:
+		v.add(path, ReasonTooLong)
+	case rules.charset != "" && !validCharset(value, rules.charset):
+		v.add(path, ReasonInvalidChars)
+	}
+}
+
+func (v *RequestValidator) validateInt(path string, value int64, bitSize int, rules fieldRules) {
+	if bitSize < 64 && (value > 1<<uint(bitSize-1)-1 || value < -1<<uint(bitSize-1)) {
+		v.add(path, ReasonOutOfRange)
+		return
+	}
+	if (rules.min != nil && value < *rules.min) || (rules.max != nil && value > *rules.max) {
+		v.add(path, ReasonOutOfRange)
+	}
+}
+
+// 按 Go 类型校验 JSON 解码后的值
+func (v *RequestValidator) validateValue(path string, value interface{}, valueType reflect.Type, rules fieldRules, itemTag string) {
+	switch valueType.Kind() {
+	case reflect.String:
+		if str, ok := value.(string); ok {
+			v.validateString(path, str, rules)
+		} else {
+			v.add(path, ReasonInvalidType)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := value.(json.Number)
+		if !ok {
+			v.add(path, ReasonInvalidType)
+			return
+		}
+		intValue, err := number.Int64()
+		if err != nil {
+			v.add(path, ReasonInvalidType)
+			return
+		}
+		v.validateInt(path, intValue, valueType.Bits(), rules)
+	case reflect.Bool:
+		if flag, ok := value.(bool); !ok {
+			v.add(path, ReasonInvalidType)
+		} else if rules.empty && flag {
+			v.add(path, ReasonNotAllowed)
+		}
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			v.add(path, ReasonInvalidType)
+			return
+		}
+		v.validateSlice(path, items, valueType.Elem(), rules, itemTag)
+	case reflect.Map:
+		items, ok := value.(map[string]interface{})
+		if !ok {
+			v.add(path, ReasonInvalidType)
+			return
+		}
+		keys := make([]string, 0, len(items))
+		for key := range items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			v.validateString(path+"."+key, key, fieldRules{})
+			v.validateValue(path+"."+key, items[key], valueType.Elem(), fieldRules{}, "")
+		}
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.add(path, ReasonInvalidType)
+			return
+		}
+		if rules.empty {
+			v.add(path, ReasonNotAllowed)
+			return
+		}
+		v.validateStruct(path, object, valueType)
+	case reflect.Ptr:
+		v.validateValue(path, value, valueType.Elem(), rules, itemTag)
+	}
+}
+
+func (v *RequestValidator) validateSlice(path string, items []interface{}, itemType reflect.Type, rules fieldRules, itemTag string) {
+	count := int64(len(items))
+	if rules.required && count == 0 {
+		v.add(path, ReasonRequired)
+	} else if (rules.min != nil && count < *rules.min) || (rules.max != nil && count > *rules.max) {
+		v.add(path, ReasonOutOfRange)
+		return
+	}
+
+	itemRules := parseFieldRules(itemTag)
+	seen := make(map[string]bool)
+	for i, item := range items {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		v.validateValue(itemPath, item, itemType, itemRules, "")
+		if rules.unique == "" {
+			continue
+		}
+		key := item
+		if object, ok := item.(map[string]interface{}); ok && rules.unique != "." {
+			key = object[rules.unique]
+		}
+		if keyAsString := fmt.Sprint(key); seen[keyAsString] {
+			v.add(itemPath, ReasonDuplicate)
+		} else {
+			seen[keyAsString] = true
+		}
+	}
+}
+
+func (v *RequestValidator) validateStruct(path string, object map[string]interface{}, structType reflect.Type) {
+	known := make(map[string]bool)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		known[name] = true
+
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		value, ok := object[name]
+		if !ok || value == nil {
+			if !strings.Contains(field.Tag.Get("metadata"), "optional") {
+				v.add(fieldPath, ReasonRequired)
+			}
+			continue
+		}
+		v.validateValue(fieldPath, value, field.Type, parseFieldRules(field.Tag.Get("validate")), field.Tag.Get("item"))
+	}
+
+	// 按字段名排序，保证错误顺序一致
+	unknown := []string{}
+	for name := range object {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		v.add(fieldPath, ReasonUnknownField)
+	}
+}
+
+// 校验一个位置参数，结构体和数组参数为 JSON 字符串
+func (v *RequestValidator) validateParam(path, param string, paramType reflect.Type, rule string) {
+	rules := parseFieldRules(rule)
+	switch paramType.Kind() {
+	case reflect.String:
+		if rule == "" {
+			rules = parseFieldRules(nameRule)
+		}
+		v.validateString(path, param, rules)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		intValue, err := strconv.ParseInt(param, 10, paramType.Bits())
+		if err != nil {
+			v.add(path, ReasonInvalidType)
+			return
+		}
+		v.validateInt(path, intValue, paramType.Bits(), rules)
+	case reflect.Bool:
+		if _, err := strconv.ParseBool(param); err != nil {
+			v.add(path, ReasonInvalidType)
+		}
+	default:
+		decoder := json.NewDecoder(bytes.NewReader([]byte(param)))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil || decoder.More() {
+			v.add(path, ReasonInvalidJSON)
+			return
+		}
+		v.validateValue(path, value, paramType, rules, "")
+	}
+}
+
+// 生成合约的参数校验函数，作为 contractapi BeforeTransaction 在参数转换前执行
+func NewRequestValidator(contractName string, contract contractapi.ContractInterface) func(ctx contractapi.TransactionContextInterface) error {
+	contractType := reflect.TypeOf(contract)
+	return func(ctx contractapi.TransactionContextInterface) error {
+		function, params := ctx.GetStub().GetFunctionAndParameters()
+		if index := strings.LastIndex(function, ":"); index >= 0 {
+			function = function[index+1:]
+		}
+		if function == "" {
+			return nil
+		}
+		function = strings.ToUpper(function[:1]) + function[1:]
+
+		// 交易不存在或参数个数不符时由 contractapi 返回错误
+		method, ok := contractType.MethodByName(function)
+		if !ok || method.Type.NumIn()-2 != len(params) {
+			return nil
+		}
+
+		rules := paramRules[contractName+":"+function]
+		validator := RequestValidator{}
+		for i, param := range params {
+			rule := ""
+			if i < len(rules) {
+				rule = rules[i]
+			}
+			validator.validateParam(fmt.Sprintf("param%d", i), param, method.Type.In(i+2), rule)
+		}
+		return validator.error()
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+/*
+ * 区块解析：
+ * 1. 只处理背书交易，跳过校验失败的交易
+ * 2. 按链码名称和事件名称过滤合约事件
+ */
+
+type BlockEvent struct {
+	BlockNumber uint64
+	TxIndex     int
+	TxID        string
+	Event       *TokenEvent
+}
+
+func ParseBlockEvents(block *common.Block, chaincodeName string) ([]BlockEvent, error) {
+	if block.Header == nil || block.Data == nil {
+		return nil, fmt.Errorf("invalid block, header or data is empty")
+	}
+	blockNumber := block.Header.Number
+
+	var txFilter []byte
+	if block.Metadata != nil && len(block.Metadata.Metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		txFilter = block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+
+	var events []BlockEvent
+	for txIndex, envelopeBytes := range block.Data.Data {
+		if txIndex < len(txFilter) && peer.TxValidationCode(txFilter[txIndex]) != peer.TxValidationCode_VALID {
+			continue
+		}
+
+		chaincodeEvent, txID, err := getChaincodeEvent(envelopeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("block %d tx %d: %s", blockNumber, txIndex, err.Error())
+		}
+		if chaincodeEvent == nil || chaincodeEvent.ChaincodeId != chaincodeName || chaincodeEvent.EventName != TokenEventName {
+			continue
+		}
+
+		event, err := ParseTokenEvent(chaincodeEvent.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("block %d tx %s: %s", blockNumber, txID, err.Error())
+		}
+		events = append(events, BlockEvent{
+			BlockNumber: blockNumber,
+			TxIndex:     txIndex,
+			TxID:        txID,
+			Event:       event,
+		})
+	}
+	return events, nil
+}
+
+// 返回背书交易中的合约事件，非背书交易或无事件时返回 nil
+func getChaincodeEvent(envelopeBytes []byte) (*peer.ChaincodeEvent, string, error) {
+	envelope := &common.Envelope{}
+	if err := proto.Unmarshal(envelopeBytes, envelope); err != nil {
+		return nil, "", err
+	}
+	payload := &common.Payload{}
+	if err := proto.Unmarshal(envelope.Payload, payload); err != nil {
+		return nil, "", err
+	}
+	if payload.Header == nil {
+		return nil, "", fmt.Errorf("payload header is empty")
+	}
+	channelHeader := &common.ChannelHeader{}
+	if err := proto.Unmarshal(payload.Header.ChannelHeader, channelHeader); err != nil {
+		return nil, "", err
+	}
+	if common.HeaderType(channelHeader.Type) != common.HeaderType_ENDORSER_TRANSACTION {
+		return nil, channelHeader.TxId, nil
+	}
+
+	transaction := &peer.Transaction{}
+	if err := proto.Unmarshal(payload.Data, transaction); err != nil {
+		return nil, "", err
+	}
+	for _, action := range transaction.Actions {
+		actionPayload := &peer.ChaincodeActionPayload{}
+		if err := proto.Unmarshal(action.Payload, actionPayload); err != nil {
+			return nil, "", err
+		}
+		if actionPayload.Action == nil {
+			continue
+		}
+		responsePayload := &peer.ProposalResponsePayload{}
+		if err := proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, responsePayload); err != nil {
+			return nil, "", err
+		}
+		chaincodeAction := &peer.ChaincodeAction{}
+		if err := proto.Unmarshal(responsePayload.Extension, chaincodeAction); err != nil {
+			return nil, "", err
+		}
+		if len(chaincodeAction.Events) == 0 {
+			continue
+		}
+		chaincodeEvent := &peer.ChaincodeEvent{}
+		if err := proto.Unmarshal(chaincodeAction.Events, chaincodeEvent); err != nil {
+			return nil, "", err
+		}
+		return chaincodeEvent, channelHeader.TxId, nil
+	}
+	return nil, channelHeader.TxId, nil
+}
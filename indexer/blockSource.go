@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"github.com/hyperledger/fabric-protos-go/common"
+)
+
+/*
+ * 区块来源接口：
+ * 1. PeerSource 从节点实时订阅区块
+ * 2. FileSource 从区块文件回放，用于重建和测试
+ */
+
+// 区块处理函数，返回错误时停止投递
+type BlockHandler func(block *common.Block) error
+
+type BlockSource interface {
+	// 从区块号 start 开始按顺序投递区块，直到 ctx 取消、来源结束或 handler 返回错误
+	Deliver(ctx context.Context, start uint64, handler BlockHandler) error
+	Close() error
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/*
+ * 合约事件结构定义，与 chaincode/chaincode_token/EVENTS.md 保持一致
+ */
+
+const TokenEventName = "TokenEvent"
+const TokenEventVersion = 1
+
+const (
+	EventAccountCreated     = "account.created"
+	EventAccountFrozen      = "account.frozen"
+	EventAccountDeleted     = "account.deleted"
+	EventTokenMinted        = "token.minted"
+	EventTokenBurned        = "token.burned"
+	EventDataEvidence       = "data.evidence"
+	EventTitleChanged       = "title.changed"
+	EventDataPurchased      = "data.purchased"
+	EventAccountMigrated    = "account.migrated"
+	EventAccountClosed      = "account.closed"
+	EventOrgRegistered      = "org.registered"
+	EventOrgFunded          = "org.funded"
+	EventTokenTransferred   = "token.transferred"
+	EventLicenseMinted      = "license.minted"
+	EventLicenseTransferred = "license.transferred"
+	EventAuctionBid         = "auction.bid"
+	EventBountyBudget       = "bounty.budget"
+)
+
+type TokenEvent struct {
+	Version int         `json:"version"`
+	TxID    string      `json:"txId"`
+	Time    int64       `json:"time"`
+	Items   []EventItem `json:"items"`
+}
+
+type EventItem struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type AccountEventPayload struct {
+	Name    string `json:"name"`
+	Type    int    `json:"type"`
+	OrgName string `json:"orgName"`
+	Address string `json:"address"`
+	Frozen  bool   `json:"frozen"`
+}
+
+type TokenEventPayload struct {
+	Name    string `json:"name"`
+	Amount  int64  `json:"amount"`
+	Balance int64  `json:"balance"`
+}
+
+type AccountClosedEventPayload struct {
+	Name             string `json:"name"`
+	Successor        string `json:"successor"`
+	Amount           int64  `json:"amount"`
+	SuccessorBalance int64  `json:"successorBalance"`
+}
+
+type AccountMigratedEventPayload struct {
+	Name       string `json:"name"`
+	TokenDelta int64  `json:"tokenDelta"`
+	Balance    int64  `json:"balance"`
+}
+
+type DataEvidenceEventPayload struct {
+	Type  int    `json:"type"`
+	Owner string `json:"owner"`
+	Title string `json:"title"`
+	Hash  string `json:"hash"`
+	Size  int    `json:"size"`
+}
+
+type TitlePrice struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Value int `json:"value"`
+}
+
+type TitleEventPayload struct {
+	Type   int        `json:"type"`
+	Owner  string     `json:"owner"`
+	Title  string     `json:"title"`
+	Shelve bool       `json:"shelve"`
+	Price  TitlePrice `json:"price"`
+}
+
+type PurchaseEventPayload struct {
+	Buyer string `json:"buyer"`
+	Type  int    `json:"type"`
+	Owner string `json:"owner"`
+	Title string `json:"title"`
+	Hash  string `json:"hash"`
+	Price int    `json:"price"`
+	Size  int    `json:"size"`
+	Time  int64  `json:"time"`
+	Org   string `json:"org"`
+}
+
+type LicenseEventPayload struct {
+	ID     string `json:"id"`
+	Holder string `json:"holder"`
+	Type   int    `json:"type"`
+	Owner  string `json:"owner"`
+	Title  string `json:"title"`
+	Hash   string `json:"hash"`
+}
+
+type LicenseTransferEventPayload struct {
+	ID      string `json:"id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Owner   string `json:"owner"`
+	Price   int64  `json:"price"`
+	Royalty int64  `json:"royalty"`
+}
+
+type AuctionBidEventPayload struct {
+	Auction string `json:"auction"`
+	Bidder  string `json:"bidder"`
+	Status  string `json:"status"`
+	Deposit int64  `json:"deposit"`
+	Balance int64  `json:"balance"`
+}
+
+type BountyBudgetEventPayload struct {
+	Bounty  string `json:"bounty"`
+	Buyer   string `json:"buyer"`
+	Status  string `json:"status"`
+	Budget  int64  `json:"budget"`
+	Balance int64  `json:"balance"`
+}
+
+type TokenTransferEventPayload struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Amount      int64  `json:"amount"`
+	FromBalance int64  `json:"fromBalance"`
+	ToBalance   int64  `json:"toBalance"`
+}
+
+type OrgEventPayload struct {
+	Name  string `json:"name"`
+	MSPID string `json:"mspId"`
+}
+
+type OrgTokenEventPayload struct {
+	Org            string `json:"org"`
+	Amount         int64  `json:"amount"`
+	Balance        int64  `json:"balance"`
+	Account        string `json:"account"`
+	AccountBalance int64  `json:"accountBalance"`
+}
+
+func ParseTokenEvent(payload []byte) (*TokenEvent, error) {
+	var event TokenEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("failed to parse token event: %s", err.Error())
+	}
+	if event.Version > TokenEventVersion {
+		return nil, fmt.Errorf("unsupported token event version %d", event.Version)
+	}
+	return &event, nil
+}
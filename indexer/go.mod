@@ -0,0 +1,10 @@
+module github.com/commis/fabric-network/indexer
+
+go 1.13
+
+require (
+	github.com/golang/protobuf v1.3.2
+	github.com/hyperledger/fabric-protos-go v0.0.0-20200124220212-e9cfc186ba7b
+	github.com/mattn/go-sqlite3 v1.14.22
+	google.golang.org/grpc v1.23.0
+)
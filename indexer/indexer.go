@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"log"
+)
+
+/*
+ * 事件索引：从区块来源读取区块，解析合约事件并写入本地查询库
+ */
+
+type Indexer struct {
+	source        BlockSource
+	store         *Store
+	chaincodeName string
+}
+
+func NewIndexer(source BlockSource, store *Store, chaincodeName string) *Indexer {
+	return &Indexer{source: source, store: store, chaincodeName: chaincodeName}
+}
+
+// 从上次处理进度的下一个区块开始投影，直到来源结束或 ctx 取消
+func (i *Indexer) Run(ctx context.Context) error {
+	var start uint64
+	if checkpoint, ok, err := i.store.Checkpoint(); err != nil {
+		return fmt.Errorf("failed to load checkpoint: %s", err.Error())
+	} else if ok {
+		start = checkpoint + 1
+	}
+	log.Printf("indexer start from block %d \n", start)
+
+	next := start
+	return i.source.Deliver(ctx, start, func(block *common.Block) error {
+		blockNumber := block.Header.Number
+		if blockNumber != next {
+			return fmt.Errorf("unexpected block %d, expecting %d", blockNumber, next)
+		}
+
+		events, err := ParseBlockEvents(block, i.chaincodeName)
+		if err != nil {
+			return err
+		}
+		if err := i.store.ApplyBlock(blockNumber, events); err != nil {
+			return fmt.Errorf("failed to apply block %d: %s", blockNumber, err.Error())
+		}
+		if len(events) > 0 {
+			log.Printf("indexed block %d, %d token events \n", blockNumber, len(events))
+		}
+		next++
+		return nil
+	})
+}
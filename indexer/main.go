@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/*
+ * 数据交易链下索引服务：
+ * 订阅 token 合约事件，投影到本地 SQLite 查询库，并提供 HTTP 查询
+ */
+
+func main() {
+	sourceType := flag.String("source", "peer", "block source: peer or file")
+	blockFile := flag.String("block-file", "", "block file to replay when source is file")
+	recordFile := flag.String("record", "", "append blocks received from peer to this block file")
+	peerAddress := flag.String("peer", "localhost:7051", "peer address")
+	channel := flag.String("channel", "mychannel", "channel name")
+	tlsCACert := flag.String("tls-ca", "", "peer TLS CA certificate, empty to disable TLS")
+	tlsServerName := flag.String("tls-server-name", "", "override peer TLS server name")
+	mspID := flag.String("msp-id", "Org1MSP", "client MSP ID")
+	certPath := flag.String("cert", "", "client certificate (PEM)")
+	keyPath := flag.String("key", "", "client private key (PEM)")
+	chaincodeName := flag.String("chaincode", "mycc", "token chaincode name")
+	dbPath := flag.String("db", "indexer.db", "SQLite database path")
+	listen := flag.String("listen", ":8080", "HTTP listen address, empty to disable")
+	flag.Parse()
+
+	store, err := OpenStore(*dbPath)
+	if err != nil {
+		log.Fatalf("failed to open store: %s", err.Error())
+	}
+	defer store.Close()
+
+	var source BlockSource
+	switch *sourceType {
+	case "file":
+		if source, err = NewFileSource(*blockFile); err != nil {
+			log.Fatalf("failed to open block file: %s", err.Error())
+		}
+	case "peer":
+		signer, err := NewSigner(*mspID, *certPath, *keyPath)
+		if err != nil {
+			log.Fatalf("failed to load client identity: %s", err.Error())
+		}
+		var writer *BlockFileWriter
+		if *recordFile != "" {
+			if writer, err = NewBlockFileWriter(*recordFile); err != nil {
+				log.Fatalf("failed to open record file: %s", err.Error())
+			}
+		}
+		config := PeerConfig{
+			Address:       *peerAddress,
+			Channel:       *channel,
+			TLSCACert:     *tlsCACert,
+			TLSServerName: *tlsServerName,
+		}
+		if source, err = NewPeerSource(config, signer, writer); err != nil {
+			log.Fatalf("failed to create peer source: %s", err.Error())
+		}
+	default:
+		log.Fatalf("unknown block source %s", *sourceType)
+	}
+	defer source.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if *listen != "" {
+		server := &http.Server{Addr: *listen, Handler: NewServer(store).Handler()}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("http server error: %s", err.Error())
+			}
+		}()
+		defer server.Close()
+	}
+
+	if err := NewIndexer(source, store, *chaincodeName).Run(ctx); err != nil && err != context.Canceled {
+		log.Fatalf("indexer stopped: %s", err.Error())
+	}
+	log.Printf("indexer finished \n")
+
+	// 回放结束后继续提供查询，直到收到退出信号
+	if *listen != "" && ctx.Err() == nil {
+		<-ctx.Done()
+	}
+}
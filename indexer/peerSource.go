@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/orderer"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"math"
+)
+
+/*
+ * 节点区块订阅：通过 Deliver 服务获取完整区块（含合约事件内容）
+ */
+
+type PeerConfig struct {
+	Address       string
+	Channel       string
+	TLSCACert     string /*为空时不启用TLS*/
+	TLSServerName string
+}
+
+type PeerSource struct {
+	config PeerConfig
+	signer *Signer
+	conn   *grpc.ClientConn
+	writer *BlockFileWriter /*不为空时录制收到的区块*/
+}
+
+func NewPeerSource(config PeerConfig, signer *Signer, writer *BlockFileWriter) (*PeerSource, error) {
+	var dialOption grpc.DialOption
+	if config.TLSCACert != "" {
+		creds, err := credentials.NewClientTLSFromFile(config.TLSCACert, config.TLSServerName)
+		if err != nil {
+			return nil, err
+		}
+		dialOption = grpc.WithTransportCredentials(creds)
+	} else {
+		dialOption = grpc.WithInsecure()
+	}
+
+	conn, err := grpc.Dial(config.Address, dialOption, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(100*1024*1024)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect peer %s: %s", config.Address, err.Error())
+	}
+	return &PeerSource{config: config, signer: signer, conn: conn, writer: writer}, nil
+}
+
+func (p *PeerSource) Deliver(ctx context.Context, start uint64, handler BlockHandler) error {
+	envelope, err := p.seekEnvelope(start)
+	if err != nil {
+		return err
+	}
+
+	stream, err := peer.NewDeliverClient(p.conn).Deliver(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(envelope); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		switch t := response.Type.(type) {
+		case *peer.DeliverResponse_Block:
+			if p.writer != nil {
+				if err := p.writer.Write(t.Block); err != nil {
+					return fmt.Errorf("failed to record block: %s", err.Error())
+				}
+			}
+			if err := handler(t.Block); err != nil {
+				return err
+			}
+		case *peer.DeliverResponse_Status:
+			if t.Status == common.Status_SUCCESS {
+				return nil
+			}
+			return fmt.Errorf("deliver service returned status %s", t.Status.String())
+		default:
+			return fmt.Errorf("unexpected deliver response %T", t)
+		}
+	}
+}
+
+func (p *PeerSource) Close() error {
+	if p.writer != nil {
+		_ = p.writer.Close()
+	}
+	return p.conn.Close()
+}
+
+func (p *PeerSource) seekEnvelope(start uint64) (*common.Envelope, error) {
+	seekInfo := &orderer.SeekInfo{
+		Start: &orderer.SeekPosition{
+			Type: &orderer.SeekPosition_Specified{Specified: &orderer.SeekSpecified{Number: start}},
+		},
+		Stop: &orderer.SeekPosition{
+			Type: &orderer.SeekPosition_Specified{Specified: &orderer.SeekSpecified{Number: math.MaxUint64}},
+		},
+		Behavior: orderer.SeekInfo_BLOCK_UNTIL_READY,
+	}
+	seekInfoBytes, err := proto.Marshal(seekInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, txID, err := p.signer.NewNonceAndTxID()
+	if err != nil {
+		return nil, err
+	}
+	creator, err := p.signer.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{
+		Type:      int32(common.HeaderType_DELIVER_SEEK_INFO),
+		ChannelId: p.config.Channel,
+		TxId:      txID,
+		Timestamp: ptypes.TimestampNow(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signatureHeader, err := proto.Marshal(&common.SignatureHeader{Creator: creator, Nonce: nonce})
+	if err != nil {
+		return nil, err
+	}
+
+	payloadBytes, err := proto.Marshal(&common.Payload{
+		Header: &common.Header{ChannelHeader: channelHeader, SignatureHeader: signatureHeader},
+		Data:   seekInfoBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	signature, err := p.signer.Sign(payloadBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &common.Envelope{Payload: payloadBytes, Signature: signature}, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+ * 本地查询库读接口
+ */
+
+type AccountView struct {
+	Name    string `json:"name"`
+	Type    int    `json:"type"`
+	OrgName string `json:"orgName"`
+	Address string `json:"address"`
+	Frozen  bool   `json:"frozen"`
+	Deleted bool   `json:"deleted"`
+	Token   int64  `json:"token"`
+}
+
+type TitleView struct {
+	Type   int        `json:"type"`
+	Owner  string     `json:"owner"`
+	Title  string     `json:"title"`
+	Shelve bool       `json:"shelve"`
+	Price  TitlePrice `json:"price"`
+}
+
+type EvidenceView struct {
+	Type  int    `json:"type"`
+	Owner string `json:"owner"`
+	Title string `json:"title"`
+	Hash  string `json:"hash"`
+	Size  int    `json:"size"`
+}
+
+type PurchaseView struct {
+	TxID        string `json:"txId"`
+	BlockNumber uint64 `json:"blockNumber"`
+	Buyer       string `json:"buyer"`
+	Type        int    `json:"type"`
+	Owner       string `json:"owner"`
+	Title       string `json:"title"`
+	Hash        string `json:"hash"`
+	Price       int    `json:"price"`
+	Size        int    `json:"size"`
+	Time        int64  `json:"time"`
+}
+
+type LicenseView struct {
+	ID     string `json:"id"`
+	Holder string `json:"holder"`
+	Type   int    `json:"type"`
+	Owner  string `json:"owner"`
+	Title  string `json:"title"`
+	Hash   string `json:"hash"`
+}
+
+// 查询条件，字段为空时不作为过滤条件
+type QueryFilter struct {
+	ID     string
+	Name   string
+	Type   *int
+	Owner  string
+	Title  string
+	Buyer  string
+	Holder string
+	Shelve *bool
+	From   int64
+	To     int64
+}
+
+type whereBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (w *whereBuilder) add(condition string, arg interface{}) {
+	w.conditions = append(w.conditions, condition)
+	w.args = append(w.args, arg)
+}
+
+func (w *whereBuilder) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+func (s *Store) QueryAccounts(filter QueryFilter) ([]AccountView, error) {
+	where := &whereBuilder{}
+	if filter.Name != "" {
+		where.add("name = ?", filter.Name)
+	}
+	rows, err := s.db.Query(`SELECT name, type, org_name, address, frozen, deleted, token FROM accounts`+
+		where.String()+` ORDER BY name`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []AccountView{}
+	for rows.Next() {
+		var account AccountView
+		if err := rows.Scan(&account.Name, &account.Type, &account.OrgName, &account.Address,
+			&account.Frozen, &account.Deleted, &account.Token); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func (s *Store) QueryTitles(filter QueryFilter) ([]TitleView, error) {
+	where := &whereBuilder{}
+	addDataFilter(where, filter)
+	if filter.Shelve != nil {
+		where.add("shelve = ?", *filter.Shelve)
+	}
+	rows, err := s.db.Query(`SELECT type, owner, title, shelve, price_min, price_max, price_value FROM titles`+
+		where.String()+` ORDER BY type, owner, title`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []TitleView{}
+	for rows.Next() {
+		var title TitleView
+		if err := rows.Scan(&title.Type, &title.Owner, &title.Title, &title.Shelve,
+			&title.Price.Min, &title.Price.Max, &title.Price.Value); err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
+
+func (s *Store) QueryEvidence(filter QueryFilter) ([]EvidenceView, error) {
+	where := &whereBuilder{}
+	addDataFilter(where, filter)
+	rows, err := s.db.Query(`SELECT type, owner, title, hash, size FROM evidence`+
+		where.String()+` ORDER BY type, owner, title, hash`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	evidence := []EvidenceView{}
+	for rows.Next() {
+		var item EvidenceView
+		if err := rows.Scan(&item.Type, &item.Owner, &item.Title, &item.Hash, &item.Size); err != nil {
+			return nil, err
+		}
+		evidence = append(evidence, item)
+	}
+	return evidence, rows.Err()
+}
+
+func (s *Store) QueryPurchases(filter QueryFilter) ([]PurchaseView, error) {
+	where := &whereBuilder{}
+	addDataFilter(where, filter)
+	if filter.Buyer != "" {
+		where.add("buyer = ?", filter.Buyer)
+	}
+	if filter.From > 0 {
+		where.add("time >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		where.add("time <= ?", filter.To)
+	}
+	rows, err := s.db.Query(`SELECT tx_id, block_number, buyer, type, owner, title, hash, price, size, time
+		FROM purchases`+where.String()+` ORDER BY block_number, tx_id, hash`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []PurchaseView{}
+	for rows.Next() {
+		var purchase PurchaseView
+		if err := rows.Scan(&purchase.TxID, &purchase.BlockNumber, &purchase.Buyer, &purchase.Type,
+			&purchase.Owner, &purchase.Title, &purchase.Hash, &purchase.Price, &purchase.Size,
+			&purchase.Time); err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, purchase)
+	}
+	return purchases, rows.Err()
+}
+
+func (s *Store) QueryLicenses(filter QueryFilter) ([]LicenseView, error) {
+	where := &whereBuilder{}
+	addDataFilter(where, filter)
+	if filter.ID != "" {
+		where.add("id = ?", filter.ID)
+	}
+	if filter.Holder != "" {
+		where.add("holder = ?", filter.Holder)
+	}
+	rows, err := s.db.Query(`SELECT id, holder, type, owner, title, hash FROM licenses`+
+		where.String()+` ORDER BY holder, type, owner, title, hash, id`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	licenses := []LicenseView{}
+	for rows.Next() {
+		var license LicenseView
+		if err := rows.Scan(&license.ID, &license.Holder, &license.Type, &license.Owner,
+			&license.Title, &license.Hash); err != nil {
+			return nil, err
+		}
+		licenses = append(licenses, license)
+	}
+	return licenses, rows.Err()
+}
+
+func addDataFilter(where *whereBuilder, filter QueryFilter) {
+	if filter.Type != nil {
+		where.add("type = ?", *filter.Type)
+	}
+	if filter.Owner != "" {
+		where.add("owner = ?", filter.Owner)
+	}
+	if filter.Title != "" {
+		where.add("title = ?", filter.Title)
+	}
+}
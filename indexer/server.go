@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+/*
+ * HTTP 查询接口，查询参数均为可选过滤条件：
+ * GET /accounts   ?name=
+ * GET /titles     ?type=&owner=&title=&shelve=
+ * GET /evidence   ?type=&owner=&title=
+ * GET /purchases  ?type=&owner=&title=&buyer=&from=&to=
+ * GET /licenses   ?id=&holder=&type=&owner=&title=
+ * GET /checkpoint
+ */
+
+type Server struct {
+	store *Store
+}
+
+func NewServer(store *Store) *Server {
+	return &Server{store: store}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/accounts", s.handle(func(filter QueryFilter) (interface{}, error) {
+		return s.store.QueryAccounts(filter)
+	}))
+	mux.HandleFunc("/titles", s.handle(func(filter QueryFilter) (interface{}, error) {
+		return s.store.QueryTitles(filter)
+	}))
+	mux.HandleFunc("/evidence", s.handle(func(filter QueryFilter) (interface{}, error) {
+		return s.store.QueryEvidence(filter)
+	}))
+	mux.HandleFunc("/purchases", s.handle(func(filter QueryFilter) (interface{}, error) {
+		return s.store.QueryPurchases(filter)
+	}))
+	mux.HandleFunc("/licenses", s.handle(func(filter QueryFilter) (interface{}, error) {
+		return s.store.QueryLicenses(filter)
+	}))
+	mux.HandleFunc("/checkpoint", s.handle(func(filter QueryFilter) (interface{}, error) {
+		blockNumber, ok, err := s.store.Checkpoint()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"indexed": ok, "blockNumber": blockNumber}, nil
+	}))
+	return mux
+}
+
+func (s *Server) handle(query func(filter QueryFilter) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		filter, err := parseFilter(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		result, err := query(filter)
+		if err != nil {
+			log.Printf("query %s failed: %s \n", r.URL.String(), err.Error())
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func parseFilter(r *http.Request) (QueryFilter, error) {
+	values := r.URL.Query()
+	filter := QueryFilter{
+		ID:     values.Get("id"),
+		Name:   values.Get("name"),
+		Owner:  values.Get("owner"),
+		Title:  values.Get("title"),
+		Buyer:  values.Get("buyer"),
+		Holder: values.Get("holder"),
+	}
+	if value := values.Get("type"); value != "" {
+		dataType, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid type %s", value)
+		}
+		filter.Type = &dataType
+	}
+	if value := values.Get("shelve"); value != "" {
+		shelve, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid shelve %s", value)
+		}
+		filter.Shelve = &shelve
+	}
+	var err error
+	if value := values.Get("from"); value != "" {
+		if filter.From, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return filter, fmt.Errorf("invalid from %s", value)
+		}
+	}
+	if value := values.Get("to"); value != "" {
+		if filter.To, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return filter, fmt.Errorf("invalid to %s", value)
+		}
+	}
+	return filter, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("failed to write response: %s \n", err.Error())
+	}
+}
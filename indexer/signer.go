@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"io/ioutil"
+	"math/big"
+)
+
+/*
+ * 客户端身份签名，用于向节点发送区块订阅请求
+ */
+
+type Signer struct {
+	mspID   string
+	certPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func NewSigner(mspID, certPath, keyPath string) (*Signer, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key %s", keyPath)
+	}
+	var key *ecdsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		ecKey, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key %s is not an ECDSA key", keyPath)
+		}
+		key = ecKey
+	} else if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %s", keyPath, err.Error())
+	}
+
+	return &Signer{mspID: mspID, certPEM: certPEM, key: key}, nil
+}
+
+func (s *Signer) Serialize() ([]byte, error) {
+	return proto.Marshal(&msp.SerializedIdentity{Mspid: s.mspID, IdBytes: s.certPEM})
+}
+
+// ECDSA 签名，S 值取低位以满足 Fabric 校验要求
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	r, sigS, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	halfOrder := new(big.Int).Rsh(curveOrder(s.key.Curve), 1)
+	if sigS.Cmp(halfOrder) > 0 {
+		sigS.Sub(curveOrder(s.key.Curve), sigS)
+	}
+	return asn1.Marshal(ecdsaSignature{R: r, S: sigS})
+}
+
+func (s *Signer) NewNonceAndTxID() ([]byte, string, error) {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+	creator, err := s.Serialize()
+	if err != nil {
+		return nil, "", err
+	}
+	digest := sha256.Sum256(append(append([]byte{}, nonce...), creator...))
+	return nonce, hex.EncodeToString(digest[:]), nil
+}
+
+func curveOrder(curve elliptic.Curve) *big.Int {
+	return curve.Params().N
+}
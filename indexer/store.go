@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+ * 本地查询库：
+ * 1. 账户、标签、存证、交易记录、许可证投影
+ * 2. 区块处理进度，与投影数据在同一事务内提交
+ */
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS checkpoint (
+		id           INTEGER PRIMARY KEY CHECK (id = 1),
+		block_number INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS accounts (
+		name     TEXT PRIMARY KEY,
+		type     INTEGER NOT NULL DEFAULT 0,
+		org_name TEXT NOT NULL DEFAULT '',
+		address  TEXT NOT NULL DEFAULT '',
+		frozen   INTEGER NOT NULL DEFAULT 0,
+		deleted  INTEGER NOT NULL DEFAULT 0,
+		token    INTEGER NOT NULL DEFAULT 0,
+		tx_id    TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS titles (
+		type        INTEGER NOT NULL,
+		owner       TEXT NOT NULL,
+		title       TEXT NOT NULL,
+		shelve      INTEGER NOT NULL,
+		price_min   INTEGER NOT NULL,
+		price_max   INTEGER NOT NULL,
+		price_value INTEGER NOT NULL,
+		tx_id       TEXT NOT NULL,
+		PRIMARY KEY (type, owner, title)
+	)`,
+	`CREATE TABLE IF NOT EXISTS evidence (
+		type  INTEGER NOT NULL,
+		owner TEXT NOT NULL,
+		title TEXT NOT NULL,
+		hash  TEXT NOT NULL,
+		size  INTEGER NOT NULL,
+		tx_id TEXT NOT NULL,
+		PRIMARY KEY (type, owner, title, hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS purchases (
+		tx_id        TEXT NOT NULL,
+		block_number INTEGER NOT NULL,
+		buyer        TEXT NOT NULL,
+		type         INTEGER NOT NULL,
+		owner        TEXT NOT NULL,
+		title        TEXT NOT NULL,
+		hash         TEXT NOT NULL,
+		price        INTEGER NOT NULL,
+		size         INTEGER NOT NULL,
+		time         INTEGER NOT NULL,
+		PRIMARY KEY (tx_id, hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS orgs (
+		name   TEXT PRIMARY KEY,
+		msp_id TEXT NOT NULL,
+		token  INTEGER NOT NULL DEFAULT 0,
+		tx_id  TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS licenses (
+		id     TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		type   INTEGER NOT NULL,
+		owner  TEXT NOT NULL,
+		title  TEXT NOT NULL,
+		hash   TEXT NOT NULL,
+		tx_id  TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS licenses_holder ON licenses (holder)`,
+	`CREATE INDEX IF NOT EXISTS purchases_buyer ON purchases (buyer, time)`,
+	`CREATE INDEX IF NOT EXISTS purchases_owner ON purchases (owner, time)`,
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite 单写，避免并发写锁冲突
+	db.SetMaxOpenConns(1)
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to init schema: %s", err.Error())
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// 返回已处理的最新区块号，尚未处理任何区块时 ok 为 false
+func (s *Store) Checkpoint() (blockNumber uint64, ok bool, err error) {
+	err = s.db.QueryRow(`SELECT block_number FROM checkpoint WHERE id = 1`).Scan(&blockNumber)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return blockNumber, true, nil
+}
+
+// 在一个事务内应用区块事件并更新处理进度
+func (s *Store) ApplyBlock(blockNumber uint64, events []BlockEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, blockEvent := range events {
+		for _, item := range blockEvent.Event.Items {
+			if err := applyItem(tx, blockEvent, item); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("tx %s event %s: %s", blockEvent.TxID, item.Type, err.Error())
+			}
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO checkpoint (id, block_number) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET block_number = excluded.block_number`, blockNumber); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func applyItem(tx *sql.Tx, blockEvent BlockEvent, item EventItem) error {
+	txID := blockEvent.TxID
+	switch item.Type {
+	case EventAccountCreated:
+		var payload AccountEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO accounts (name, type, org_name, address, frozen, deleted, token, tx_id)
+			VALUES (?, ?, ?, ?, ?, 0, 0, ?)
+			ON CONFLICT (name) DO UPDATE SET type = excluded.type, org_name = excluded.org_name,
+				address = excluded.address, frozen = excluded.frozen, deleted = 0, token = 0, tx_id = excluded.tx_id`,
+			payload.Name, payload.Type, payload.OrgName, payload.Address, payload.Frozen, txID)
+		return err
+	case EventAccountFrozen:
+		var payload AccountEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE accounts SET frozen = ?, tx_id = ? WHERE name = ?`, payload.Frozen, txID, payload.Name)
+		return err
+	case EventAccountDeleted:
+		var payload AccountEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE accounts SET deleted = 1, tx_id = ? WHERE name = ?`, txID, payload.Name)
+		return err
+	case EventTokenMinted, EventTokenBurned:
+		var payload TokenEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE accounts SET token = ?, tx_id = ? WHERE name = ?`, payload.Balance, txID, payload.Name)
+		return err
+	case EventTokenTransferred:
+		var payload TokenTransferEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE accounts SET token = ?, tx_id = ? WHERE name = ?`, payload.FromBalance, txID, payload.From); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE accounts SET token = ?, tx_id = ? WHERE name = ?`, payload.ToBalance, txID, payload.To)
+		return err
+	case EventAccountClosed:
+		var payload AccountClosedEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE accounts SET deleted = 1, token = 0, tx_id = ? WHERE name = ?`, txID, payload.Name); err != nil {
+			return err
+		}
+		if payload.Successor == "" {
+			return nil
+		}
+		_, err := tx.Exec(`UPDATE accounts SET token = ?, tx_id = ? WHERE name = ?`, payload.SuccessorBalance, txID, payload.Successor)
+		return err
+	case EventOrgRegistered:
+		var payload OrgEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT OR REPLACE INTO orgs (name, msp_id, token, tx_id) VALUES (?, ?, 0, ?)`,
+			payload.Name, payload.MSPID, txID)
+		return err
+	case EventOrgFunded:
+		var payload OrgTokenEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE orgs SET token = ?, tx_id = ? WHERE name = ?`, payload.Balance, txID, payload.Org); err != nil {
+			return err
+		}
+		if payload.Account == "" {
+			return nil
+		}
+		_, err := tx.Exec(`UPDATE accounts SET token = ?, tx_id = ? WHERE name = ?`, payload.AccountBalance, txID, payload.Account)
+		return err
+	case EventAccountMigrated:
+		var payload AccountMigratedEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE accounts SET token = ?, tx_id = ? WHERE name = ?`, payload.Balance, txID, payload.Name)
+		return err
+	case EventDataEvidence:
+		var payload DataEvidenceEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO evidence (type, owner, title, hash, size, tx_id) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (type, owner, title, hash) DO UPDATE SET size = excluded.size, tx_id = excluded.tx_id`,
+			payload.Type, payload.Owner, payload.Title, payload.Hash, payload.Size, txID)
+		return err
+	case EventTitleChanged:
+		var payload TitleEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO titles (type, owner, title, shelve, price_min, price_max, price_value, tx_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (type, owner, title) DO UPDATE SET shelve = excluded.shelve, price_min = excluded.price_min,
+				price_max = excluded.price_max, price_value = excluded.price_value, tx_id = excluded.tx_id`,
+			payload.Type, payload.Owner, payload.Title, payload.Shelve,
+			payload.Price.Min, payload.Price.Max, payload.Price.Value, txID)
+		return err
+	case EventDataPurchased:
+		var payload PurchaseEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO purchases
+			(tx_id, block_number, buyer, type, owner, title, hash, price, size, time)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			txID, blockEvent.BlockNumber, payload.Buyer, payload.Type, payload.Owner, payload.Title,
+			payload.Hash, payload.Price, payload.Size, payload.Time); err != nil {
+			return err
+		}
+		// 交易费用：价格 * 数据条数，与合约 transferData 计算一致，指定组织时由组织钱包支付
+		amount := int64(payload.Price) * int64(payload.Size)
+		if payload.Org != "" {
+			if _, err := tx.Exec(`UPDATE orgs SET token = token - ?, tx_id = ? WHERE name = ?`,
+				amount, txID, payload.Org); err != nil {
+				return err
+			}
+		} else if _, err := tx.Exec(`UPDATE accounts SET token = token - ?, tx_id = ? WHERE name = ?`,
+			amount, txID, payload.Buyer); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE accounts SET token = token + ?, tx_id = ? WHERE name = ?`,
+			amount, txID, payload.Owner)
+		return err
+	case EventLicenseMinted:
+		var payload LicenseEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT OR REPLACE INTO licenses (id, holder, type, owner, title, hash, tx_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			payload.ID, payload.Holder, payload.Type, payload.Owner, payload.Title, payload.Hash, txID)
+		return err
+	case EventLicenseTransferred:
+		var payload LicenseTransferEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE licenses SET holder = ?, tx_id = ? WHERE id = ?`,
+			payload.To, txID, payload.ID); err != nil {
+			return err
+		}
+		// 买方支付转售价格，版税支付给数据归属方，其余支付给原持有人
+		if _, err := tx.Exec(`UPDATE accounts SET token = token - ?, tx_id = ? WHERE name = ?`,
+			payload.Price, txID, payload.To); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE accounts SET token = token + ?, tx_id = ? WHERE name = ?`,
+			payload.Royalty, txID, payload.Owner); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE accounts SET token = token + ?, tx_id = ? WHERE name = ?`,
+			payload.Price-payload.Royalty, txID, payload.From)
+		return err
+	case EventAuctionBid:
+		var payload AuctionBidEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		// 锁定或退回出价积分后的账户积分，揭示出价不影响积分
+		if payload.Status != "locked" && payload.Status != "unlocked" {
+			return nil
+		}
+		_, err := tx.Exec(`UPDATE accounts SET token = ?, tx_id = ? WHERE name = ?`, payload.Balance, txID, payload.Bidder)
+		return err
+	case EventBountyBudget:
+		var payload BountyBudgetEventPayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return err
+		}
+		// 锁定或退回悬赏积分后的账户积分
+		_, err := tx.Exec(`UPDATE accounts SET token = ?, tx_id = ? WHERE name = ?`, payload.Balance, txID, payload.Buyer)
+		return err
+	default:
+		// 忽略未知事件类型，保证新版本合约事件不会阻塞索引
+		return nil
+	}
+}